@@ -0,0 +1,126 @@
+// Package apperr defines the error categories shared by the repository and
+// usecase layers and maps them to HTTP and gRPC responses.
+package apperr
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrInvalidID    = errors.New("invalid id")
+	ErrValidation   = errors.New("validation failed")
+	ErrConflict     = errors.New("conflict")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	// ErrTooManyRequests and ErrLocked are usually returned through
+	// WithRetryAfter so clients learn when to try again.
+	ErrTooManyRequests = errors.New("too many requests")
+	ErrLocked          = errors.New("locked")
+)
+
+type kind struct {
+	err    error
+	reason string
+	status int
+	code   codes.Code
+}
+
+var kinds = []kind{
+	{ErrNotFound, "NOT_FOUND", http.StatusNotFound, codes.NotFound},
+	{ErrInvalidID, "INVALID_ID", http.StatusBadRequest, codes.InvalidArgument},
+	{ErrValidation, "VALIDATION_FAILED", http.StatusBadRequest, codes.InvalidArgument},
+	{ErrConflict, "CONFLICT", http.StatusConflict, codes.AlreadyExists},
+	{ErrUnauthorized, "UNAUTHORIZED", http.StatusUnauthorized, codes.Unauthenticated},
+	{ErrForbidden, "FORBIDDEN", http.StatusForbidden, codes.PermissionDenied},
+	{ErrTooManyRequests, "TOO_MANY_REQUESTS", http.StatusTooManyRequests, codes.ResourceExhausted},
+	{ErrLocked, "LOCKED", http.StatusLocked, codes.ResourceExhausted},
+}
+
+var internal = kind{nil, "INTERNAL", http.StatusInternalServerError, codes.Internal}
+
+func kindOf(err error) kind {
+	for _, k := range kinds {
+		if errors.Is(err, k.err) {
+			return k
+		}
+	}
+	return internal
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is a domain error of one of the categories above. Declare it as a
+// package-level sentinel (e.g. repository.ErrUserNotFound) so callers can match
+// either the sentinel itself or its category with errors.Is.
+type Error struct {
+	kind    error
+	message string
+	Fields  []FieldError
+}
+
+func New(kind error, message string) *Error {
+	return &Error{kind: kind, message: message}
+}
+
+// Validation reports invalid input, one FieldError per offending field.
+func Validation(fields ...FieldError) *Error {
+	return &Error{kind: ErrValidation, message: ErrValidation.Error(), Fields: fields}
+}
+
+func (e *Error) Error() string {
+	return e.message
+}
+
+func (e *Error) Unwrap() error {
+	return e.kind
+}
+
+// message is what clients see: the domain message for known errors, and a
+// generic text for anything else so internals never leak.
+func message(err error) string {
+	if kindOf(err) == internal {
+		return "internal server error"
+	}
+	return err.Error()
+}
+
+func fields(err error) []FieldError {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Fields
+	}
+	return nil
+}
+
+type retryAfterError struct {
+	error
+	after time.Duration
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.error
+}
+
+// WithRetryAfter wraps err with how long the client should wait before
+// retrying. HTTP responses carry it as a Retry-After header and gRPC statuses
+// as a RetryInfo detail.
+func WithRetryAfter(err error, after time.Duration) error {
+	return &retryAfterError{error: err, after: after}
+}
+
+// RetryAfter returns the delay attached by WithRetryAfter.
+func RetryAfter(err error) (time.Duration, bool) {
+	var e *retryAfterError
+	if errors.As(err, &e) {
+		return e.after, true
+	}
+	return 0, false
+}
@@ -0,0 +1,134 @@
+package apperr_test
+
+import (
+	"7-solutions/apperr"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errUserNotFound = apperr.New(apperr.ErrNotFound, "user not found")
+
+func TestError_Is(t *testing.T) {
+	wrapped := fmt.Errorf("get user: %w", errUserNotFound)
+	assert.ErrorIs(t, wrapped, errUserNotFound)
+	assert.ErrorIs(t, wrapped, apperr.ErrNotFound)
+	assert.NotErrorIs(t, wrapped, apperr.ErrConflict)
+}
+
+func TestNewProblem(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+		detail string
+	}{
+		{"not found", errUserNotFound, http.StatusNotFound, "NOT_FOUND", "user not found"},
+		{"invalid id", apperr.New(apperr.ErrInvalidID, "invalid user id"), http.StatusBadRequest, "INVALID_ID", "invalid user id"},
+		{"conflict", apperr.New(apperr.ErrConflict, "email already registered"), http.StatusConflict, "CONFLICT", "email already registered"},
+		{"unauthorized", apperr.New(apperr.ErrUnauthorized, "invalid credentials"), http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials"},
+		{"internal", errors.New("connection refused"), http.StatusInternalServerError, "INTERNAL", "internal server error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problem := apperr.NewProblem(tt.err, "/users/1")
+			assert.Equal(t, tt.status, problem.Status)
+			assert.Equal(t, tt.code, problem.Code)
+			assert.Equal(t, tt.detail, problem.Detail)
+			assert.Equal(t, http.StatusText(tt.status), problem.Title)
+			assert.Equal(t, "/users/1", problem.Instance)
+		})
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/register", func(c *gin.Context) {
+		apperr.WriteProblem(c, apperr.Validation(apperr.FieldError{Field: "email", Message: "must be a valid email"}))
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/register", nil))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+
+	var problem apperr.Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, "VALIDATION_FAILED", problem.Code)
+	assert.Equal(t, "/register", problem.Instance)
+	assert.Equal(t, []apperr.FieldError{{Field: "email", Message: "must be a valid email"}}, problem.Errors)
+}
+
+func TestGRPCStatus(t *testing.T) {
+	st := apperr.GRPCStatus(apperr.Validation(apperr.FieldError{Field: "sort", Message: "unsupported sort field"}))
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+
+	var info *errdetails.ErrorInfo
+	var badRequest *errdetails.BadRequest
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			info = d
+		case *errdetails.BadRequest:
+			badRequest = d
+		}
+	}
+	if assert.NotNil(t, info) {
+		assert.Equal(t, "VALIDATION_FAILED", info.Reason)
+	}
+	if assert.NotNil(t, badRequest) && assert.Len(t, badRequest.FieldViolations, 1) {
+		assert.Equal(t, "sort", badRequest.FieldViolations[0].Field)
+	}
+
+	st = apperr.GRPCStatus(errUserNotFound)
+	assert.Equal(t, codes.NotFound, st.Code())
+	assert.Equal(t, "user not found", st.Message())
+
+	st = apperr.GRPCStatus(errors.New("connection refused"))
+	assert.Equal(t, codes.Internal, st.Code())
+	assert.Equal(t, "internal server error", st.Message())
+
+	existing := status.Error(codes.PermissionDenied, "permission denied")
+	assert.Equal(t, codes.PermissionDenied, apperr.GRPCStatus(existing).Code())
+}
+
+func TestWithRetryAfter(t *testing.T) {
+	errLocked := apperr.New(apperr.ErrLocked, "account temporarily locked")
+	err := apperr.WithRetryAfter(errLocked, 1500*time.Millisecond)
+	assert.ErrorIs(t, err, errLocked)
+	assert.ErrorIs(t, err, apperr.ErrLocked)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/login", func(c *gin.Context) { apperr.WriteProblem(c, err) })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", nil))
+	assert.Equal(t, http.StatusLocked, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	st := apperr.GRPCStatus(apperr.WithRetryAfter(apperr.New(apperr.ErrTooManyRequests, "slow down"), time.Minute))
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	var retry *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		if d, ok := d.(*errdetails.RetryInfo); ok {
+			retry = d
+		}
+	}
+	if assert.NotNil(t, retry) {
+		assert.Equal(t, time.Minute, retry.RetryDelay.AsDuration())
+	}
+}
@@ -0,0 +1,47 @@
+package apperr
+
+import (
+	"log"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const errorDomain = "7-solutions"
+
+// GRPCStatus maps err to a status carrying an ErrorInfo detail, for
+// validation errors a BadRequest detail listing the offending fields, and a
+// RetryInfo detail for errors wrapped with WithRetryAfter. Errors
+// that already are gRPC statuses pass through unchanged.
+func GRPCStatus(err error) *status.Status {
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+
+	k := kindOf(err)
+	if k.code == codes.Internal {
+		log.Printf("internal error: %v", err)
+	}
+
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: k.reason, Domain: errorDomain}}
+	if fs := fields(err); len(fs) > 0 {
+		violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(fs))
+		for _, f := range fs {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: f.Field, Description: f.Message})
+		}
+		details = append(details, &errdetails.BadRequest{FieldViolations: violations})
+	}
+
+	if after, ok := RetryAfter(err); ok {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(after)})
+	}
+
+	st := status.New(k.code, message(err))
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		return withDetails
+	}
+	return st
+}
@@ -0,0 +1,47 @@
+package apperr
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Problem is an RFC 7807 problem details document.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+func NewProblem(err error, instance string) Problem {
+	k := kindOf(err)
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(k.status),
+		Status:   k.status,
+		Detail:   message(err),
+		Instance: instance,
+		Code:     k.reason,
+		Errors:   fields(err),
+	}
+}
+
+// WriteProblem aborts the request with the problem document for err.
+func WriteProblem(c *gin.Context, err error) {
+	problem := NewProblem(err, c.Request.URL.Path)
+	if problem.Status == http.StatusInternalServerError {
+		log.Printf("%s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+	}
+	if after, ok := RetryAfter(err); ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(after.Seconds()))))
+	}
+	c.Header("Content-Type", "application/problem+json")
+	c.AbortWithStatusJSON(problem.Status, problem)
+}
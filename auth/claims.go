@@ -0,0 +1,45 @@
+// Package auth verifies the access tokens this service issues and describes
+// the caller behind a request, whichever transport it arrived on.
+package auth
+
+import (
+	"7-solutions/model"
+	"7-solutions/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultAudience is the "aud" of access tokens for this API. Tokens issued
+// to OpenID Connect clients carry another audience and are refused.
+const DefaultAudience = "7-solutions"
+
+// Claims are the claims of an access token issued by utils.GenerateJWT.
+type Claims struct {
+	UserID    string     `json:"user_id"`
+	Role      model.Role `json:"role"`
+	SessionID string     `json:"sid"`
+	Scope     string     `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Signer signs access tokens with keys, adding the "iss" and "aud" claims a
+// Verifier with the same issuer and audience expects.
+type Signer struct {
+	keys     utils.TokenSigner
+	issuer   string
+	audience string
+}
+
+func NewSigner(keys utils.TokenSigner, issuer, audience string) *Signer {
+	return &Signer{keys: keys, issuer: issuer, audience: audience}
+}
+
+func (s *Signer) Sign(claims map[string]interface{}) (string, error) {
+	stamped := make(map[string]interface{}, len(claims)+2)
+	for k, v := range claims {
+		stamped[k] = v
+	}
+	stamped["iss"] = s.issuer
+	stamped["aud"] = s.audience
+	return s.keys.Sign(stamped)
+}
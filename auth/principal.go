@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"7-solutions/model"
+	"7-solutions/policy"
+	"context"
+	"time"
+)
+
+// Principal is the authenticated caller of a request, from an access token or
+// an API key. The Gin middleware and the gRPC interceptor put the same
+// Principal into the request context.
+type Principal struct {
+	UserID string
+	Role   model.Role
+	Scopes []string
+	// SessionID, TokenID and ExpiresAt describe the access token; they are
+	// empty for API keys.
+	SessionID string
+	TokenID   string
+	ExpiresAt time.Time
+	// APIKeyID is set when the caller used an API key.
+	APIKeyID string
+}
+
+// APIKeyPrincipal is the caller behind key, acting with user's role.
+func APIKeyPrincipal(key *model.APIKey, user *model.User) *Principal {
+	return &Principal{
+		UserID:   user.ID.Hex(),
+		Role:     user.Role,
+		Scopes:   key.Scopes,
+		APIKeyID: key.ID.Hex(),
+	}
+}
+
+// Subject is the principal as the access policy sees it.
+func (p *Principal) Subject() policy.Subject {
+	return policy.Subject{UserID: p.UserID, Role: p.Role}
+}
+
+// IsAPIKey reports whether the caller used an API key rather than a session.
+func (p *Principal) IsAPIKey() bool {
+	return p.APIKeyID != ""
+}
+
+type principalKey struct{}
+
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal stored by NewContext.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"7-solutions/model"
+	"7-solutions/repository"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxCachedUsers bounds the cache; expired entries are swept once it fills.
+const maxCachedUsers = 10000
+
+// userCache remembers for a short while that a user exists and their role, so
+// the user check does not query the database on every request.
+type userCache struct {
+	users repository.UsersRepository
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedUser
+}
+
+type cachedUser struct {
+	role    model.Role
+	expires time.Time
+}
+
+func newUserCache(users repository.UsersRepository, ttl time.Duration) *userCache {
+	return &userCache{users: users, ttl: ttl, entries: make(map[string]cachedUser)}
+}
+
+// role returns the stored role of userID, or ErrUserInactive when the user
+// does not exist. Missing users are not cached.
+func (c *userCache) role(ctx context.Context, userID string) (model.Role, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[userID]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.role, nil
+	}
+
+	user, err := c.users.GetByID(ctx, userID)
+	if errors.Is(err, repository.ErrUserNotFound) || errors.Is(err, repository.ErrInvalidUserID) {
+		c.mu.Lock()
+		delete(c.entries, userID)
+		c.mu.Unlock()
+		return "", ErrUserInactive
+	}
+	if err != nil {
+		return "", fmt.Errorf("checking user: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxCachedUsers {
+		for id, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, id)
+			}
+		}
+		if len(c.entries) >= maxCachedUsers {
+			c.entries = make(map[string]cachedUser)
+		}
+	}
+	c.entries[userID] = cachedUser{role: user.Role, expires: now.Add(c.ttl)}
+	return user.Role, nil
+}
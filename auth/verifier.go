@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/policy"
+	"7-solutions/repository"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// DefaultLeeway tolerates clock skew between this service and the
+	// instance that issued a token when checking "exp", "nbf" and "iat".
+	DefaultLeeway = 30 * time.Second
+	// DefaultUserCacheTTL is how long a user found by the user check is
+	// trusted before it is looked up again.
+	DefaultUserCacheTTL = 30 * time.Second
+)
+
+var (
+	ErrInvalidToken = apperr.New(apperr.ErrUnauthorized, "invalid token")
+	ErrTokenRevoked = apperr.New(apperr.ErrUnauthorized, "token has been revoked")
+	ErrUserInactive = apperr.New(apperr.ErrUnauthorized, "user does not exist (possibly deleted)")
+)
+
+// KeySet checks token signatures. keys.Manager implements it.
+type KeySet interface {
+	ParseWithClaims(token string, claims jwt.Claims, opts ...jwt.ParserOption) error
+}
+
+// Verifier validates access tokens and returns their principal. Every
+// transport uses the same Verifier, so a token is accepted or refused
+// identically over HTTP and gRPC.
+type Verifier struct {
+	keys        KeySet
+	issuer      string
+	audience    string
+	leeway      time.Duration
+	revocations repository.RevocationStore
+	users       *userCache
+}
+
+type VerifierOption func(*Verifier)
+
+// WithLeeway overrides DefaultLeeway.
+func WithLeeway(d time.Duration) VerifierOption {
+	return func(v *Verifier) { v.leeway = d }
+}
+
+// WithRevocations refuses tokens revoked by logout or a password change.
+func WithRevocations(store repository.RevocationStore) VerifierOption {
+	return func(v *Verifier) { v.revocations = store }
+}
+
+// WithUserCheck refuses tokens whose user no longer exists, and takes the
+// principal's role from the stored user rather than the token, so deletions
+// and role changes apply before the token expires. Users found are cached
+// for ttl.
+func WithUserCheck(users repository.UsersRepository, ttl time.Duration) VerifierOption {
+	return func(v *Verifier) { v.users = newUserCache(users, ttl) }
+}
+
+// NewVerifier accepts tokens signed by keys for issuer and audience.
+func NewVerifier(keys KeySet, issuer, audience string, opts ...VerifierOption) *Verifier {
+	v := &Verifier{keys: keys, issuer: issuer, audience: audience, leeway: DefaultLeeway}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// ParseClaims checks the token's signature, issuer, audience and validity
+// period, without the revocation and user checks.
+func (v *Verifier) ParseClaims(token string) (*Claims, error) {
+	claims := &Claims{}
+	err := v.keys.ParseWithClaims(token, claims,
+		jwt.WithIssuer(v.issuer),
+		jwt.WithAudience(v.audience),
+		jwt.WithLeeway(v.leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil || claims.UserID == "" || claims.ID == "" || claims.IssuedAt == nil {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// Verify returns the principal of a valid access token.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Principal, error) {
+	claims, err := v.ParseClaims(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.revocations != nil {
+		revoked, err := v.revocations.IsRevoked(ctx, claims.ID, claims.UserID, claims.SessionID, claims.IssuedAt.Time)
+		if err != nil {
+			return nil, fmt.Errorf("checking token revocation: %w", err)
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+
+	role := claims.Role
+	if v.users != nil {
+		if role, err = v.users.role(ctx, claims.UserID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Principal{
+		UserID:    claims.UserID,
+		Role:      role,
+		Scopes:    policy.ParseScope(claims.Scope),
+		SessionID: claims.SessionID,
+		TokenID:   claims.ID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
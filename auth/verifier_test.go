@@ -0,0 +1,165 @@
+package auth_test
+
+import (
+	"7-solutions/auth"
+	"7-solutions/keys"
+	"7-solutions/model"
+	"7-solutions/policy"
+	"7-solutions/repository"
+	"7-solutions/utils"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testIssuer = "https://api.example.com"
+
+var testKeys = keys.NewHMACManager("secret")
+
+// stubUsers serves users from a map and counts lookups.
+type stubUsers struct {
+	repository.UsersRepository
+	users   map[string]*model.User
+	lookups int
+}
+
+func (s *stubUsers) GetByID(ctx context.Context, id string) (*model.User, error) {
+	s.lookups++
+	if user, ok := s.users[id]; ok {
+		return user, nil
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+// signAt signs an access token for alice as if issued at issuedAt.
+func signAt(t *testing.T, signer utils.TokenSigner, issuedAt time.Time, ttl time.Duration) string {
+	token, err := signer.Sign(map[string]interface{}{
+		"user_id": "alice",
+		"role":    "user",
+		"sid":     "session",
+		"scope":   "users:read",
+		"jti":     "jti-" + issuedAt.String(),
+		"iat":     issuedAt.Unix(),
+		"nbf":     issuedAt.Unix(),
+		"exp":     issuedAt.Add(ttl).Unix(),
+	})
+	require.NoError(t, err)
+	return token
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	signer := auth.NewSigner(testKeys, testIssuer, auth.DefaultAudience)
+	verifier := auth.NewVerifier(testKeys, testIssuer, auth.DefaultAudience)
+
+	token, err := utils.GenerateJWT("alice", "user", "session", []string{policy.ScopeUsersRead}, signer, time.Minute)
+	require.NoError(t, err)
+	principal, err := verifier.Verify(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", principal.UserID)
+	assert.Equal(t, model.RoleUser, principal.Role)
+	assert.Equal(t, "session", principal.SessionID)
+	assert.Equal(t, []string{policy.ScopeUsersRead}, principal.Scopes)
+	assert.NotEmpty(t, principal.TokenID)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), principal.ExpiresAt, 2*time.Second)
+	assert.False(t, principal.IsAPIKey())
+}
+
+func TestVerifier_Rejects(t *testing.T) {
+	verifier := auth.NewVerifier(testKeys, testIssuer, auth.DefaultAudience, auth.WithLeeway(30*time.Second))
+	signer := auth.NewSigner(testKeys, testIssuer, auth.DefaultAudience)
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"expired within leeway", signAt(t, signer, now.Add(-70*time.Second), time.Minute), true},
+		{"expired beyond leeway", signAt(t, signer, now.Add(-2*time.Minute), time.Minute), false},
+		{"issued slightly in the future", signAt(t, signer, now.Add(20*time.Second), time.Minute), true},
+		{"not yet valid", signAt(t, signer, now.Add(2*time.Minute), time.Minute), false},
+		{"other issuer", signAt(t, auth.NewSigner(testKeys, "https://evil.example.com", auth.DefaultAudience), now, time.Minute), false},
+		{"other audience", signAt(t, auth.NewSigner(testKeys, testIssuer, "other-api"), now, time.Minute), false},
+		{"no audience", signAt(t, testKeys, now, time.Minute), false},
+		{"other key", signAt(t, auth.NewSigner(keys.NewHMACManager("other"), testIssuer, auth.DefaultAudience), now, time.Minute), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.Verify(context.Background(), tt.token)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, auth.ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestVerifier_Revocations(t *testing.T) {
+	store := repository.NewMemoryTokenStore()
+	verifier := auth.NewVerifier(testKeys, testIssuer, auth.DefaultAudience, auth.WithRevocations(store))
+	signer := auth.NewSigner(testKeys, testIssuer, auth.DefaultAudience)
+	token, err := utils.GenerateJWT("alice", "user", "session", nil, signer, time.Minute)
+	require.NoError(t, err)
+
+	principal, err := verifier.Verify(context.Background(), token)
+	require.NoError(t, err)
+	require.NoError(t, store.RevokeToken(context.Background(), principal.TokenID, principal.ExpiresAt))
+
+	_, err = verifier.Verify(context.Background(), token)
+	assert.ErrorIs(t, err, auth.ErrTokenRevoked)
+}
+
+func TestVerifier_UserCheck(t *testing.T) {
+	alice := &model.User{ID: primitive.NewObjectID(), Role: model.RoleAdmin}
+	users := &stubUsers{users: map[string]*model.User{alice.ID.Hex(): alice}}
+	verifier := auth.NewVerifier(testKeys, testIssuer, auth.DefaultAudience, auth.WithUserCheck(users, time.Minute))
+	signer := auth.NewSigner(testKeys, testIssuer, auth.DefaultAudience)
+
+	token, err := utils.GenerateJWT(alice.ID.Hex(), "user", "session", nil, signer, time.Minute)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		principal, err := verifier.Verify(context.Background(), token)
+		require.NoError(t, err)
+		assert.Equal(t, model.RoleAdmin, principal.Role, "the stored role wins over the token's")
+	}
+	assert.Equal(t, 1, users.lookups, "users are cached")
+
+	bob, err := utils.GenerateJWT(primitive.NewObjectID().Hex(), "user", "session", nil, signer, time.Minute)
+	require.NoError(t, err)
+	_, err = verifier.Verify(context.Background(), bob)
+	assert.ErrorIs(t, err, auth.ErrUserInactive)
+}
+
+func TestVerifier_UserCacheExpires(t *testing.T) {
+	alice := &model.User{ID: primitive.NewObjectID(), Role: model.RoleUser}
+	users := &stubUsers{users: map[string]*model.User{alice.ID.Hex(): alice}}
+	verifier := auth.NewVerifier(testKeys, testIssuer, auth.DefaultAudience, auth.WithUserCheck(users, 0))
+	signer := auth.NewSigner(testKeys, testIssuer, auth.DefaultAudience)
+	token, err := utils.GenerateJWT(alice.ID.Hex(), "user", "session", nil, signer, time.Minute)
+	require.NoError(t, err)
+
+	_, err = verifier.Verify(context.Background(), token)
+	require.NoError(t, err)
+	delete(users.users, alice.ID.Hex())
+	_, err = verifier.Verify(context.Background(), token)
+	assert.ErrorIs(t, err, auth.ErrUserInactive, "deletion applies once the cache expires")
+	assert.Equal(t, 2, users.lookups)
+}
+
+func TestPrincipalContext(t *testing.T) {
+	_, ok := auth.FromContext(context.Background())
+	assert.False(t, ok)
+
+	key := &model.APIKey{ID: primitive.NewObjectID(), Scopes: []string{policy.ScopeUsersRead}}
+	user := &model.User{ID: primitive.NewObjectID(), Role: model.RoleUser}
+	ctx := auth.NewContext(context.Background(), auth.APIKeyPrincipal(key, user))
+	principal, ok := auth.FromContext(ctx)
+	require.True(t, ok)
+	assert.True(t, principal.IsAPIKey())
+	assert.Equal(t, policy.Subject{UserID: user.ID.Hex(), Role: model.RoleUser}, principal.Subject())
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"7-solutions/auth"
+	"7-solutions/keys"
+	"7-solutions/repository"
+)
+
+// tokenIssuer is the "iss" of access tokens, JWT_ISSUER or else APP_BASE_URL.
+func tokenIssuer() string {
+	return GetEnv("JWT_ISSUER", GetEnv("APP_BASE_URL", "http://localhost:8080"))
+}
+
+// NewTokenSigner signs access tokens with tokenKeys for JWT_ISSUER and
+// JWT_AUDIENCE.
+func NewTokenSigner(tokenKeys *keys.Manager) *auth.Signer {
+	return auth.NewSigner(tokenKeys, tokenIssuer(), GetEnv("JWT_AUDIENCE", auth.DefaultAudience))
+}
+
+// NewVerifier accepts the access tokens NewTokenSigner issues, allowing
+// JWT_LEEWAY of clock skew. Unless AUTH_CHECK_USER is false, it also refuses
+// tokens of deleted users, caching users for AUTH_USER_CACHE_TTL.
+func NewVerifier(tokenKeys *keys.Manager, revocations repository.RevocationStore, users repository.UsersRepository) *auth.Verifier {
+	opts := []auth.VerifierOption{
+		auth.WithLeeway(GetDuration("JWT_LEEWAY", auth.DefaultLeeway)),
+		auth.WithRevocations(revocations),
+	}
+	if GetBool("AUTH_CHECK_USER", true) {
+		opts = append(opts, auth.WithUserCheck(users, GetDuration("AUTH_USER_CACHE_TTL", auth.DefaultUserCacheTTL)))
+	}
+	return auth.NewVerifier(tokenKeys, tokenIssuer(), GetEnv("JWT_AUDIENCE", auth.DefaultAudience), opts...)
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"7-solutions/connector"
+	"log"
+	"os"
+	"strings"
+)
+
+// NewConnectors reads the identity providers listed in CONNECTORS, e.g.
+// "google,okta". Each is configured with CONNECTOR_<ID>_ISSUER, _CLIENT_ID,
+// _CLIENT_SECRET, optional _SCOPES and _TRUST_EMAIL (default true). The
+// callback is APP_BASE_URL/auth/<id>/callback.
+func NewConnectors() []connector.Connector {
+	var connectors []connector.Connector
+	for _, id := range GetList("CONNECTORS", nil) {
+		prefix := "CONNECTOR_" + strings.ToUpper(strings.ReplaceAll(id, "-", "_")) + "_"
+		cfg := connector.OIDCConfig{
+			ID:           id,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  strings.TrimSuffix(os.Getenv("APP_BASE_URL"), "/") + "/auth/" + id + "/callback",
+			Scopes:       GetList(prefix+"SCOPES", nil),
+			TrustEmail:   GetBool(prefix+"TRUST_EMAIL", true),
+		}
+		if cfg.Issuer == "" || cfg.ClientID == "" {
+			log.Fatalf("connector %s needs %sISSUER and %sCLIENT_ID", id, prefix, prefix)
+		}
+		connectors = append(connectors, connector.NewOIDC(cfg))
+	}
+	return connectors
+}
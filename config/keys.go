@@ -0,0 +1,37 @@
+package config
+
+import (
+	"7-solutions/keys"
+	"log"
+	"os"
+)
+
+// NewKeyManager loads the key access tokens are signed with from the PEM file
+// at JWT_SIGNING_KEY, plus retired keys that still verify from
+// JWT_VERIFICATION_KEYS. Without a signing key, tokens are signed with
+// HS256 and JWT_SECRET, no keys are published and the OpenID Connect
+// provider stays off.
+func NewKeyManager() *keys.Manager {
+	path := os.Getenv("JWT_SIGNING_KEY")
+	if path == "" {
+		return keys.NewHMACManager(os.Getenv("JWT_SECRET"))
+	}
+	signing, err := keys.LoadPEM(path)
+	if err != nil {
+		log.Fatalf("invalid JWT_SIGNING_KEY: %v", err)
+	}
+	var verification []keys.Key
+	for _, path := range GetList("JWT_VERIFICATION_KEYS", nil) {
+		key, err := keys.LoadPEM(path)
+		if err != nil {
+			log.Fatalf("invalid JWT_VERIFICATION_KEYS: %v", err)
+		}
+		verification = append(verification, key)
+	}
+	manager, err := keys.NewManager(signing, verification...)
+	if err != nil {
+		log.Fatalf("invalid JWT keys: %v", err)
+	}
+	log.Printf("signing tokens with %s key %s", signing.Algorithm, signing.ID)
+	return manager
+}
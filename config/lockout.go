@@ -0,0 +1,17 @@
+package config
+
+import "7-solutions/policy"
+
+// NewLockoutPolicy builds the failed login policy from LOGIN_* settings on
+// top of policy.DefaultLockoutPolicy.
+func NewLockoutPolicy() policy.LockoutPolicy {
+	p := policy.DefaultLockoutPolicy()
+	p.Window = GetDuration("LOGIN_ATTEMPT_WINDOW", p.Window)
+	p.FreeAttempts = GetInt("LOGIN_FREE_ATTEMPTS", p.FreeAttempts)
+	p.BaseDelay = GetDuration("LOGIN_BACKOFF_BASE", p.BaseDelay)
+	p.MaxDelay = GetDuration("LOGIN_BACKOFF_MAX", p.MaxDelay)
+	p.MaxAttempts = GetInt("LOGIN_MAX_ATTEMPTS", p.MaxAttempts)
+	p.LockoutDuration = GetDuration("LOGIN_LOCKOUT_DURATION", p.LockoutDuration)
+	p.MaxIPAttempts = GetInt("LOGIN_MAX_IP_ATTEMPTS", p.MaxIPAttempts)
+	return p
+}
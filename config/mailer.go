@@ -0,0 +1,29 @@
+package config
+
+import (
+	"7-solutions/mailer"
+	"log"
+	"os"
+)
+
+// NewMailer builds the mailer selected by MAILER: "log" (default), "smtp" or
+// "memory".
+func NewMailer() mailer.Mailer {
+	switch backend := GetEnv("MAILER", "log"); backend {
+	case "log":
+		return mailer.NewLogMailer()
+	case "memory":
+		return mailer.NewMemoryMailer()
+	case "smtp":
+		return mailer.NewSMTPMailer(
+			GetEnv("SMTP_HOST", "localhost"),
+			GetEnv("SMTP_PORT", "1025"),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+			GetEnv("MAIL_FROM", "no-reply@7-solutions.local"),
+		)
+	default:
+		log.Fatalf("unknown MAILER %q", backend)
+		return nil
+	}
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"7-solutions/policy"
+	"7-solutions/utils"
+	"log"
+	"os"
+)
+
+// NewPasswordPolicy builds the password policy from PASSWORD_* settings on top
+// of policy.DefaultPasswordPolicy. BREACHED_PASSWORDS_FILE, when set, names a
+// sorted Pwned Passwords style list of SHA-1 digests to reject.
+func NewPasswordPolicy() policy.PasswordPolicy {
+	p := policy.DefaultPasswordPolicy()
+	p.MinLength = GetInt("PASSWORD_MIN_LENGTH", p.MinLength)
+	p.MaxLength = GetInt("PASSWORD_MAX_LENGTH", p.MaxLength)
+	p.RequireUpper = GetBool("PASSWORD_REQUIRE_UPPER", p.RequireUpper)
+	p.RequireLower = GetBool("PASSWORD_REQUIRE_LOWER", p.RequireLower)
+	p.RequireDigit = GetBool("PASSWORD_REQUIRE_DIGIT", p.RequireDigit)
+	p.RequireSymbol = GetBool("PASSWORD_REQUIRE_SYMBOL", p.RequireSymbol)
+
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := policy.LoadBreachedPasswords(path)
+		if err != nil {
+			log.Fatalf("loading breached passwords: %v", err)
+		}
+		p.Breached = breached
+	}
+	return p
+}
+
+// NewPasswordHasher picks the hasher for new passwords from PASSWORD_HASHER
+// (bcrypt or argon2id). Hashes made by the other algorithm keep verifying and
+// are upgraded on the user's next login.
+func NewPasswordHasher() utils.PasswordHasher {
+	switch kind := GetEnv("PASSWORD_HASHER", "bcrypt"); kind {
+	case "bcrypt":
+		return utils.NewBcryptHasher(GetInt("BCRYPT_COST", utils.DefaultBcryptCost))
+	case "argon2id":
+		params := utils.DefaultArgon2Params()
+		params.Memory = uint32(GetInt("ARGON2_MEMORY", int(params.Memory)))
+		params.Iterations = uint32(GetInt("ARGON2_ITERATIONS", int(params.Iterations)))
+		params.Parallelism = uint8(GetInt("ARGON2_PARALLELISM", int(params.Parallelism)))
+		return utils.NewArgon2idHasher(params)
+	default:
+		log.Fatalf("unknown PASSWORD_HASHER %q", kind)
+		return nil
+	}
+}
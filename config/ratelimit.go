@@ -0,0 +1,37 @@
+package config
+
+import (
+	"7-solutions/ratelimit"
+	"context"
+	"log"
+	"time"
+)
+
+// NewRateLimiter builds the limiter selected by RATE_LIMIT_STORE: "memory"
+// (default), which counts per instance, or "redis", shared by every instance.
+func NewRateLimiter() ratelimit.Limiter {
+	switch backend := GetEnv("RATE_LIMIT_STORE", "memory"); backend {
+	case "memory":
+		return ratelimit.NewMemoryLimiter()
+	case "redis":
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return ratelimit.NewRedisLimiter(ConnectRedis(ctx))
+	default:
+		log.Fatalf("unknown RATE_LIMIT_STORE %q", backend)
+		return nil
+	}
+}
+
+// NewRateLimitRules reads the limit every route shares from
+// RATE_LIMIT_DEFAULT and per-route limits from RATE_LIMIT_ROUTES.
+func NewRateLimitRules() ratelimit.Rules {
+	rules, err := ratelimit.ParseRules(
+		GetEnv("RATE_LIMIT_DEFAULT", "300/1m"),
+		GetList("RATE_LIMIT_ROUTES", ratelimit.DefaultRoutes),
+	)
+	if err != nil {
+		log.Fatalf("invalid rate limits: %v", err)
+	}
+	return rules
+}
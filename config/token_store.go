@@ -0,0 +1,50 @@
+package config
+
+import (
+	"7-solutions/repository"
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NewTokenStore builds the token store selected by TOKEN_STORE: "memory",
+// "mongo" (default) or "redis".
+func NewTokenStore(db *mongo.Database) repository.TokenStore {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	switch backend := GetEnv("TOKEN_STORE", "mongo"); backend {
+	case "memory":
+		return repository.NewMemoryTokenStore()
+	case "mongo":
+		store, err := repository.NewMongoTokenStore(ctx, db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return store
+	case "redis":
+		return repository.NewRedisTokenStore(ConnectRedis(ctx))
+	default:
+		log.Fatalf("unknown TOKEN_STORE %q", backend)
+		return nil
+	}
+}
+
+func ConnectRedis(ctx context.Context) *redis.Client {
+	db, _ := strconv.Atoi(os.Getenv("REDIS_DB"))
+	client := redis.NewClient(&redis.Options{
+		Addr:     GetEnv("REDIS_ADDR", "localhost:6379"),
+		Password: os.Getenv("REDIS_PASSWORD"),
+		DB:       db,
+	})
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		log.Fatal(err)
+	}
+	return client
+}
@@ -0,0 +1,142 @@
+// Package connector signs users in at external identity providers.
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Identity is what an identity provider asserts about the signed-in user.
+type Identity struct {
+	Provider string
+	// Subject is the provider's stable id for the account.
+	Subject string
+	Email   string
+	// EmailVerified is only set when the provider vouches for the email and
+	// the connector is configured to trust it.
+	EmailVerified bool
+	Name          string
+}
+
+// Connector sends users to an identity provider and verifies who they are
+// when they come back.
+type Connector interface {
+	ID() string
+	// AuthCodeURL returns where to send the user. state, nonce and the PKCE
+	// verifier must be kept for Exchange.
+	AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error)
+	// Exchange redeems the code the provider returned and checks its ID token.
+	Exchange(ctx context.Context, code, nonce, verifier string) (*Identity, error)
+}
+
+// OIDCConfig describes one OpenID Connect identity provider.
+type OIDCConfig struct {
+	ID           string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is this service's callback for the provider.
+	RedirectURL string
+	Scopes      []string
+	// TrustEmail accepts the provider's email_verified claim, which lets
+	// sign-ins link to existing accounts with the same email. Leave it off
+	// for providers that let users claim addresses they do not own.
+	TrustEmail bool
+}
+
+// OIDC is a connector for any OpenID Connect provider. The provider's
+// discovery document is fetched on first use.
+type OIDC struct {
+	config OIDCConfig
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func NewOIDC(config OIDCConfig) *OIDC {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	return &OIDC{config: config}
+}
+
+func (c *OIDC) ID() string {
+	return c.config.ID
+}
+
+func (c *OIDC) discover(ctx context.Context) (*oidc.Provider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.provider != nil {
+		return c.provider, nil
+	}
+	// The provider refreshes its keys with this context long after the
+	// request that triggered discovery has finished.
+	provider, err := oidc.NewProvider(context.WithoutCancel(ctx), c.config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", c.config.ID, err)
+	}
+	c.provider = provider
+	return provider, nil
+}
+
+func (c *OIDC) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.config.ClientID,
+		ClientSecret: c.config.ClientSecret,
+		RedirectURL:  c.config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       c.config.Scopes,
+	}
+}
+
+func (c *OIDC) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	provider, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return c.oauth2Config(provider).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (c *OIDC) Exchange(ctx context.Context, code, nonce, verifier string) (*Identity, error) {
+	provider, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := c.oauth2Config(provider).Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("redeeming %s code: %w", c.config.ID, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("no id_token in token response")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: c.config.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verifying %s id_token: %w", c.config.ID, err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	return &Identity{
+		Provider:      c.config.ID,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified && c.config.TrustEmail,
+		Name:          claims.Name,
+	}, nil
+}
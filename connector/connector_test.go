@@ -0,0 +1,159 @@
+package connector_test
+
+import (
+	"7-solutions/connector"
+	"7-solutions/keys"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+// fakeIssuer is a local OpenID Connect provider. It issues an ID token with
+// claims for the code "good" once the PKCE challenge sent to /authorize
+// matches the verifier.
+type fakeIssuer struct {
+	server    *httptest.Server
+	keys      *keys.Manager
+	claims    map[string]interface{}
+	challenge string
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := keys.Generate(keys.ES256)
+	require.NoError(t, err)
+	m, err := keys.NewManager(key)
+	require.NoError(t, err)
+	f := &fakeIssuer{keys: m}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                f.server.URL,
+			"authorization_endpoint":                f.server.URL + "/authorize",
+			"token_endpoint":                        f.server.URL + "/token",
+			"jwks_uri":                              f.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{keys.ES256},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(m.JWKS())
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if id != "client" || secret != "client-secret" || r.PostFormValue("code") != "good" ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != f.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := map[string]interface{}{
+			"iss": f.server.URL,
+			"aud": "client",
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}
+		for k, v := range f.claims {
+			claims[k] = v
+		}
+		idToken, err := m.Sign(claims)
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	})
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeIssuer) connector(trustEmail bool) *connector.OIDC {
+	return connector.NewOIDC(connector.OIDCConfig{
+		ID:           "fake",
+		Issuer:       f.server.URL,
+		ClientID:     "client",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://users.example.com/auth/fake/callback",
+		TrustEmail:   trustEmail,
+	})
+}
+
+// authorize follows the connector's redirect as far as the fake issuer
+// needs: it remembers the PKCE challenge.
+func (f *fakeIssuer) authorize(t *testing.T, c *connector.OIDC, nonce string) {
+	authURL, err := c.AuthCodeURL(context.Background(), "state", nonce, verifier)
+	require.NoError(t, err)
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(authURL, f.server.URL+"/authorize?"))
+	q := u.Query()
+	assert.Equal(t, "state", q.Get("state"))
+	assert.Equal(t, nonce, q.Get("nonce"))
+	assert.Equal(t, "S256", q.Get("code_challenge_method"))
+	assert.Equal(t, "openid email profile", q.Get("scope"))
+	f.challenge = q.Get("code_challenge")
+}
+
+func TestOIDC_Exchange(t *testing.T) {
+	f := newFakeIssuer(t)
+	f.claims = map[string]interface{}{
+		"sub":            "248289761001",
+		"nonce":          "nonce-1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+	}
+	c := f.connector(true)
+	f.authorize(t, c, "nonce-1")
+
+	identity, err := c.Exchange(context.Background(), "good", "nonce-1", verifier)
+	require.NoError(t, err)
+	assert.Equal(t, &connector.Identity{
+		Provider:      "fake",
+		Subject:       "248289761001",
+		Email:         "alice@example.com",
+		EmailVerified: true,
+		Name:          "Alice",
+	}, identity)
+
+	untrusted := f.connector(false)
+	f.authorize(t, untrusted, "nonce-1")
+	identity, err = untrusted.Exchange(context.Background(), "good", "nonce-1", verifier)
+	require.NoError(t, err)
+	assert.False(t, identity.EmailVerified, "email_verified is ignored unless trusted")
+}
+
+func TestOIDC_ExchangeRejects(t *testing.T) {
+	f := newFakeIssuer(t)
+	f.claims = map[string]interface{}{"sub": "1", "nonce": "nonce-1"}
+	c := f.connector(true)
+	f.authorize(t, c, "nonce-1")
+	ctx := context.Background()
+
+	_, err := c.Exchange(ctx, "bad", "nonce-1", verifier)
+	assert.Error(t, err)
+	_, err = c.Exchange(ctx, "good", "nonce-1", strings.Repeat("x", 43))
+	assert.Error(t, err, "wrong PKCE verifier")
+	_, err = c.Exchange(ctx, "good", "nonce-2", verifier)
+	assert.Error(t, err, "replayed ID token with another nonce")
+
+	f.claims["aud"] = "someone-else"
+	_, err = c.Exchange(ctx, "good", "nonce-1", verifier)
+	assert.Error(t, err, "ID token for another client")
+}
@@ -1,22 +1,88 @@
 package grpc
 
 import (
+	"7-solutions/auth"
+	"7-solutions/model"
+	"7-solutions/policy"
+	"7-solutions/utils"
 	"context"
-	"errors"
-	"log"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// methodActions names the policy action guarding each RPC. Methods not listed
+// only require a valid token.
+var methodActions = map[string]string{
+	"/user.UserService/GetUser":    policy.ActionGetUser,
+	"/user.UserService/ListUsers":  policy.ActionListUsers,
+	"/user.UserService/WatchUsers": policy.ActionListUsers,
+	"/user.UserService/UpdateUser": policy.ActionUpdateUser,
+	"/user.UserService/DeleteUser": policy.ActionDeleteUser,
+	"/user.UserService/UnlockUser": policy.ActionUnlockUser,
+	"/user.UserService/CountUsers": policy.ActionListUsers,
+}
+
+// DefaultPublicMethods are the RPCs callers use before they hold a valid
+// access token: registering, logging in and refreshing an expired token.
+var DefaultPublicMethods = []string{
+	"/user.UserService/CreateUser",
+	"/user.UserService/Login",
+	"/user.UserService/LoginMFA",
+	"/user.UserService/RefreshToken",
+}
+
+// methodScopes names the scopes a token or API key needs for each RPC. Like
+// RequireScopes on the HTTP routes, they apply on top of methodActions.
+var methodScopes = map[string][]string{
+	"/user.UserService/GetUser":        {policy.ScopeUsersRead},
+	"/user.UserService/ListUsers":      {policy.ScopeUsersRead},
+	"/user.UserService/WatchUsers":     {policy.ScopeUsersRead},
+	"/user.UserService/CountUsers":     {policy.ScopeUsersRead},
+	"/user.UserService/UpdateUser":     {policy.ScopeUsersWrite},
+	"/user.UserService/ChangePassword": {policy.ScopeUsersWrite},
+	"/user.UserService/DeleteUser":     {policy.ScopeUsersAdmin},
+	"/user.UserService/UnlockUser":     {policy.ScopeUsersAdmin},
+}
+
+// sessionMethods manage the account itself and refuse API keys, so a leaked
+// key cannot take the account over.
+var sessionMethods = map[string]bool{
+	"/user.UserService/ChangePassword": true,
+}
+
+// APIKeyAuthenticator resolves API keys to their key and owner, such as
+// usecase.APIKeyUsecase.
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, secret string) (*model.APIKey, *model.User, error)
+}
+
 type AuthInterceptor struct {
-	JWTSecret string
+	Verifier      *auth.Verifier
+	APIKeys       APIKeyAuthenticator
+	Policy        policy.Policy
+	PublicMethods map[string]bool
 }
 
-func NewAuthInterceptor(secret string) *AuthInterceptor {
-	return &AuthInterceptor{JWTSecret: secret}
+// NewAuthInterceptor protects every method except the full method names
+// (e.g. "/user.UserService/Login") listed in publicMethods. Callers send an
+// access token as "authorization: Bearer <token>" metadata, or an API key as
+// "authorization: ApiKey <key>" or "x-api-key: <key>". apiKeys may be nil to
+// accept access tokens only. Handlers find the caller with auth.FromContext.
+func NewAuthInterceptor(verifier *auth.Verifier, apiKeys APIKeyAuthenticator, p policy.Policy, publicMethods []string) *AuthInterceptor {
+	public := make(map[string]bool, len(publicMethods))
+	for _, method := range publicMethods {
+		public[method] = true
+	}
+	return &AuthInterceptor{
+		Verifier:      verifier,
+		APIKeys:       apiKeys,
+		Policy:        p,
+		PublicMethods: public,
+	}
 }
 
 func (a *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
@@ -26,56 +92,133 @@ func (a *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (interface{}, error) {
+		if a.PublicMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
 
-		userID, err := a.authorize(ctx)
+		principal, err := a.authorize(ctx, info.FullMethod)
 		if err != nil {
 			return nil, err
 		}
 
-		newCtx := context.WithValue(ctx, "userID", userID)
-		return handler(newCtx, req)
+		if action, ok := methodActions[info.FullMethod]; ok {
+			if !a.Policy.Allow(action, principal.Subject(), resourceID(req)) {
+				return nil, status.Error(codes.PermissionDenied, "permission denied")
+			}
+		}
+
+		return handler(auth.NewContext(ctx, principal), req)
+	}
+}
+
+func (a *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if a.PublicMethods[info.FullMethod] {
+			return handler(srv, ss)
+		}
+
+		principal, err := a.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		action, guarded := methodActions[info.FullMethod]
+		return handler(srv, &authorizedStream{
+			ServerStream: ss,
+			ctx:          auth.NewContext(ss.Context(), principal),
+			authorized: func(req interface{}) bool {
+				return !guarded || a.Policy.Allow(action, principal.Subject(), resourceID(req))
+			},
+		})
 	}
 }
 
-func (a *AuthInterceptor) authorize(ctx context.Context) (string, error) {
+// authorizedStream carries the authenticated context and checks the policy
+// against each request message, since stream requests arrive after the
+// interceptor has run.
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx        context.Context
+	authorized func(req interface{}) bool
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if !s.authorized(m) {
+		return status.Error(codes.PermissionDenied, "permission denied")
+	}
+	return nil
+}
+
+// resourceID returns the id field of requests that target a single user.
+func resourceID(req interface{}) string {
+	if r, ok := req.(interface{ GetId() string }); ok {
+		return r.GetId()
+	}
+	return ""
+}
+
+// authorize validates the bearer token or API key and returns its principal.
+func (a *AuthInterceptor) authorize(ctx context.Context, method string) (*auth.Principal, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return "", errors.New("missing metadata")
+		return nil, status.Error(codes.Unauthenticated, "missing metadata")
 	}
 
 	authHeaders := md["authorization"]
+	if apiKey := utils.APIKeyCredential(firstValue(md, "authorization"), firstValue(md, "x-api-key")); apiKey != "" {
+		return a.authorizeAPIKey(ctx, method, apiKey)
+	}
 	if len(authHeaders) == 0 {
-		return "", errors.New("authorization token not provided")
+		return nil, status.Error(codes.Unauthenticated, "authorization token not provided")
 	}
 
 	tokenParts := strings.SplitN(authHeaders[0], " ", 2)
 	if len(tokenParts) != 2 || strings.ToLower(tokenParts[0]) != "bearer" {
-		return "", errors.New("invalid authorization format")
+		return nil, status.Error(codes.Unauthenticated, "invalid authorization format")
 	}
 
-	tokenString := tokenParts[1]
-
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return []byte(a.JWTSecret), nil
-	})
-
-	if err != nil || !token.Valid {
-		log.Println("Invalid token:", err)
-		return "", errors.New("invalid token")
+	principal, err := a.Verifier.Verify(ctx, tokenParts[1])
+	if err != nil {
+		return nil, toStatus(err)
 	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return "", errors.New("invalid token claims")
+	if err := checkScopes(method, principal.Scopes); err != nil {
+		return nil, err
 	}
+	return principal, nil
+}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return "", errors.New("user_id not found in token claims")
+func checkScopes(method string, granted []string) error {
+	if required := methodScopes[method]; !policy.HasScopes(granted, required...) {
+		return status.Errorf(codes.PermissionDenied, "insufficient scope, requires %s", strings.Join(required, " "))
 	}
+	return nil
+}
 
-	return userID, nil
+func (a *AuthInterceptor) authorizeAPIKey(ctx context.Context, method, secret string) (*auth.Principal, error) {
+	if a.APIKeys == nil {
+		return nil, status.Error(codes.Unauthenticated, "api keys are not accepted")
+	}
+	if sessionMethods[method] {
+		return nil, status.Error(codes.PermissionDenied, "api keys cannot be used for this method")
+	}
+	key, user, err := a.APIKeys.AuthenticateAPIKey(ctx, secret)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := checkScopes(method, key.Scopes); err != nil {
+		return nil, err
+	}
+	return auth.APIKeyPrincipal(key, user), nil
 }
@@ -0,0 +1,85 @@
+package grpc
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/auth"
+	"7-solutions/ratelimit"
+	"context"
+	"log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RateLimitInterceptor applies the same token buckets as the HTTP server,
+// per full method name. Install it before the AuthInterceptor so rejected
+// calls are limited too.
+type RateLimitInterceptor struct {
+	Limiter  ratelimit.Limiter
+	Rules    ratelimit.Rules
+	Verifier *auth.Verifier
+}
+
+func NewRateLimitInterceptor(limiter ratelimit.Limiter, rules ratelimit.Rules, verifier *auth.Verifier) *RateLimitInterceptor {
+	return &RateLimitInterceptor{Limiter: limiter, Rules: rules, Verifier: verifier}
+}
+
+func (r *RateLimitInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if err := r.allow(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (r *RateLimitInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if err := r.allow(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// allow takes a token for the call, sending the RateLimit-* fields as header
+// metadata. Limiter failures let the call through.
+func (r *RateLimitInterceptor) allow(ctx context.Context, method string) error {
+	limit, bucket := r.Rules.For(method)
+	if limit.Unlimited() {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	client := ratelimit.ClientKey(ctx, r.Verifier, firstValue(md, "authorization"), firstValue(md, "x-api-key"), clientIP(ctx))
+	res, err := r.Limiter.Allow(ctx, bucket+"|"+client, limit)
+	if err != nil {
+		log.Printf("rate limiter: %v", err)
+		return nil
+	}
+
+	if err := grpc.SetHeader(ctx, metadata.New(res.Header())); err != nil {
+		log.Printf("sending rate limit headers: %v", err)
+	}
+	if !res.Allowed {
+		return toStatus(apperr.WithRetryAfter(ratelimit.ErrRateLimited, res.RetryAfter))
+	}
+	return nil
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package grpc_test
+
+import (
+	"7-solutions/auth"
+	grpcserver "7-solutions/grpc"
+	"7-solutions/model"
+	userpb "7-solutions/proto"
+	"7-solutions/ratelimit"
+	"7-solutions/usecase"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestRateLimitInterceptor(t *testing.T) {
+	uc := new(MockUsecase)
+	uc.On("Login", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, usecase.ErrInvalidCredentials)
+	uc.On("CountUsers", mock.Anything).Return(int64(1), nil)
+	rules, err := ratelimit.ParseRules("off", []string{"/user.UserService/Login=2/1m"})
+	require.NoError(t, err)
+	limiter := grpcserver.NewRateLimitInterceptor(ratelimit.NewMemoryLimiter(), rules, auth.NewVerifier(testKeys, "test", auth.DefaultAudience))
+	client := newTestClient(t, uc,
+		grpc.ChainUnaryInterceptor(limiter.Unary()),
+		grpc.ChainStreamInterceptor(limiter.Stream()),
+	)
+	req := &userpb.LoginRequest{Email: "a@example.com", Password: "wrong"}
+
+	var header metadata.MD
+	_, err = client.Login(context.Background(), req, grpc.Header(&header))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, []string{"2"}, header.Get("ratelimit-limit"))
+	assert.Equal(t, []string{"1"}, header.Get("ratelimit-remaining"))
+	_, err = client.Login(context.Background(), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.Login(context.Background(), req)
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	var retry *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		if d, ok := d.(*errdetails.RetryInfo); ok {
+			retry = d
+		}
+	}
+	if assert.NotNil(t, retry) {
+		assert.InDelta(t, 30, retry.RetryDelay.AsDuration().Seconds(), 1)
+	}
+	uc.AssertNumberOfCalls(t, "Login", 2)
+
+	_, err = client.CountUsers(withToken(t, "admin", model.RoleAdmin), &userpb.CountUsersRequest{})
+	assert.NoError(t, err, "methods without a rule are not limited")
+}
@@ -1,14 +1,17 @@
 package grpc
 
 import (
+	"7-solutions/apperr"
+	"7-solutions/auth"
+	"7-solutions/model"
 	userpb "7-solutions/proto"
 	"7-solutions/usecase"
 	"context"
-	"errors"
+	"net"
 	"time"
 
-	"github.com/google/uuid"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -22,68 +25,231 @@ func NewUserGRPCServer(uc usecase.UserUsecase) *UserGRPCServer {
 }
 
 func (s *UserGRPCServer) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.CreateUserResponse, error) {
-	err := s.Usecase.Register(ctx, req.Name, req.Email, req.Password)
+	user, err := s.Usecase.Register(ctx, req.Name, req.Email, req.Password)
 	if err != nil {
-		return nil, err
+		return nil, toStatus(err)
 	}
 
-	return &userpb.CreateUserResponse{
-		User: &userpb.User{
-			Id:        uuid.New().String(),
-			Name:      req.Name,
-			Email:     req.Email,
-			CreatedAt: time.Now().Format(time.RFC3339),
-		},
-	}, nil
+	return &userpb.CreateUserResponse{User: toProtoUser(user)}, nil
 }
 
-// func (s *UserGRPCServer) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
-// 	user, err := s.Usecase.GetUser(ctx, req.Id)
-// 	if err != nil {
-// 		return nil, err
-// 	}
+func (s *UserGRPCServer) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
+	user, err := s.Usecase.GetUser(ctx, req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
 
-// 	return &userpb.GetUserResponse{
-// 		User: &userpb.User{
-// 			Id:        user.ID.Hex(),
-// 			Name:      user.Name,
-// 			Email:     user.Email,
-// 			CreatedAt: user.CreatedAt.Format(time.RFC3339),
-// 		},
-// 	}, nil
-// }
+	return &userpb.GetUserResponse{User: toProtoUser(user)}, nil
+}
 
-func (s *UserGRPCServer) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
-	// ดึง user_id จาก context (อาจจะต้องส่งมาจาก interceptor)
-	userIDFromToken, err := getUserIDFromContext(ctx)
+// ListUsers streams every matching user, fetching page_size users per
+// database query so the full collection is never held in memory.
+func (s *UserGRPCServer) ListUsers(req *userpb.ListUsersRequest, stream userpb.UserService_ListUsersServer) error {
+	query, err := toUserQuery(req)
 	if err != nil {
-		return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+		return err
+	}
+
+	for {
+		page, err := s.Usecase.ListUsers(stream.Context(), query)
+		if err != nil {
+			return toStatus(err)
+		}
+		for i := range page.Users {
+			if err := stream.Send(toProtoUser(&page.Users[i])); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		query.After = page.NextCursor
 	}
+}
 
-	// เช็คสิทธิ์ ว่าขอข้อมูล user ตัวเองเท่านั้น (ถ้าต้องการ)
-	if userIDFromToken != req.Id {
-		return nil, status.Error(codes.PermissionDenied, "permission denied")
+func toUserQuery(req *userpb.ListUsersRequest) (model.UserQuery, error) {
+	query := model.UserQuery{
+		Filter: model.UserFilter{
+			NamePrefix:  req.NamePrefix,
+			EmailPrefix: req.EmailPrefix,
+		},
+		SortBy:     req.SortBy,
+		Descending: req.Descending,
+		Limit:      int64(req.PageSize),
+	}
+	if query.Limit <= 0 {
+		query.Limit = usecase.MaxPageSize
+	}
+
+	var err error
+	if req.CreatedAfter != "" {
+		if query.Filter.CreatedAfter, err = time.Parse(time.RFC3339, req.CreatedAfter); err != nil {
+			return query, status.Error(codes.InvalidArgument, "created_after must be an RFC 3339 timestamp")
+		}
+	}
+	if req.CreatedBefore != "" {
+		if query.Filter.CreatedBefore, err = time.Parse(time.RFC3339, req.CreatedBefore); err != nil {
+			return query, status.Error(codes.InvalidArgument, "created_before must be an RFC 3339 timestamp")
+		}
+	}
+	return query, nil
+}
+
+// WatchUsers pushes user changes made through this instance until the client
+// disconnects.
+func (s *UserGRPCServer) WatchUsers(req *userpb.WatchUsersRequest, stream userpb.UserService_WatchUsersServer) error {
+	events, cancel := s.Usecase.WatchUsers(stream.Context())
+	defer cancel()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(toProtoUserEvent(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *UserGRPCServer) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.UpdateUserResponse, error) {
+	if err := s.Usecase.UpdateUser(ctx, req.Id, req.Name, req.Email); err != nil {
+		return nil, toStatus(err)
 	}
 
 	user, err := s.Usecase.GetUser(ctx, req.Id)
 	if err != nil {
-		return nil, err
+		return nil, toStatus(err)
 	}
+	return &userpb.UpdateUserResponse{User: toProtoUser(user)}, nil
+}
 
-	return &userpb.GetUserResponse{
-		User: &userpb.User{
-			Id:        user.ID.Hex(),
-			Name:      user.Name,
-			Email:     user.Email,
-			CreatedAt: user.CreatedAt.Format(time.RFC3339),
-		},
-	}, nil
+func (s *UserGRPCServer) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
+	if err := s.Usecase.DeleteUser(ctx, req.Id); err != nil {
+		return nil, toStatus(err)
+	}
+	return &userpb.DeleteUserResponse{}, nil
 }
 
-func getUserIDFromContext(ctx context.Context) (string, error) {
-	userID, ok := ctx.Value("userID").(string)
-	if !ok || userID == "" {
-		return "", errors.New("userID not found in context")
+func (s *UserGRPCServer) UnlockUser(ctx context.Context, req *userpb.UnlockUserRequest) (*userpb.UnlockUserResponse, error) {
+	if err := s.Usecase.UnlockUser(ctx, req.Id); err != nil {
+		return nil, toStatus(err)
+	}
+	return &userpb.UnlockUserResponse{}, nil
+}
+
+func (s *UserGRPCServer) CountUsers(ctx context.Context, req *userpb.CountUsersRequest) (*userpb.CountUsersResponse, error) {
+	count, err := s.Usecase.CountUsers(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &userpb.CountUsersResponse{Count: count}, nil
+}
+
+func (s *UserGRPCServer) Login(ctx context.Context, req *userpb.LoginRequest) (*userpb.TokenResponse, error) {
+	if req.Email == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "email and password are required")
+	}
+
+	tokens, err := s.Usecase.Login(ctx, req.Email, req.Password, clientIP(ctx))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toTokenResponse(tokens), nil
+}
+
+func (s *UserGRPCServer) LoginMFA(ctx context.Context, req *userpb.LoginMFARequest) (*userpb.TokenResponse, error) {
+	if req.MfaToken == "" || req.Code == "" {
+		return nil, status.Error(codes.InvalidArgument, "mfa_token and code are required")
+	}
+
+	tokens, err := s.Usecase.LoginMFA(ctx, req.MfaToken, req.Code)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toTokenResponse(tokens), nil
+}
+
+func (s *UserGRPCServer) RefreshToken(ctx context.Context, req *userpb.RefreshTokenRequest) (*userpb.TokenResponse, error) {
+	tokens, err := s.Usecase.RefreshToken(ctx, req.RefreshToken)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toTokenResponse(tokens), nil
+}
+
+// ChangePassword changes the caller's own password and ends their other
+// sessions.
+func (s *UserGRPCServer) ChangePassword(ctx context.Context, req *userpb.ChangePasswordRequest) (*userpb.ChangePasswordResponse, error) {
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "not authenticated")
+	}
+	err := s.Usecase.ChangePassword(ctx, principal.UserID, principal.SessionID, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &userpb.ChangePasswordResponse{}, nil
+}
+
+func toProtoUser(user *model.User) *userpb.User {
+	return &userpb.User{
+		Id:        user.ID.Hex(),
+		Name:      user.Name,
+		Email:     user.Email,
+		Role:      string(user.Role),
+		CreatedAt: user.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+var protoEventTypes = map[model.UserEventType]userpb.UserEventType{
+	model.UserCreated: userpb.UserEventType_USER_EVENT_TYPE_CREATED,
+	model.UserUpdated: userpb.UserEventType_USER_EVENT_TYPE_UPDATED,
+	model.UserDeleted: userpb.UserEventType_USER_EVENT_TYPE_DELETED,
+}
+
+func toProtoUserEvent(event model.UserEvent) *userpb.UserEvent {
+	return &userpb.UserEvent{
+		Type:       protoEventTypes[event.Type],
+		User:       toProtoUser(&event.User),
+		OccurredAt: event.OccurredAt.Format(time.RFC3339),
+	}
+}
+
+func toTokenResponse(tokens *usecase.TokenPair) *userpb.TokenResponse {
+	if tokens.MFAToken != "" {
+		return &userpb.TokenResponse{
+			MfaRequired: true,
+			MfaToken:    tokens.MFAToken,
+			ExpiresIn:   int64(tokens.ExpiresIn.Seconds()),
+		}
+	}
+	return &userpb.TokenResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokens.ExpiresIn.Seconds()),
+	}
+}
+
+// toStatus maps domain errors to gRPC statuses with error details.
+func toStatus(err error) error {
+	return apperr.GRPCStatus(err).Err()
+}
+
+// clientIP is the host of the connected peer, or "" when it is unknown.
+func clientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
 	}
-	return userID, nil
+	return host
 }
@@ -0,0 +1,515 @@
+package grpc_test
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/auth"
+	grpcserver "7-solutions/grpc"
+	"7-solutions/keys"
+	"7-solutions/model"
+	"7-solutions/policy"
+	userpb "7-solutions/proto"
+	"7-solutions/repository"
+	"7-solutions/usecase"
+	"7-solutions/utils"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testKeys signs the tokens of test callers with an Ed25519 key, and
+// testSigner adds the issuer and audience the interceptor expects.
+var (
+	testKeys   = newTestKeys()
+	testSigner = auth.NewSigner(testKeys, "test", auth.DefaultAudience)
+)
+
+func newTestKeys() *keys.Manager {
+	key, err := keys.Generate(keys.EdDSA)
+	if err != nil {
+		panic(err)
+	}
+	m, err := keys.NewManager(key)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+type MockUsecase struct {
+	mock.Mock
+}
+
+func (m *MockUsecase) Register(ctx context.Context, name, email, password string) (*model.User, error) {
+	args := m.Called(ctx, name, email, password)
+	user, _ := args.Get(0).(*model.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUsecase) Login(ctx context.Context, email, password, clientIP string) (*usecase.TokenPair, error) {
+	args := m.Called(ctx, email, password, clientIP)
+	tokens, _ := args.Get(0).(*usecase.TokenPair)
+	return tokens, args.Error(1)
+}
+
+func (m *MockUsecase) Authenticate(ctx context.Context, email, password, clientIP string) (*model.User, string, error) {
+	args := m.Called(ctx, email, password, clientIP)
+	user, _ := args.Get(0).(*model.User)
+	return user, args.String(1), args.Error(2)
+}
+
+func (m *MockUsecase) AuthenticateMFA(ctx context.Context, mfaToken, code string) (*model.User, error) {
+	args := m.Called(ctx, mfaToken, code)
+	user, _ := args.Get(0).(*model.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUsecase) StartExternalLogin(ctx context.Context, provider string) (string, string, error) {
+	args := m.Called(ctx, provider)
+	return args.String(0), args.String(1), args.Error(2)
+}
+
+func (m *MockUsecase) FinishExternalLogin(ctx context.Context, provider, state, code string) (*usecase.TokenPair, error) {
+	args := m.Called(ctx, provider, state, code)
+	tokens, _ := args.Get(0).(*usecase.TokenPair)
+	return tokens, args.Error(1)
+}
+
+func (m *MockUsecase) RefreshToken(ctx context.Context, refreshToken string) (*usecase.TokenPair, error) {
+	args := m.Called(ctx, refreshToken)
+	tokens, _ := args.Get(0).(*usecase.TokenPair)
+	return tokens, args.Error(1)
+}
+
+func (m *MockUsecase) Logout(ctx context.Context, userID, jti, sessionID string, expiresAt time.Time) error {
+	return m.Called(ctx, userID, jti, sessionID, expiresAt).Error(0)
+}
+
+func (m *MockUsecase) LogoutAll(ctx context.Context, userID string, revokeAPIKeys bool) error {
+	return m.Called(ctx, userID, revokeAPIKeys).Error(0)
+}
+
+func (m *MockUsecase) GetUser(ctx context.Context, id string) (*model.User, error) {
+	args := m.Called(ctx, id)
+	user, _ := args.Get(0).(*model.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUsecase) ListUsers(ctx context.Context, query model.UserQuery) (*model.UserPage, error) {
+	args := m.Called(ctx, query)
+	page, _ := args.Get(0).(*model.UserPage)
+	return page, args.Error(1)
+}
+
+func (m *MockUsecase) WatchUsers(ctx context.Context) (<-chan model.UserEvent, func()) {
+	args := m.Called(ctx)
+	return args.Get(0).(chan model.UserEvent), func() {}
+}
+
+func (m *MockUsecase) UpdateUser(ctx context.Context, id string, name, email string) error {
+	return m.Called(ctx, id, name, email).Error(0)
+}
+
+func (m *MockUsecase) DeleteUser(ctx context.Context, id string) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func (m *MockUsecase) CountUsers(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockUsecase) VerifyEmail(ctx context.Context, token string) error {
+	return m.Called(ctx, token).Error(0)
+}
+
+func (m *MockUsecase) ResendVerification(ctx context.Context, email string) error {
+	return m.Called(ctx, email).Error(0)
+}
+
+func (m *MockUsecase) ChangePassword(ctx context.Context, userID, sessionID, currentPassword, newPassword string) error {
+	return m.Called(ctx, userID, sessionID, currentPassword, newPassword).Error(0)
+}
+
+func (m *MockUsecase) EnrollTOTP(ctx context.Context, userID string) (*usecase.TOTPEnrollment, error) {
+	args := m.Called(ctx, userID)
+	enrollment, _ := args.Get(0).(*usecase.TOTPEnrollment)
+	return enrollment, args.Error(1)
+}
+
+func (m *MockUsecase) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	args := m.Called(ctx, userID, code)
+	codes, _ := args.Get(0).([]string)
+	return codes, args.Error(1)
+}
+
+func (m *MockUsecase) DisableTOTP(ctx context.Context, userID, password, code string) error {
+	return m.Called(ctx, userID, password, code).Error(0)
+}
+
+func (m *MockUsecase) LoginMFA(ctx context.Context, mfaToken, code string) (*usecase.TokenPair, error) {
+	args := m.Called(ctx, mfaToken, code)
+	tokens, _ := args.Get(0).(*usecase.TokenPair)
+	return tokens, args.Error(1)
+}
+
+func (m *MockUsecase) UnlockUser(ctx context.Context, id string) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func (m *MockUsecase) ForgotPassword(ctx context.Context, email string) error {
+	return m.Called(ctx, email).Error(0)
+}
+
+func (m *MockUsecase) ResetPassword(ctx context.Context, token, newPassword string) error {
+	return m.Called(ctx, token, newPassword).Error(0)
+}
+
+// testAPIKeys accepts "7s_alice", a read-only key of the user with aliceID.
+type testAPIKeys struct{}
+
+var aliceID = primitive.NewObjectID()
+
+func (testAPIKeys) AuthenticateAPIKey(ctx context.Context, secret string) (*model.APIKey, *model.User, error) {
+	if secret != "7s_alice" {
+		return nil, nil, usecase.ErrInvalidAPIKey
+	}
+	key := &model.APIKey{ID: primitive.NewObjectID(), UserID: aliceID.Hex(), Scopes: []string{policy.ScopeUsersRead}}
+	return key, &model.User{ID: aliceID, Role: model.RoleUser}, nil
+}
+
+// newTestClient serves uc behind the auth interceptor. Interceptors given in
+// opts run before it.
+func newTestClient(t *testing.T, uc usecase.UserUsecase, opts ...grpc.ServerOption) userpb.UserServiceClient {
+	lis := bufconn.Listen(1 << 20)
+	interceptor := grpcserver.NewAuthInterceptor(
+		auth.NewVerifier(testKeys, "test", auth.DefaultAudience, auth.WithRevocations(repository.NewMemoryTokenStore())),
+		testAPIKeys{}, policy.Default(), grpcserver.DefaultPublicMethods)
+	srv := grpc.NewServer(append(opts,
+		grpc.ChainUnaryInterceptor(interceptor.Unary()),
+		grpc.ChainStreamInterceptor(interceptor.Stream()),
+	)...)
+	userpb.RegisterUserServiceServer(srv, grpcserver.NewUserGRPCServer(uc))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return userpb.NewUserServiceClient(conn)
+}
+
+func withToken(t *testing.T, userID string, role model.Role) context.Context {
+	token, err := utils.GenerateJWT(userID, string(role), "session", policy.RoleScopes(role), testSigner, time.Minute)
+	require.NoError(t, err)
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestLogin_IsPublic(t *testing.T) {
+	uc := new(MockUsecase)
+	uc.On("Login", mock.Anything, "a@example.com", "secret", mock.Anything).
+		Return(&usecase.TokenPair{AccessToken: "access", RefreshToken: "refresh", ExpiresIn: time.Minute}, nil)
+	uc.On("Login", mock.Anything, "a@example.com", "wrong", mock.Anything).Return(nil, usecase.ErrInvalidCredentials)
+	client := newTestClient(t, uc)
+
+	resp, err := client.Login(context.Background(), &userpb.LoginRequest{Email: "a@example.com", Password: "secret"})
+	require.NoError(t, err)
+	assert.Equal(t, "access", resp.AccessToken)
+	assert.Equal(t, "refresh", resp.RefreshToken)
+	assert.Equal(t, int64(60), resp.ExpiresIn)
+
+	_, err = client.Login(context.Background(), &userpb.LoginRequest{Email: "a@example.com", Password: "wrong"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.Login(context.Background(), &userpb.LoginRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestLoginMFA_IsPublic(t *testing.T) {
+	uc := new(MockUsecase)
+	uc.On("Login", mock.Anything, "a@example.com", "secret", mock.Anything).
+		Return(&usecase.TokenPair{MFAToken: "pending", ExpiresIn: usecase.MFATokenTTL}, nil)
+	uc.On("LoginMFA", mock.Anything, "pending", "123456").
+		Return(&usecase.TokenPair{AccessToken: "access", RefreshToken: "refresh", ExpiresIn: time.Minute}, nil)
+	client := newTestClient(t, uc)
+
+	resp, err := client.Login(context.Background(), &userpb.LoginRequest{Email: "a@example.com", Password: "secret"})
+	require.NoError(t, err)
+	assert.True(t, resp.MfaRequired)
+	assert.Equal(t, "pending", resp.MfaToken)
+	assert.Empty(t, resp.AccessToken)
+	assert.Equal(t, int64(300), resp.ExpiresIn)
+
+	resp, err = client.LoginMFA(context.Background(), &userpb.LoginMFARequest{MfaToken: "pending", Code: "123456"})
+	require.NoError(t, err)
+	assert.Equal(t, "access", resp.AccessToken)
+	assert.False(t, resp.MfaRequired)
+
+	_, err = client.LoginMFA(context.Background(), &userpb.LoginMFARequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCreateUser_IsPublic(t *testing.T) {
+	uc := new(MockUsecase)
+	created := &model.User{
+		ID:        primitive.NewObjectID(),
+		Name:      "Alice",
+		Email:     "alice@example.com",
+		Role:      model.RoleUser,
+		CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	uc.On("Register", mock.Anything, "Alice", "alice@example.com", "secret").Return(created, nil)
+	client := newTestClient(t, uc)
+
+	resp, err := client.CreateUser(context.Background(), &userpb.CreateUserRequest{
+		Name:     "Alice",
+		Email:    "alice@example.com",
+		Password: "secret",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, created.ID.Hex(), resp.User.Id)
+	assert.Equal(t, "alice@example.com", resp.User.Email)
+	assert.Equal(t, "2024-05-01T12:00:00Z", resp.User.CreatedAt)
+}
+
+func TestCreateUser_EmailTaken(t *testing.T) {
+	uc := new(MockUsecase)
+	uc.On("Register", mock.Anything, "Alice", "alice@example.com", "secret").Return(nil, repository.ErrEmailTaken)
+	client := newTestClient(t, uc)
+
+	_, err := client.CreateUser(context.Background(), &userpb.CreateUserRequest{
+		Name:     "Alice",
+		Email:    "alice@example.com",
+		Password: "secret",
+	})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+}
+
+func TestProtectedMethods_RequireToken(t *testing.T) {
+	client := newTestClient(t, new(MockUsecase))
+
+	stream, err := client.ListUsers(context.Background(), &userpb.ListUsersRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.GetUser(context.Background(), &userpb.GetUserRequest{Id: "alice"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAPIKeys(t *testing.T) {
+	uc := new(MockUsecase)
+	uc.On("GetUser", mock.Anything, aliceID.Hex()).Return(&model.User{ID: aliceID, Name: "Alice"}, nil)
+	client := newTestClient(t, uc)
+
+	for _, md := range [][]string{{"authorization", "ApiKey 7s_alice"}, {"x-api-key", "7s_alice"}} {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), md...)
+		resp, err := client.GetUser(ctx, &userpb.GetUserRequest{Id: aliceID.Hex()})
+		require.NoError(t, err, md[0])
+		assert.Equal(t, "Alice", resp.User.Name)
+
+		_, err = client.GetUser(ctx, &userpb.GetUserRequest{Id: "bob"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err), "keys act with their user's permissions")
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "ApiKey 7s_alice")
+	_, err := client.ChangePassword(ctx, &userpb.ChangePasswordRequest{CurrentPassword: "a", NewPassword: "b"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.UpdateUser(ctx, &userpb.UpdateUserRequest{Id: aliceID.Hex(), Name: "Alicia"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "the key is read-only")
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "ApiKey 7s_revoked")
+	_, err = client.GetUser(ctx, &userpb.GetUserRequest{Id: aliceID.Hex()})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestScopes(t *testing.T) {
+	uc := new(MockUsecase)
+	uc.On("GetUser", mock.Anything, "admin").Return(&model.User{Name: "Admin"}, nil)
+	client := newTestClient(t, uc)
+
+	token, err := utils.GenerateJWT("admin", string(model.RoleAdmin), "session", []string{policy.ScopeUsersRead}, testSigner, time.Minute)
+	require.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+
+	_, err = client.GetUser(ctx, &userpb.GetUserRequest{Id: "admin"})
+	assert.NoError(t, err)
+	_, err = client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: "bob"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "an admin's read-only token cannot delete")
+	uc.AssertNotCalled(t, "DeleteUser", mock.Anything, mock.Anything)
+}
+
+func TestAuth_RejectsOtherAudiences(t *testing.T) {
+	client := newTestClient(t, new(MockUsecase))
+
+	// Tokens for OpenID Connect clients are signed by the same keys but
+	// carry no audience for this API.
+	token, err := utils.GenerateJWT("alice", string(model.RoleUser), "session", []string{policy.ScopeUsersRead}, testKeys, time.Minute)
+	require.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	_, err = client.GetUser(ctx, &userpb.GetUserRequest{Id: "alice"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestDeleteUser_AdminOnly(t *testing.T) {
+	uc := new(MockUsecase)
+	uc.On("DeleteUser", mock.Anything, "bob").Return(nil)
+	uc.On("DeleteUser", mock.Anything, "ghost").Return(repository.ErrUserNotFound)
+	client := newTestClient(t, uc)
+
+	_, err := client.DeleteUser(withToken(t, "alice", model.RoleUser), &userpb.DeleteUserRequest{Id: "bob"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.DeleteUser(withToken(t, "admin", model.RoleAdmin), &userpb.DeleteUserRequest{Id: "bob"})
+	assert.NoError(t, err)
+
+	_, err = client.DeleteUser(withToken(t, "admin", model.RoleAdmin), &userpb.DeleteUserRequest{Id: "ghost"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestUpdateUser_Self(t *testing.T) {
+	uc := new(MockUsecase)
+	uc.On("UpdateUser", mock.Anything, "alice", "Alice", "alice@example.com").Return(nil)
+	uc.On("GetUser", mock.Anything, "alice").Return(&model.User{Name: "Alice", Email: "alice@example.com"}, nil)
+	client := newTestClient(t, uc)
+
+	resp, err := client.UpdateUser(withToken(t, "alice", model.RoleUser), &userpb.UpdateUserRequest{
+		Id:    "alice",
+		Name:  "Alice",
+		Email: "alice@example.com",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", resp.User.Name)
+
+	_, err = client.UpdateUser(withToken(t, "alice", model.RoleUser), &userpb.UpdateUserRequest{Id: "bob"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestChangePassword_UsesCallerSession(t *testing.T) {
+	uc := new(MockUsecase)
+	uc.On("ChangePassword", mock.Anything, "alice", "session", "old-password", "new-password").Return(nil)
+	client := newTestClient(t, uc)
+
+	_, err := client.ChangePassword(withToken(t, "alice", model.RoleUser), &userpb.ChangePasswordRequest{
+		CurrentPassword: "old-password",
+		NewPassword:     "new-password",
+	})
+	require.NoError(t, err)
+	uc.AssertExpectations(t)
+
+	_, err = client.ChangePassword(context.Background(), &userpb.ChangePasswordRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestLogin_Locked(t *testing.T) {
+	uc := new(MockUsecase)
+	uc.On("Login", mock.Anything, "a@example.com", "secret", mock.Anything).
+		Return(nil, apperr.WithRetryAfter(usecase.ErrAccountLocked, time.Minute))
+	client := newTestClient(t, uc)
+
+	_, err := client.Login(context.Background(), &userpb.LoginRequest{Email: "a@example.com", Password: "secret"})
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	var retry *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		if d, ok := d.(*errdetails.RetryInfo); ok {
+			retry = d
+		}
+	}
+	if assert.NotNil(t, retry) {
+		assert.Equal(t, time.Minute, retry.RetryDelay.AsDuration())
+	}
+}
+
+func TestUnlockUser_AdminOnly(t *testing.T) {
+	uc := new(MockUsecase)
+	uc.On("UnlockUser", mock.Anything, "bob").Return(nil).Once()
+	client := newTestClient(t, uc)
+
+	_, err := client.UnlockUser(withToken(t, "alice", model.RoleUser), &userpb.UnlockUserRequest{Id: "bob"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.UnlockUser(withToken(t, "admin", model.RoleAdmin), &userpb.UnlockUserRequest{Id: "bob"})
+	require.NoError(t, err)
+	uc.AssertExpectations(t)
+}
+
+func TestListUsers_StreamsAllPages(t *testing.T) {
+	users := []model.User{
+		{ID: primitive.NewObjectID(), Name: "a"},
+		{ID: primitive.NewObjectID(), Name: "b"},
+		{ID: primitive.NewObjectID(), Name: "c"},
+	}
+	uc := new(MockUsecase)
+	first := model.UserQuery{Filter: model.UserFilter{NamePrefix: "a"}, Limit: 2}
+	second := first
+	second.After = "cursor-1"
+	uc.On("ListUsers", mock.Anything, first).Return(&model.UserPage{Users: users[:2], NextCursor: "cursor-1", Total: 3}, nil)
+	uc.On("ListUsers", mock.Anything, second).Return(&model.UserPage{Users: users[2:], Total: 3}, nil)
+	client := newTestClient(t, uc)
+
+	stream, err := client.ListUsers(withToken(t, "admin", model.RoleAdmin), &userpb.ListUsersRequest{PageSize: 2, NamePrefix: "a"})
+	require.NoError(t, err)
+
+	var names []string
+	for {
+		user, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, user.Name)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, names)
+	uc.AssertExpectations(t)
+}
+
+func TestListUsers_InvalidTimestamp(t *testing.T) {
+	client := newTestClient(t, new(MockUsecase))
+
+	stream, err := client.ListUsers(withToken(t, "admin", model.RoleAdmin), &userpb.ListUsersRequest{CreatedAfter: "yesterday"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestListUsers_AdminOnly(t *testing.T) {
+	client := newTestClient(t, new(MockUsecase))
+
+	stream, err := client.ListUsers(withToken(t, "alice", model.RoleUser), &userpb.ListUsersRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestWatchUsers_PushesEvents(t *testing.T) {
+	events := make(chan model.UserEvent, 1)
+	uc := new(MockUsecase)
+	uc.On("WatchUsers", mock.Anything).Return(events)
+	client := newTestClient(t, uc)
+
+	stream, err := client.WatchUsers(withToken(t, "admin", model.RoleAdmin), &userpb.WatchUsersRequest{})
+	require.NoError(t, err)
+
+	events <- model.UserEvent{Type: model.UserCreated, User: model.User{Name: "Alice"}, OccurredAt: time.Now()}
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, userpb.UserEventType_USER_EVENT_TYPE_CREATED, event.Type)
+	assert.Equal(t, "Alice", event.User.Name)
+}
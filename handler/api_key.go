@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/middleware"
+	"7-solutions/policy"
+	"7-solutions/usecase"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHandler lets users manage their own API keys. Keys are managed with
+// an access token only; a key cannot create or revoke keys.
+type APIKeyHandler struct {
+	Usecase usecase.APIKeyUsecase
+}
+
+func NewAPIKeyHandler(r *gin.Engine, uc usecase.APIKeyUsecase, auth gin.HandlerFunc) {
+	h := &APIKeyHandler{Usecase: uc}
+
+	keys := r.Group("/users/me/api-keys", auth, middleware.RequireSession(), middleware.RequireScopes(policy.ScopeUsersWrite))
+	keys.POST("", h.Create)
+	keys.GET("", h.List)
+	keys.DELETE("/:key_id", h.Revoke)
+}
+
+// Create returns the key itself once; only its hash is kept.
+func (h *APIKeyHandler) Create(c *gin.Context) {
+	var req struct {
+		Name      string     `json:"name" binding:"required"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.WriteProblem(c, bindError(err))
+		return
+	}
+	key, secret, err := h.Usecase.CreateAPIKey(c.Request.Context(), middleware.Principal(c).UserID, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, gin.H{
+		"id":         key.ID.Hex(),
+		"name":       key.Name,
+		"key":        secret,
+		"prefix":     key.Prefix,
+		"scopes":     key.Scopes,
+		"expires_at": key.ExpiresAt,
+		"created_at": key.CreatedAt,
+	})
+}
+
+func (h *APIKeyHandler) List(c *gin.Context) {
+	keys, err := h.Usecase.ListAPIKeys(c.Request.Context(), middleware.Principal(c).UserID)
+	if err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": keys})
+}
+
+func (h *APIKeyHandler) Revoke(c *gin.Context) {
+	if err := h.Usecase.RevokeAPIKey(c.Request.Context(), middleware.Principal(c).UserID, c.Param("key_id")); err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "api key revoked"})
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/usecase"
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// externalStateCookie binds the sign-in state to the browser that started
+// it, so a callback URL sent to someone else does not sign them in.
+const externalStateCookie = "external_login_state"
+
+// ExternalLogin redirects to the identity provider's sign-in page.
+func (h *UserHandler) ExternalLogin(c *gin.Context) {
+	authURL, state, err := h.Usecase.StartExternalLogin(c.Request.Context(), c.Param("provider"))
+	if err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(externalStateCookie, state, int(usecase.ExternalLoginTTL.Seconds()), "/auth", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// ExternalCallback is where the identity provider sends the user back. It
+// answers like Login.
+func (h *UserHandler) ExternalCallback(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	if providerErr := c.Query("error"); providerErr != "" {
+		apperr.WriteProblem(c, apperr.New(apperr.ErrUnauthorized, "identity provider sign-in failed: "+providerErr))
+		return
+	}
+	state := c.Query("state")
+	cookie, _ := c.Cookie(externalStateCookie)
+	c.SetCookie(externalStateCookie, "", -1, "/auth", "", c.Request.TLS != nil, true)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie)) != 1 {
+		apperr.WriteProblem(c, usecase.ErrInvalidExternalState)
+		return
+	}
+
+	tokens, err := h.Usecase.FinishExternalLogin(c.Request.Context(), c.Param("provider"), state, c.Query("code"))
+	if err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokenResponse(tokens))
+}
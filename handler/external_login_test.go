@@ -0,0 +1,90 @@
+package handler_test
+
+import (
+	"7-solutions/connector"
+	"7-solutions/handler"
+	"7-solutions/model"
+	"7-solutions/policy"
+	"7-solutions/repository"
+	"7-solutions/usecase"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// idpConnector signs everyone in as one identity.
+type idpConnector struct{}
+
+func (idpConnector) ID() string { return "idp" }
+
+func (idpConnector) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	return "https://idp.example.com/authorize?state=" + url.QueryEscape(state), nil
+}
+
+func (idpConnector) Exchange(ctx context.Context, code, nonce, verifier string) (*connector.Identity, error) {
+	return &connector.Identity{Provider: "idp", Subject: "42", Email: "alice@example.com", EmailVerified: true}, nil
+}
+
+func newExternalLoginRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	users := &stubUsers{users: []*model.User{{
+		ID:         primitive.NewObjectID(),
+		Email:      "alice@example.com",
+		Identities: []model.Identity{{Provider: "idp", Subject: "42"}},
+	}}}
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour,
+		usecase.WithConnectors(idpConnector{}),
+	)
+	r := gin.New()
+	handler.NewUserHandler(r, uc, func(c *gin.Context) { c.Next() }, policy.Default())
+	return r
+}
+
+func TestExternalLogin(t *testing.T) {
+	r := newExternalLoginRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/idp/login", nil))
+	require.Equal(t, http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "idp.example.com", location.Host)
+	state := location.Query().Get("state")
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, state, cookies[0].Value)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+
+	callback := "/auth/idp/callback?code=abc&state=" + url.QueryEscape(state)
+
+	// Without the cookie the callback was not started by this browser.
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, callback, nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, callback, nil)
+	req.AddCookie(cookies[0])
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.NotEmpty(t, body["access_token"])
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+}
+
+func TestExternalLogin_UnknownProvider(t *testing.T) {
+	w := httptest.NewRecorder()
+	newExternalLoginRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/nope/login", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"7-solutions/keys"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKSMaxAge is how long clients may cache the key set. Rotated-out keys must
+// stay published at least this long after their last token expires.
+const JWKSMaxAge = "max-age=300"
+
+// KeySet publishes the public keys tokens are verified with.
+type KeySet interface {
+	JWKS() keys.JWKS
+}
+
+func NewJWKSHandler(r *gin.Engine, ks KeySet) {
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, "+JWKSMaxAge)
+		c.JSON(http.StatusOK, ks.JWKS())
+	})
+}
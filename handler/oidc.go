@@ -0,0 +1,292 @@
+package handler
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/middleware"
+	"7-solutions/model"
+	"7-solutions/policy"
+	"7-solutions/usecase"
+	"errors"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var signInPage = template.Must(template.New("sign-in").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Sign in to {{.Client}}</title></head>
+<body>
+<h1>Sign in to {{.Client}}</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>
+{{end}}<form method="post" action="/authorize">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}{{if .MFAToken}}<input type="hidden" name="mfa_token" value="{{.MFAToken}}">
+<label>Authentication or recovery code <input name="code" autocomplete="one-time-code" required autofocus></label>
+{{else}}<label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required autofocus></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+{{end}}<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+type signInForm struct {
+	Client   string
+	Params   map[string]string
+	Email    string
+	MFAToken string
+	Error    string
+}
+
+// OIDCHandler serves the OpenID Connect provider endpoints. Users sign in on
+// a plain HTML form at /authorize.
+type OIDCHandler struct {
+	Provider usecase.OIDCProvider
+	Users    usecase.UserUsecase
+}
+
+func NewOIDCHandler(r *gin.Engine, provider usecase.OIDCProvider, users usecase.UserUsecase, auth gin.HandlerFunc, p policy.Policy) {
+	h := &OIDCHandler{Provider: provider, Users: users}
+
+	r.GET("/.well-known/openid-configuration", h.Discovery)
+	r.GET("/authorize", h.Authorize)
+	r.POST("/authorize", h.SignIn)
+	r.POST("/token", h.Token)
+	r.GET("/userinfo", h.UserInfo)
+	r.POST("/userinfo", h.UserInfo)
+	r.POST("/oauth/clients", auth, middleware.RequireScopes(policy.ScopeUsersAdmin), middleware.Authorize(p, policy.ActionRegisterOAuthClient), h.RegisterClient)
+}
+
+func (h *OIDCHandler) Discovery(c *gin.Context) {
+	c.Header("Cache-Control", "public, "+JWKSMaxAge)
+	c.JSON(http.StatusOK, h.Provider.Discovery())
+}
+
+func authorizationRequest(param func(string) string) usecase.AuthorizationRequest {
+	return usecase.AuthorizationRequest{
+		ClientID:            param("client_id"),
+		RedirectURI:         param("redirect_uri"),
+		ResponseType:        param("response_type"),
+		Scope:               param("scope"),
+		State:               param("state"),
+		Nonce:               param("nonce"),
+		CodeChallenge:       param("code_challenge"),
+		CodeChallengeMethod: param("code_challenge_method"),
+	}
+}
+
+// checkAuthorization returns the client of a valid request. Otherwise it
+// answers the request itself: with a problem when the redirect URI cannot be
+// trusted, else by redirecting the error back to the client.
+func (h *OIDCHandler) checkAuthorization(c *gin.Context, req usecase.AuthorizationRequest) (*model.OAuthClient, bool) {
+	client, err := h.Provider.AuthorizationClient(c.Request.Context(), req.ClientID, req.RedirectURI)
+	if err != nil {
+		apperr.WriteProblem(c, err)
+		return nil, false
+	}
+	if err := h.Provider.CheckAuthorization(req); err != nil {
+		var oauthErr *usecase.OAuthError
+		if !errors.As(err, &oauthErr) {
+			apperr.WriteProblem(c, err)
+			return nil, false
+		}
+		c.Redirect(http.StatusFound, h.Provider.RedirectURI(req.RedirectURI, url.Values{
+			"error":             {oauthErr.Code},
+			"error_description": {oauthErr.Error()},
+		}, req.State))
+		return nil, false
+	}
+	return client, true
+}
+
+func (h *OIDCHandler) Authorize(c *gin.Context) {
+	req := authorizationRequest(c.Query)
+	client, ok := h.checkAuthorization(c, req)
+	if !ok {
+		return
+	}
+	renderSignIn(c, http.StatusOK, client, req, signInForm{})
+}
+
+// SignIn checks the credentials posted from the sign-in form, then the
+// second factor if the user has one, and redirects back with a code.
+func (h *OIDCHandler) SignIn(c *gin.Context) {
+	req := authorizationRequest(c.PostForm)
+	client, ok := h.checkAuthorization(c, req)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	var user *model.User
+	var err error
+	form := signInForm{Email: c.PostForm("email")}
+	if mfaToken := c.PostForm("mfa_token"); mfaToken != "" {
+		user, err = h.Users.AuthenticateMFA(ctx, mfaToken, c.PostForm("code"))
+		if errors.Is(err, usecase.ErrInvalidMFACode) {
+			// The token stays valid, so the user may try another code.
+			form.MFAToken = mfaToken
+		}
+	} else {
+		var mfaToken string
+		user, mfaToken, err = h.Users.Authenticate(ctx, form.Email, c.PostForm("password"), c.ClientIP())
+		if err == nil && mfaToken != "" {
+			renderSignIn(c, http.StatusOK, client, req, signInForm{MFAToken: mfaToken})
+			return
+		}
+	}
+	if err != nil {
+		signInError(c, client, req, form, err)
+		return
+	}
+
+	redirect, err := h.Provider.Authorize(ctx, req, user)
+	if err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, redirect)
+}
+
+// signInError shows the form again with the message and status err maps to.
+// Unexpected errors get the usual problem response.
+func signInError(c *gin.Context, client *model.OAuthClient, req usecase.AuthorizationRequest, form signInForm, err error) {
+	problem := apperr.NewProblem(err, c.Request.URL.Path)
+	if problem.Status == http.StatusInternalServerError {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	if after, ok := apperr.RetryAfter(err); ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(after.Seconds()))))
+	}
+	form.Error = problem.Detail
+	renderSignIn(c, problem.Status, client, req, form)
+}
+
+func renderSignIn(c *gin.Context, status int, client *model.OAuthClient, req usecase.AuthorizationRequest, form signInForm) {
+	form.Client = client.Name
+	form.Params = map[string]string{}
+	for name, value := range map[string]string{
+		"client_id":             req.ClientID,
+		"redirect_uri":          req.RedirectURI,
+		"response_type":         req.ResponseType,
+		"scope":                 req.Scope,
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+	} {
+		if value != "" {
+			form.Params[name] = value
+		}
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "frame-ancestors 'none'")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	if err := signInPage.Execute(c.Writer, form); err != nil {
+		_ = c.Error(err)
+	}
+}
+
+// Token redeems an authorization code. Confidential clients authenticate with
+// HTTP Basic or client_secret in the form.
+func (h *OIDCHandler) Token(c *gin.Context) {
+	req := usecase.TokenRequest{
+		GrantType:    c.PostForm("grant_type"),
+		Code:         c.PostForm("code"),
+		RedirectURI:  c.PostForm("redirect_uri"),
+		ClientID:     c.PostForm("client_id"),
+		ClientSecret: c.PostForm("client_secret"),
+		CodeVerifier: c.PostForm("code_verifier"),
+	}
+	if id, secret, ok := c.Request.BasicAuth(); ok {
+		// RFC 6749 form-encodes the credentials before Basic encoding.
+		req.ClientID, _ = url.QueryUnescape(id)
+		req.ClientSecret, _ = url.QueryUnescape(secret)
+	}
+
+	c.Header("Cache-Control", "no-store")
+	tokens, err := h.Provider.Exchange(c.Request.Context(), req)
+	if err != nil {
+		if errors.Is(err, usecase.ErrInvalidClient) {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		writeOAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": tokens.AccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int64(tokens.ExpiresIn.Seconds()),
+		"id_token":     tokens.IDToken,
+		"scope":        tokens.Scope,
+	})
+}
+
+func (h *OIDCHandler) UserInfo(c *gin.Context) {
+	scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !strings.EqualFold(scheme, "bearer") || token == "" {
+		c.Header("WWW-Authenticate", `Bearer`)
+		writeOAuthError(c, usecase.ErrInvalidAccessToken)
+		return
+	}
+	claims, err := h.Provider.UserInfo(c.Request.Context(), token)
+	if err != nil {
+		if errors.Is(err, usecase.ErrInvalidAccessToken) {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		}
+		writeOAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, claims)
+}
+
+// writeOAuthError answers with the RFC 6749 error body, or a problem for
+// errors that are not OAuth errors.
+func writeOAuthError(c *gin.Context, err error) {
+	var oauthErr *usecase.OAuthError
+	if !errors.As(err, &oauthErr) {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.AbortWithStatusJSON(apperr.NewProblem(err, "").Status, gin.H{
+		"error":             oauthErr.Code,
+		"error_description": oauthErr.Error(),
+	})
+}
+
+// RegisterClient returns the client secret once; only its hash is kept.
+func (h *OIDCHandler) RegisterClient(c *gin.Context) {
+	var req struct {
+		Name         string   `json:"name" binding:"required"`
+		RedirectURIs []string `json:"redirect_uris" binding:"required"`
+		Public       bool     `json:"public"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.WriteProblem(c, bindError(err))
+		return
+	}
+	client, secret, err := h.Provider.RegisterClient(c.Request.Context(), req.Name, req.RedirectURIs, req.Public)
+	if err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	resp := gin.H{
+		"client_id":     client.ID,
+		"name":          client.Name,
+		"redirect_uris": client.RedirectURIs,
+		"public":        client.Public(),
+		"created_at":    client.CreatedAt,
+	}
+	if secret != "" {
+		resp["client_secret"] = secret
+	}
+	c.JSON(http.StatusCreated, resp)
+}
@@ -0,0 +1,425 @@
+package handler_test
+
+import (
+	"7-solutions/handler"
+	"7-solutions/keys"
+	"7-solutions/model"
+	"7-solutions/policy"
+	"7-solutions/repository"
+	"7-solutions/usecase"
+	"7-solutions/utils"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const callback = "https://app.example.com/callback"
+
+// stubUsers serves the users the OIDC and external login flows read. Other methods are not
+// expected to be called.
+type stubUsers struct {
+	repository.UsersRepository
+	users []*model.User
+}
+
+func (s *stubUsers) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	for _, u := range s.users {
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (s *stubUsers) GetByID(ctx context.Context, id string) (*model.User, error) {
+	for _, u := range s.users {
+		if u.ID.Hex() == id {
+			return u, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (s *stubUsers) GetByIdentity(ctx context.Context, provider, subject string) (*model.User, error) {
+	for _, u := range s.users {
+		for _, identity := range u.Identities {
+			if identity.Provider == provider && identity.Subject == subject {
+				return u, nil
+			}
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+type memoryClients map[string]*model.OAuthClient
+
+func (m memoryClients) Create(ctx context.Context, client *model.OAuthClient) (*model.OAuthClient, error) {
+	created := *client
+	created.CreatedAt = time.Now()
+	m[client.ID] = &created
+	return &created, nil
+}
+
+func (m memoryClients) GetByID(ctx context.Context, clientID string) (*model.OAuthClient, error) {
+	client, ok := m[clientID]
+	if !ok {
+		return nil, repository.ErrClientNotFound
+	}
+	return client, nil
+}
+
+type oidcFixture struct {
+	server   *httptest.Server
+	http     *http.Client
+	provider usecase.OIDCProvider
+	keys     *keys.Manager
+	user     *model.User
+	clientID string
+	secret   string
+}
+
+func newOIDCFixture(t *testing.T) *oidcFixture {
+	return newOIDCFixtureWithKey(t, keys.EdDSA)
+}
+
+// newOIDCFixtureWithKey signs tokens with a new key for the algorithm alg.
+func newOIDCFixtureWithKey(t *testing.T, alg string) *oidcFixture {
+	gin.SetMode(gin.TestMode)
+	hasher := utils.NewBcryptHasher(4)
+	hashed, err := hasher.Hash("correct horse battery")
+	require.NoError(t, err)
+	user := &model.User{ID: primitive.NewObjectID(), Name: "Alice", Email: "alice@example.com", Password: hashed, Verified: true}
+	users := &stubUsers{users: []*model.User{user}}
+
+	key, err := keys.Generate(alg)
+	require.NoError(t, err)
+	tokenKeys, err := keys.NewManager(key)
+	require.NoError(t, err)
+	tokens := repository.NewMemoryTokenStore()
+	uc := usecase.NewUserUsecase(users, tokens, "secret", time.Minute, time.Hour,
+		usecase.WithPasswordHasher(hasher),
+		usecase.WithTokenSigner(tokenKeys),
+	)
+
+	// The issuer is the server's own URL, known only once it listens.
+	var router http.Handler
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	provider, err := usecase.NewOIDCProvider(server.URL, users, memoryClients{}, tokens, tokenKeys, time.Minute)
+	require.NoError(t, err)
+	r := gin.New()
+	handler.NewJWKSHandler(r, tokenKeys)
+	handler.NewOIDCHandler(r, provider, uc, func(c *gin.Context) { c.Next() }, policy.Default())
+	router = r
+
+	client, secret, err := provider.RegisterClient(context.Background(), "Wiki", []string{callback}, false)
+	require.NoError(t, err)
+
+	httpClient := server.Client()
+	httpClient.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return &oidcFixture{
+		server:   server,
+		http:     httpClient,
+		provider: provider,
+		keys:     tokenKeys,
+		user:     user,
+		clientID: client.ID,
+		secret:   secret,
+	}
+}
+
+func (f *oidcFixture) getJSON(t *testing.T, uri string, v interface{}) {
+	res, err := f.http.Get(uri)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(v))
+}
+
+func pkce() (verifier, challenge string) {
+	verifier = strings.Repeat("v", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (f *oidcFixture) authorizeParams(challenge string) url.Values {
+	return url.Values{
+		"client_id":             {f.clientID},
+		"redirect_uri":          {callback},
+		"response_type":         {"code"},
+		"scope":                 {"openid email profile"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+// signIn posts the sign-in form and returns the code from the redirect.
+func (f *oidcFixture) signIn(t *testing.T, endpoint string, params url.Values) string {
+	form := url.Values{"email": {"alice@example.com"}, "password": {"correct horse battery"}}
+	for k, v := range params {
+		form[k] = v
+	}
+	res, err := f.http.PostForm(endpoint, form)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusSeeOther, res.StatusCode)
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, callback, location.Scheme+"://"+location.Host+location.Path)
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+	assert.Equal(t, f.server.URL, location.Query().Get("iss"))
+	code := location.Query().Get("code")
+	require.NotEmpty(t, code)
+	return code
+}
+
+func (f *oidcFixture) exchange(t *testing.T, endpoint, code, verifier string) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {callback},
+		"code_verifier": {verifier},
+	}.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(f.clientID, f.secret)
+	res, err := f.http.Do(req)
+	require.NoError(t, err)
+	return res
+}
+
+func TestOIDC_AuthorizationCodeFlow(t *testing.T) {
+	f := newOIDCFixture(t)
+
+	var discovery usecase.OIDCDiscovery
+	f.getJSON(t, f.server.URL+"/.well-known/openid-configuration", &discovery)
+	assert.Equal(t, f.server.URL, discovery.Issuer)
+	assert.Equal(t, []string{keys.EdDSA}, discovery.IDTokenSigningAlgValuesSupported)
+
+	verifier, challenge := pkce()
+	params := f.authorizeParams(challenge)
+	res, err := f.http.Get(discovery.AuthorizationEndpoint + "?" + params.Encode())
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, res.Header.Get("Content-Type"), "text/html")
+
+	code := f.signIn(t, discovery.AuthorizationEndpoint, params)
+
+	res = f.exchange(t, discovery.TokenEndpoint, code, verifier)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		TokenType   string `json:"token_type"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+	res.Body.Close()
+	assert.Equal(t, "Bearer", tokens.TokenType)
+
+	// A relying party verifies the ID token with the published key set alone.
+	var jwks keys.JWKS
+	f.getJSON(t, discovery.JWKSURI, &jwks)
+	claims := verifyWithJWKS(t, tokens.IDToken, jwks, jwt.WithIssuer(discovery.Issuer), jwt.WithAudience(f.clientID))
+	assert.Equal(t, f.user.ID.Hex(), claims["sub"])
+	assert.Equal(t, "n-0S6", claims["nonce"])
+	assert.Equal(t, "alice@example.com", claims["email"])
+
+	req, err := http.NewRequest(http.MethodGet, discovery.UserinfoEndpoint, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	res, err = f.http.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var info map[string]interface{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&info))
+	res.Body.Close()
+	assert.Equal(t, map[string]interface{}{
+		"sub":            f.user.ID.Hex(),
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+	}, info)
+
+	// Codes work once.
+	res = f.exchange(t, discovery.TokenEndpoint, code, verifier)
+	var oauthErr struct {
+		Error string `json:"error"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&oauthErr))
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, "invalid_grant", oauthErr.Error)
+}
+
+// verifyWithJWKS verifies token with the key in jwks named by its kid, as a
+// relying party without any of the service's secrets would.
+func verifyWithJWKS(t *testing.T, token string, jwks keys.JWKS, opts ...jwt.ParserOption) jwt.MapClaims {
+	t.Helper()
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		for _, k := range jwks.Keys {
+			if k.Kid != token.Header["kid"] || k.Alg != token.Header["alg"] {
+				continue
+			}
+			decode := base64.RawURLEncoding.DecodeString
+			switch k.Kty {
+			case "OKP":
+				x, err := decode(k.X)
+				return ed25519.PublicKey(x), err
+			case "EC":
+				x, err := decode(k.X)
+				if err != nil {
+					return nil, err
+				}
+				y, err := decode(k.Y)
+				if err != nil {
+					return nil, err
+				}
+				return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+			case "RSA":
+				n, err := decode(k.N)
+				if err != nil {
+					return nil, err
+				}
+				e, err := decode(k.E)
+				if err != nil {
+					return nil, err
+				}
+				return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+			}
+		}
+		return nil, keys.ErrUnknownKey
+	}, opts...)
+	require.NoError(t, err)
+	return claims
+}
+
+func TestOIDC_IDTokenVerifiesWithJWKS(t *testing.T) {
+	for _, alg := range []string{keys.RS256, keys.ES256, keys.EdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			f := newOIDCFixtureWithKey(t, alg)
+			var discovery usecase.OIDCDiscovery
+			f.getJSON(t, f.server.URL+"/.well-known/openid-configuration", &discovery)
+			assert.Equal(t, []string{alg}, discovery.IDTokenSigningAlgValuesSupported)
+
+			verifier, challenge := pkce()
+			code := f.signIn(t, discovery.AuthorizationEndpoint, f.authorizeParams(challenge))
+			res := f.exchange(t, discovery.TokenEndpoint, code, verifier)
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			var tokens struct {
+				IDToken string `json:"id_token"`
+			}
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+			res.Body.Close()
+
+			var jwks keys.JWKS
+			f.getJSON(t, discovery.JWKSURI, &jwks)
+			claims := verifyWithJWKS(t, tokens.IDToken, jwks,
+				jwt.WithIssuer(discovery.Issuer), jwt.WithAudience(f.clientID), jwt.WithValidMethods([]string{alg}))
+			assert.Equal(t, f.user.ID.Hex(), claims["sub"])
+		})
+	}
+}
+
+func TestOIDC_RequiresAsymmetricKey(t *testing.T) {
+	_, err := usecase.NewOIDCProvider("https://id.example.com", &stubUsers{}, memoryClients{},
+		repository.NewMemoryTokenStore(), keys.NewHMACManager("secret"), time.Minute)
+	assert.ErrorIs(t, err, usecase.ErrSymmetricSigningKey)
+}
+
+func TestOIDC_TokenRejectsWrongVerifierAndSecret(t *testing.T) {
+	f := newOIDCFixture(t)
+	verifier, challenge := pkce()
+
+	code := f.signIn(t, f.server.URL+"/authorize", f.authorizeParams(challenge))
+	res := f.exchange(t, f.server.URL+"/token", code, strings.Repeat("w", 43))
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	// The failed attempt spent the code.
+	res = f.exchange(t, f.server.URL+"/token", code, verifier)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	code = f.signIn(t, f.server.URL+"/authorize", f.authorizeParams(challenge))
+	f.secret = "wrong"
+	res = f.exchange(t, f.server.URL+"/token", code, verifier)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.NotEmpty(t, res.Header.Get("WWW-Authenticate"))
+}
+
+func TestOIDC_AuthorizeRejectsBadRequests(t *testing.T) {
+	f := newOIDCFixture(t)
+	_, challenge := pkce()
+
+	// An unregistered redirect URI is never redirected to.
+	params := f.authorizeParams(challenge)
+	params.Set("redirect_uri", "https://evil.example.com/callback")
+	res, err := f.http.Get(f.server.URL + "/authorize?" + params.Encode())
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Empty(t, res.Header.Get("Location"))
+
+	// Other errors go back to the client.
+	params = f.authorizeParams("")
+	params.Del("code_challenge_method")
+	res, err = f.http.Get(f.server.URL + "/authorize?" + params.Encode())
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusFound, res.StatusCode)
+	location, err := url.Parse(res.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "invalid_request", location.Query().Get("error"))
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+
+	// Wrong passwords show the form again.
+	form := f.authorizeParams(challenge)
+	form.Set("email", "alice@example.com")
+	form.Set("password", "wrong")
+	res, err = f.http.PostForm(f.server.URL+"/authorize", form)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Contains(t, res.Header.Get("Content-Type"), "text/html")
+}
+
+func TestOIDC_UserInfoRejectsSessionTokens(t *testing.T) {
+	f := newOIDCFixture(t)
+	session, err := utils.GenerateJWT(f.user.ID.Hex(), "user", "session", nil, f.keys, time.Minute)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, f.server.URL+"/userinfo", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+session)
+	res, err := f.http.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Contains(t, res.Header.Get("WWW-Authenticate"), "invalid_token")
+}
@@ -0,0 +1,61 @@
+package handler_test
+
+import (
+	"7-solutions/handler"
+	"7-solutions/mailer"
+	"7-solutions/model"
+	"7-solutions/policy"
+	"7-solutions/repository"
+	"7-solutions/usecase"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// blockingMailer holds every message until release is closed.
+type blockingMailer struct {
+	release chan struct{}
+	sent    chan mailer.Message
+}
+
+func (m *blockingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	select {
+	case <-m.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	m.sent <- msg
+	return nil
+}
+
+func TestForgotPassword_DoesNotWaitForMail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mail := &blockingMailer{release: make(chan struct{}), sent: make(chan mailer.Message, 1)}
+	users := &stubUsers{users: []*model.User{{ID: primitive.NewObjectID(), Email: "alice@example.com"}}}
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour,
+		usecase.WithMailer(mail),
+	)
+	r := gin.New()
+	handler.NewUserHandler(r, uc, func(c *gin.Context) { c.Next() }, policy.Default())
+
+	for _, email := range []string{"alice@example.com", "nobody@example.com"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/password/forgot", strings.NewReader(`{"email":"`+email+`"}`)))
+		assert.Equal(t, http.StatusAccepted, w.Code, "%s is answered while the mailer is blocked", email)
+	}
+
+	close(mail.release)
+	select {
+	case msg := <-mail.sent:
+		assert.Equal(t, "alice@example.com", msg.To)
+	case <-time.After(time.Second):
+		t.Fatal("the reset email was not sent")
+	}
+}
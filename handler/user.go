@@ -1,8 +1,16 @@
 package handler
 
 import (
+	"7-solutions/apperr"
+	"7-solutions/middleware"
+	"7-solutions/model"
+	"7-solutions/policy"
 	"7-solutions/usecase"
+	"encoding/base64"
+	"errors"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,17 +19,37 @@ type UserHandler struct {
 	Usecase usecase.UserUsecase
 }
 
-func NewUserHandler(r *gin.Engine, uc usecase.UserUsecase, auth gin.HandlerFunc) {
+func NewUserHandler(r *gin.Engine, uc usecase.UserUsecase, auth gin.HandlerFunc, p policy.Policy) {
 	h := &UserHandler{Usecase: uc}
 
 	r.POST("/register", h.Register)
 	r.POST("/login", h.Login)
+	r.POST("/login/mfa", h.LoginMFA)
+	r.GET("/auth/:provider/login", h.ExternalLogin)
+	r.GET("/auth/:provider/callback", h.ExternalCallback)
+	r.POST("/verify-email", h.VerifyEmail)
+	r.POST("/verify-email/resend", h.ResendVerification)
+	r.POST("/password/forgot", h.ForgotPassword)
+	r.POST("/password/reset", h.ResetPassword)
+	r.POST("/token/refresh", h.RefreshToken)
+	// Sessions and account security are managed with an access token only.
+	session := middleware.RequireSession()
+	r.POST("/logout", auth, session, h.Logout)
+	r.POST("/logout/all", auth, session, h.LogoutAll)
 
+	read := middleware.RequireScopes(policy.ScopeUsersRead)
+	write := middleware.RequireScopes(policy.ScopeUsersWrite)
+	admin := middleware.RequireScopes(policy.ScopeUsersAdmin)
 	authGroup := r.Group("/users", auth)
-	authGroup.GET("/", h.List)
-	authGroup.GET("/:id", h.Get)
-	authGroup.PUT("/:id", h.Update)
-	authGroup.DELETE("/:id", h.Delete)
+	authGroup.GET("/", read, middleware.Authorize(p, policy.ActionListUsers), h.List)
+	authGroup.PUT("/me/password", session, write, h.ChangePassword)
+	authGroup.POST("/me/mfa/totp", session, write, h.EnrollTOTP)
+	authGroup.POST("/me/mfa/totp/confirm", session, write, h.ConfirmTOTP)
+	authGroup.DELETE("/me/mfa/totp", session, write, h.DisableTOTP)
+	authGroup.GET("/:id", read, middleware.Authorize(p, policy.ActionGetUser), h.Get)
+	authGroup.PUT("/:id", write, middleware.Authorize(p, policy.ActionUpdateUser), h.Update)
+	authGroup.DELETE("/:id", admin, middleware.Authorize(p, policy.ActionDeleteUser), h.Delete)
+	authGroup.POST("/:id/unlock", admin, middleware.Authorize(p, policy.ActionUnlockUser), h.Unlock)
 }
 
 func (h *UserHandler) Register(c *gin.Context) {
@@ -31,15 +59,79 @@ func (h *UserHandler) Register(c *gin.Context) {
 		Password string `json:"password" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apperr.WriteProblem(c, bindError(err))
 		return
 	}
-	err := h.Usecase.Register(c.Request.Context(), req.Name, req.Email, req.Password)
+	user, err := h.Usecase.Register(c.Request.Context(), req.Name, req.Email, req.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apperr.WriteProblem(c, err)
 		return
 	}
-	c.JSON(http.StatusCreated, gin.H{"message": "registered"})
+	c.Header("Location", "/users/"+user.ID.Hex())
+	c.JSON(http.StatusCreated, user)
+}
+
+func (h *UserHandler) VerifyEmail(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.WriteProblem(c, bindError(err))
+		return
+	}
+	if err := h.Usecase.VerifyEmail(c.Request.Context(), req.Token); err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "email verified"})
+}
+
+// ResendVerification answers 202 whether or not the email is registered.
+func (h *UserHandler) ResendVerification(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.WriteProblem(c, bindError(err))
+		return
+	}
+	if err := h.Usecase.ResendVerification(c.Request.Context(), req.Email); err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "if the email is registered and unverified, a verification email has been sent"})
+}
+
+// ForgotPassword answers 202 whether or not the email is registered.
+func (h *UserHandler) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.WriteProblem(c, bindError(err))
+		return
+	}
+	if err := h.Usecase.ForgotPassword(c.Request.Context(), req.Email); err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "if the email is registered, a password reset email has been sent"})
+}
+
+func (h *UserHandler) ResetPassword(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.WriteProblem(c, bindError(err))
+		return
+	}
+	if err := h.Usecase.ResetPassword(c.Request.Context(), req.Token, req.Password); err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "password reset"})
 }
 
 func (h *UserHandler) Login(c *gin.Context) {
@@ -48,53 +140,178 @@ func (h *UserHandler) Login(c *gin.Context) {
 		Password string `json:"password" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apperr.WriteProblem(c, bindError(err))
+		return
+	}
+	tokens, err := h.Usecase.Login(c.Request.Context(), req.Email, req.Password, c.ClientIP())
+	if err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokenResponse(tokens))
+}
+
+// LoginMFA completes a login that answered with mfa_required.
+func (h *UserHandler) LoginMFA(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.WriteProblem(c, bindError(err))
+		return
+	}
+	tokens, err := h.Usecase.LoginMFA(c.Request.Context(), req.MFAToken, req.Code)
+	if err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokenResponse(tokens))
+}
+
+func (h *UserHandler) RefreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.WriteProblem(c, bindError(err))
+		return
+	}
+	tokens, err := h.Usecase.RefreshToken(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokenResponse(tokens))
+}
+
+func (h *UserHandler) Logout(c *gin.Context) {
+	principal := middleware.Principal(c)
+	err := h.Usecase.Logout(
+		c.Request.Context(),
+		principal.UserID,
+		principal.TokenID,
+		principal.SessionID,
+		principal.ExpiresAt,
+	)
+	if err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+}
+
+// LogoutAll ends every session of the caller. The body is optional;
+// {"revoke_api_keys": true} deletes the caller's API keys as well.
+func (h *UserHandler) LogoutAll(c *gin.Context) {
+	var req struct {
+		RevokeAPIKeys bool `json:"revoke_api_keys"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		apperr.WriteProblem(c, bindError(err))
+		return
+	}
+	if err := h.Usecase.LogoutAll(c.Request.Context(), middleware.Principal(c).UserID, req.RevokeAPIKeys); err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "all sessions revoked"})
+}
+
+// ChangePassword changes the caller's own password. The session making the
+// request stays signed in; every other session is ended.
+func (h *UserHandler) ChangePassword(c *gin.Context) {
+	var req struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.WriteProblem(c, bindError(err))
 		return
 	}
-	token, err := h.Usecase.Login(c.Request.Context(), req.Email, req.Password)
+	principal := middleware.Principal(c)
+	err := h.Usecase.ChangePassword(c.Request.Context(), principal.UserID, principal.SessionID, req.CurrentPassword, req.NewPassword)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		apperr.WriteProblem(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	c.JSON(http.StatusOK, gin.H{"message": "password changed"})
+}
+
+func tokenResponse(tokens *usecase.TokenPair) gin.H {
+	if tokens.MFAToken != "" {
+		return gin.H{
+			"mfa_required": true,
+			"mfa_token":    tokens.MFAToken,
+			"expires_in":   int64(tokens.ExpiresIn.Seconds()),
+		}
+	}
+	return gin.H{
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int64(tokens.ExpiresIn.Seconds()),
+	}
 }
 
 func (h *UserHandler) Get(c *gin.Context) {
 	id := c.Param("id")
 	user, err := h.Usecase.GetUser(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		apperr.WriteProblem(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, user)
 }
 
 func (h *UserHandler) List(c *gin.Context) {
-	users, err := h.Usecase.ListUsers(c.Request.Context())
+	var req struct {
+		Limit         int64     `form:"limit" binding:"omitempty,min=1,max=100"`
+		After         string    `form:"after"`
+		Name          string    `form:"name"`
+		Email         string    `form:"email"`
+		CreatedAfter  time.Time `form:"created_after" time_format:"2006-01-02T15:04:05Z07:00"`
+		CreatedBefore time.Time `form:"created_before" time_format:"2006-01-02T15:04:05Z07:00"`
+		Sort          string    `form:"sort" binding:"omitempty,oneof=id name email created_at"`
+		Order         string    `form:"order" binding:"omitempty,oneof=asc desc"`
+	}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		apperr.WriteProblem(c, bindError(err))
+		return
+	}
+
+	page, err := h.Usecase.ListUsers(c.Request.Context(), model.UserQuery{
+		Filter: model.UserFilter{
+			NamePrefix:    req.Name,
+			EmailPrefix:   req.Email,
+			CreatedAfter:  req.CreatedAfter,
+			CreatedBefore: req.CreatedBefore,
+		},
+		SortBy:     req.Sort,
+		Descending: req.Order == "desc",
+		After:      req.After,
+		Limit:      req.Limit,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apperr.WriteProblem(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, users)
+	c.JSON(http.StatusOK, page)
 }
 
 func (h *UserHandler) Update(c *gin.Context) {
 	id := c.Param("id")
 	var req struct {
 		Name  string `json:"name"`
-		Email string `json:"email"`
+		Email string `json:"email" binding:"omitempty,email"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apperr.WriteProblem(c, bindError(err))
 		return
 	}
 	err := h.Usecase.UpdateUser(c.Request.Context(), id, req.Name, req.Email)
 	if err != nil {
-		if err.Error() == "user not found" {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
+		apperr.WriteProblem(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "updated"})
@@ -104,12 +321,65 @@ func (h *UserHandler) Delete(c *gin.Context) {
 	id := c.Param("id")
 	err := h.Usecase.DeleteUser(c.Request.Context(), id)
 	if err != nil {
-		if err.Error() == "user not found" {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
+		apperr.WriteProblem(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
 }
+
+// Unlock lifts a login lockout on the user's account.
+func (h *UserHandler) Unlock(c *gin.Context) {
+	if err := h.Usecase.UnlockUser(c.Request.Context(), c.Param("id")); err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "unlocked"})
+}
+
+// EnrollTOTP returns a new TOTP secret as an otpauth URI and a QR code PNG
+// data URI, to be confirmed through ConfirmTOTP.
+func (h *UserHandler) EnrollTOTP(c *gin.Context) {
+	enrollment, err := h.Usecase.EnrollTOTP(c.Request.Context(), middleware.Principal(c).UserID)
+	if err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      enrollment.Secret,
+		"otpauth_uri": enrollment.URI,
+		"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(enrollment.QRCode),
+	})
+}
+
+func (h *UserHandler) ConfirmTOTP(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.WriteProblem(c, bindError(err))
+		return
+	}
+	codes, err := h.Usecase.ConfirmTOTP(c.Request.Context(), middleware.Principal(c).UserID, req.Code)
+	if err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (h *UserHandler) DisableTOTP(c *gin.Context) {
+	// Users without a password confirm with a TOTP or recovery code.
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.WriteProblem(c, bindError(err))
+		return
+	}
+	if err := h.Usecase.DisableTOTP(c.Request.Context(), middleware.Principal(c).UserID, req.Password, req.Code); err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
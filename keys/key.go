@@ -0,0 +1,198 @@
+// Package keys holds the keys access tokens are signed and verified with and
+// publishes the public ones as a JSON Web Key Set.
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms, as JWS "alg" values.
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+	HS256 = "HS256"
+)
+
+// minRSABits is the smallest RSA modulus accepted for RS256.
+const minRSABits = 2048
+
+// Key is one signing or verification key. Keys built from a public key only
+// verify. The ID of an asymmetric key is its RFC 7638 JWK thumbprint.
+type Key struct {
+	ID        string
+	Algorithm string
+	public    crypto.PublicKey
+	private   crypto.Signer
+	secret    []byte
+}
+
+// NewKey wraps an RSA (RS256), P-256 ECDSA (ES256) or Ed25519 (EdDSA)
+// private key.
+func NewKey(private crypto.Signer) (Key, error) {
+	key, err := NewPublicKey(private.Public())
+	if err != nil {
+		return Key{}, err
+	}
+	key.private = private
+	return key, nil
+}
+
+// NewPublicKey wraps a public key that only verifies tokens, such as the
+// previous key after a rotation.
+func NewPublicKey(public crypto.PublicKey) (Key, error) {
+	alg, err := algorithmFor(public)
+	if err != nil {
+		return Key{}, err
+	}
+	key := Key{Algorithm: alg, public: public}
+	key.ID, err = key.thumbprint()
+	if err != nil {
+		return Key{}, err
+	}
+	return key, nil
+}
+
+// NewHMACKey wraps a shared HS256 secret. HMAC keys carry no ID and are
+// never published.
+func NewHMACKey(secret []byte) Key {
+	return Key{Algorithm: HS256, secret: secret}
+}
+
+// Generate creates a new private key for alg (RS256, ES256 or EdDSA).
+func Generate(alg string) (Key, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case RS256:
+		private, err = rsa.GenerateKey(rand.Reader, minRSABits)
+	case ES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case EdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return Key{}, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	if err != nil {
+		return Key{}, err
+	}
+	return NewKey(private)
+}
+
+// ParsePEM reads a private key (PKCS#8, PKCS#1 or SEC 1) or a public key
+// (PKIX) in PEM form.
+func ParsePEM(data []byte) (Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Key{}, errors.New("no PEM block found")
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, err
+		}
+		private, ok := parsed.(crypto.Signer)
+		if !ok {
+			return Key{}, fmt.Errorf("unsupported private key %T", parsed)
+		}
+		return NewKey(private)
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, err
+		}
+		return NewKey(private)
+	case "EC PRIVATE KEY":
+		private, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, err
+		}
+		return NewKey(private)
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return Key{}, err
+		}
+		return NewPublicKey(public)
+	default:
+		return Key{}, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+// LoadPEM reads a key file in one of the forms ParsePEM accepts.
+func LoadPEM(path string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+	key, err := ParsePEM(data)
+	if err != nil {
+		return Key{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// Public returns the public half, or nil for an HMAC key.
+func (k Key) Public() crypto.PublicKey {
+	return k.public
+}
+
+// Signer returns the private half, or nil for keys that only verify.
+func (k Key) Signer() crypto.Signer {
+	return k.private
+}
+
+// CanSign reports whether the key holds a private half or secret.
+func (k Key) CanSign() bool {
+	return k.private != nil || k.secret != nil
+}
+
+func algorithmFor(public crypto.PublicKey) (string, error) {
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSABits {
+			return "", fmt.Errorf("RSA keys need at least %d bits", minRSABits)
+		}
+		return RS256, nil
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return "", errors.New("ECDSA keys must use P-256")
+		}
+		return ES256, nil
+	case ed25519.PublicKey:
+		return EdDSA, nil
+	default:
+		return "", fmt.Errorf("unsupported public key %T", public)
+	}
+}
+
+func (k Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+func (k Key) signingKey() interface{} {
+	if k.secret != nil {
+		return k.secret
+	}
+	return k.private
+}
+
+func (k Key) verificationKey() interface{} {
+	if k.secret != nil {
+		return k.secret
+	}
+	return k.public
+}
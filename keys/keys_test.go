@@ -0,0 +1,179 @@
+package keys_test
+
+import (
+	"7-solutions/keys"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func claims() map[string]interface{} {
+	return map[string]interface{}{"user_id": "alice", "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func tokenHeader(t *testing.T, token string) map[string]interface{} {
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	require.NoError(t, err)
+	return parsed.Header
+}
+
+func TestManager_SignAndVerify(t *testing.T) {
+	for _, alg := range []string{keys.RS256, keys.ES256, keys.EdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			key, err := keys.Generate(alg)
+			require.NoError(t, err)
+			m, err := keys.NewManager(key)
+			require.NoError(t, err)
+
+			token, err := m.Sign(claims())
+			require.NoError(t, err)
+			header := tokenHeader(t, token)
+			assert.Equal(t, alg, header["alg"])
+			assert.Equal(t, key.ID, header["kid"])
+
+			got, err := m.Verify(token)
+			require.NoError(t, err)
+			assert.Equal(t, "alice", got["user_id"])
+		})
+	}
+}
+
+func TestManager_RejectsBadTokens(t *testing.T) {
+	key, err := keys.Generate(keys.EdDSA)
+	require.NoError(t, err)
+	m, err := keys.NewManager(key)
+	require.NoError(t, err)
+	other, err := keys.Generate(keys.EdDSA)
+	require.NoError(t, err)
+	stranger, err := keys.NewManager(other)
+	require.NoError(t, err)
+
+	foreign, err := stranger.Sign(claims())
+	require.NoError(t, err)
+	expired, err := m.Sign(map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()})
+	require.NoError(t, err)
+	noExpiry, err := m.Sign(map[string]interface{}{"user_id": "alice"})
+	require.NoError(t, err)
+	// An HS256 token naming the Ed25519 kid must not be checked as HMAC.
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims(claims()))
+	confused.Header["kid"] = key.ID
+	hmac, err := confused.SignedString([]byte("guess"))
+	require.NoError(t, err)
+
+	for _, token := range []string{"garbage", foreign, expired, noExpiry, hmac} {
+		_, err := m.Verify(token)
+		assert.ErrorIs(t, err, keys.ErrInvalidJWT)
+	}
+}
+
+func TestManager_Rotate(t *testing.T) {
+	first, err := keys.Generate(keys.ES256)
+	require.NoError(t, err)
+	second, err := keys.Generate(keys.RS256)
+	require.NoError(t, err)
+	m, err := keys.NewManager(first)
+	require.NoError(t, err)
+
+	old, err := m.Sign(claims())
+	require.NoError(t, err)
+	require.NoError(t, m.Rotate(second))
+	assert.Equal(t, second.ID, m.SigningKeyID())
+
+	current, err := m.Sign(claims())
+	require.NoError(t, err)
+	assert.Equal(t, second.ID, tokenHeader(t, current)["kid"])
+	_, err = m.Verify(old)
+	assert.NoError(t, err, "tokens of the previous key verify until it is retired")
+	assert.Len(t, m.JWKS().Keys, 2)
+
+	assert.ErrorIs(t, m.Retire(second.ID), keys.ErrSigningKey)
+	require.NoError(t, m.Retire(first.ID))
+	_, err = m.Verify(old)
+	assert.Error(t, err)
+	_, err = m.Verify(current)
+	assert.NoError(t, err)
+	assert.Len(t, m.JWKS().Keys, 1)
+}
+
+func TestManager_VerificationOnlyKeys(t *testing.T) {
+	previous, err := keys.Generate(keys.EdDSA)
+	require.NoError(t, err)
+	old, err := keys.NewManager(previous)
+	require.NoError(t, err)
+	token, err := old.Sign(claims())
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(previous.Public())
+	require.NoError(t, err)
+	public, err := keys.ParsePEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	require.NoError(t, err)
+	assert.False(t, public.CanSign())
+	assert.Equal(t, previous.ID, public.ID)
+
+	_, err = keys.NewManager(public)
+	assert.ErrorIs(t, err, keys.ErrCannotSign)
+
+	next, err := keys.Generate(keys.EdDSA)
+	require.NoError(t, err)
+	m, err := keys.NewManager(next, public)
+	require.NoError(t, err)
+	_, err = m.Verify(token)
+	assert.NoError(t, err)
+}
+
+func TestParsePEM_PrivateKeys(t *testing.T) {
+	key, err := keys.Generate(keys.ES256)
+	require.NoError(t, err)
+	m, err := keys.NewManager(key)
+	require.NoError(t, err)
+	token, err := m.Sign(claims())
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key.Signer())
+	require.NoError(t, err)
+	parsed, err := keys.ParsePEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	require.NoError(t, err)
+	assert.True(t, parsed.CanSign())
+	assert.Equal(t, key.ID, parsed.ID)
+
+	_, err = keys.ParsePEM([]byte("not a key"))
+	assert.Error(t, err)
+	_, err = m.Verify(token)
+	assert.NoError(t, err)
+}
+
+func TestNewKey_RejectsWeakRSA(t *testing.T) {
+	_, err := keys.NewPublicKey(&rsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), 1023), E: 65537})
+	assert.Error(t, err)
+}
+
+// The example key and thumbprint of RFC 7638, section 3.1.
+func TestKeyID_IsJWKThumbprint(t *testing.T) {
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	require.NoError(t, err)
+	key, err := keys.NewPublicKey(&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537})
+	require.NoError(t, err)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", key.ID)
+
+	jwk, ok := key.JWK()
+	require.True(t, ok)
+	assert.Equal(t, keys.JWK{Kty: "RSA", Use: "sig", Alg: keys.RS256, Kid: key.ID, N: base64.RawURLEncoding.EncodeToString(n), E: "AQAB"}, jwk)
+}
+
+func TestHMACManager_PublishesNothing(t *testing.T) {
+	m := keys.NewHMACManager("secret")
+	token, err := m.Sign(claims())
+	require.NoError(t, err)
+	assert.NotContains(t, tokenHeader(t, token), "kid")
+	_, err = m.Verify(token)
+	assert.NoError(t, err)
+	assert.Empty(t, m.JWKS().Keys)
+}
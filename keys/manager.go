@@ -0,0 +1,163 @@
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKey = errors.New("unknown signing key")
+	ErrCannotSign = errors.New("key has no private half")
+	ErrSigningKey = errors.New("cannot retire the signing key")
+	ErrInvalidJWT = errors.New("invalid token")
+)
+
+var validAlgorithms = []string{RS256, ES256, EdDSA, HS256}
+
+// Manager signs tokens with one key and verifies them with any of its keys,
+// picked by the token's "kid" header. Rotating adds a new signing key while
+// the old one keeps verifying the tokens it already issued.
+type Manager struct {
+	mu      sync.RWMutex
+	signing Key
+	keys    map[string]Key
+}
+
+// NewManager signs with signing and also accepts tokens signed by any of
+// verification.
+func NewManager(signing Key, verification ...Key) (*Manager, error) {
+	if !signing.CanSign() {
+		return nil, ErrCannotSign
+	}
+	m := &Manager{signing: signing, keys: map[string]Key{signing.ID: signing}}
+	for _, key := range verification {
+		if err := m.AddVerificationKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// NewHMACManager signs and verifies with a shared HS256 secret, for
+// deployments that have not moved to asymmetric keys.
+func NewHMACManager(secret string) *Manager {
+	key := NewHMACKey([]byte(secret))
+	return &Manager{signing: key, keys: map[string]Key{key.ID: key}}
+}
+
+// Sign issues a token for claims with the current signing key.
+func (m *Manager) Sign(claims map[string]interface{}) (string, error) {
+	m.mu.RLock()
+	key := m.signing
+	m.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.method(), jwt.MapClaims(claims))
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.signingKey())
+}
+
+// Verify checks the token's signature and expiry and returns its claims.
+func (m *Manager) Verify(tokenStr string) (map[string]interface{}, error) {
+	claims := jwt.MapClaims{}
+	if err := m.ParseWithClaims(tokenStr, claims, jwt.WithExpirationRequired()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// ParseWithClaims checks the token's signature against the key named by its
+// "kid" header and decodes it into claims, which are validated with opts.
+func (m *Manager) ParseWithClaims(tokenStr string, claims jwt.Claims, opts ...jwt.ParserOption) error {
+	opts = append(opts, jwt.WithValidMethods(validAlgorithms))
+	token, err := jwt.ParseWithClaims(tokenStr, claims, m.keyfunc, opts...)
+	if err != nil || !token.Valid {
+		return ErrInvalidJWT
+	}
+	return nil
+}
+
+func (m *Manager) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	m.mu.RLock()
+	key, ok := m.keys[kid]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("key %q does not sign %s", kid, token.Method.Alg())
+	}
+	return key.verificationKey(), nil
+}
+
+// Rotate makes next the signing key. The previous key stays available for
+// verification until it is retired.
+func (m *Manager) Rotate(next Key) error {
+	if !next.CanSign() {
+		return ErrCannotSign
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signing = next
+	m.keys[next.ID] = next
+	return nil
+}
+
+// AddVerificationKey accepts tokens signed by key without signing with it.
+func (m *Manager) AddVerificationKey(key Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.keys[key.ID]; ok {
+		return fmt.Errorf("duplicate key %q", key.ID)
+	}
+	m.keys[key.ID] = key
+	return nil
+}
+
+// Retire stops accepting tokens signed by the key with id.
+func (m *Manager) Retire(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id == m.signing.ID {
+		return ErrSigningKey
+	}
+	if _, ok := m.keys[id]; !ok {
+		return ErrUnknownKey
+	}
+	delete(m.keys, id)
+	return nil
+}
+
+// SigningKeyID returns the kid new tokens are issued with.
+func (m *Manager) SigningKeyID() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.signing.ID
+}
+
+// SigningAlgorithm returns the JWS algorithm new tokens are signed with.
+func (m *Manager) SigningAlgorithm() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.signing.Algorithm
+}
+
+// JWKS returns the public halves of every asymmetric key, sorted by kid.
+func (m *Manager) JWKS() JWKS {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range m.keys {
+		if jwk, ok := key.JWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -0,0 +1,14 @@
+// Package mailer sends transactional email such as verification messages.
+package mailer
+
+import "context"
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
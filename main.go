@@ -7,6 +7,7 @@ import (
 
 	"7-solutions/handler"
 	"7-solutions/middleware"
+	"7-solutions/policy"
 
 	"7-solutions/repository"
 	"7-solutions/usecase"
@@ -29,8 +30,51 @@ func main() {
 
 	client := config.ConnectDB()
 	db := client.Database("7-solutions-db")
-	userRepo := repository.NewUserRepository(db)
-	userUC := usecase.NewUserUsecase(userRepo, os.Getenv("JWT_SECRET"))
+	userRepo, err := repository.NewUserRepository(context.Background(), db)
+	if err != nil {
+		log.Fatalf("failed to prepare users collection: %v", err)
+	}
+	tokenStore := config.NewTokenStore(db)
+	apiKeyRepo, err := repository.NewAPIKeyRepository(context.Background(), db)
+	if err != nil {
+		log.Fatalf("failed to prepare api keys collection: %v", err)
+	}
+	tokenKeys := config.NewKeyManager()
+	accessTTL := config.GetDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+	userUC := usecase.NewUserUsecase(
+		userRepo,
+		tokenStore,
+		os.Getenv("JWT_SECRET"),
+		accessTTL,
+		config.GetDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		usecase.WithTokenSigner(config.NewTokenSigner(tokenKeys)),
+		usecase.WithMailer(config.NewMailer()),
+		usecase.WithMailConcurrency(config.GetInt("MAIL_CONCURRENCY", usecase.DefaultMailConcurrency)),
+		usecase.WithBaseURL(os.Getenv("APP_BASE_URL")),
+		usecase.WithPasswordPolicy(config.NewPasswordPolicy()),
+		usecase.WithPasswordHasher(config.NewPasswordHasher()),
+		usecase.WithLockoutPolicy(config.NewLockoutPolicy()),
+		usecase.WithTOTPIssuer(config.GetEnv("TOTP_ISSUER", usecase.DefaultTOTPIssuer)),
+		usecase.WithPasswordResetTTL(config.GetDuration("PASSWORD_RESET_TTL", usecase.DefaultPasswordResetTTL)),
+		usecase.WithEmailVerification(
+			config.GetDuration("EMAIL_VERIFICATION_TTL", usecase.DefaultVerificationTTL),
+			config.GetBool("REQUIRE_EMAIL_VERIFICATION", false),
+		),
+		usecase.WithConnectors(config.NewConnectors()...),
+		usecase.WithAPIKeys(apiKeyRepo),
+	)
+	apiKeyUC := usecase.NewAPIKeyUsecase(apiKeyRepo, userRepo)
+	oidcProvider, oidcErr := usecase.NewOIDCProvider(
+		config.GetEnv("OIDC_ISSUER", config.GetEnv("APP_BASE_URL", "http://localhost:8080")),
+		userRepo,
+		repository.NewOAuthClientRepository(db),
+		tokenStore,
+		tokenKeys,
+		accessTTL,
+	)
+	if oidcErr != nil {
+		log.Printf("OpenID Connect provider disabled: %v; set JWT_SIGNING_KEY to enable it", oidcErr)
+	}
 
 	go func() {
 		for {
@@ -42,18 +86,42 @@ func main() {
 		}
 	}()
 
+	accessPolicy := policy.Default()
+	rateLimiter := config.NewRateLimiter()
+	rateLimits := config.NewRateLimitRules()
+
 	// ! === Setup Gin HTTP Server ===
 	ginRouter := gin.Default()
-	handler.NewUserHandler(ginRouter, userUC, middleware.JWTAuth(os.Getenv("JWT_SECRET"), userRepo))
+	// Login throttling keys on the client IP, so only listed proxies may set
+	// X-Forwarded-For.
+	if err := ginRouter.SetTrustedProxies(config.GetList("TRUSTED_PROXIES", nil)); err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
+	tokenVerifier := config.NewVerifier(tokenKeys, tokenStore, userRepo)
+	ginRouter.Use(middleware.RateLimit(rateLimiter, rateLimits, tokenVerifier))
+	auth := middleware.JWTAuth(tokenVerifier, apiKeyUC)
+	handler.NewUserHandler(ginRouter, userUC, auth, accessPolicy)
+	handler.NewAPIKeyHandler(ginRouter, apiKeyUC, auth)
+	handler.NewJWKSHandler(ginRouter, tokenKeys)
+	if oidcProvider != nil {
+		handler.NewOIDCHandler(ginRouter, oidcProvider, userUC, auth, accessPolicy)
+	}
 	httpSrv := &http.Server{
 		Addr:    ":8080",
 		Handler: ginRouter,
 	}
 
 	// ? === Setup gRPC Server ===
-	authInterceptor := grpcserver.NewAuthInterceptor(os.Getenv("JWT_SECRET"))
+	authInterceptor := grpcserver.NewAuthInterceptor(
+		tokenVerifier,
+		apiKeyUC,
+		accessPolicy,
+		config.GetList("GRPC_PUBLIC_METHODS", grpcserver.DefaultPublicMethods),
+	)
+	rateLimitInterceptor := grpcserver.NewRateLimitInterceptor(rateLimiter, rateLimits, tokenVerifier)
 	grpcSrv := grpc.NewServer(
-		grpc.UnaryInterceptor(authInterceptor.Unary()),
+		grpc.ChainUnaryInterceptor(rateLimitInterceptor.Unary(), authInterceptor.Unary()),
+		grpc.ChainStreamInterceptor(rateLimitInterceptor.Stream(), authInterceptor.Stream()),
 	)
 	userpb.RegisterUserServiceServer(grpcSrv, grpcserver.NewUserGRPCServer(userUC))
 	grpcListener, err := net.Listen("tcp", ":50051")
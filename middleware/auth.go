@@ -1,59 +1,90 @@
 package middleware
 
 import (
-	"7-solutions/repository"
+	"7-solutions/apperr"
+	"7-solutions/auth"
+	"7-solutions/model"
 	"7-solutions/utils"
-	"net/http"
+	"context"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func JWTAuth(secret string, userRepo repository.UsersRepository) gin.HandlerFunc {
+// APIKeyAuthenticator resolves API keys to their key and owner, such as
+// usecase.APIKeyUsecase.
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, secret string) (*model.APIKey, *model.User, error)
+}
+
+// JWTAuth accepts an access token as "Authorization: Bearer <token>", or an
+// API key as "X-API-Key: <key>" or "Authorization: ApiKey <key>", and stores
+// the caller's auth.Principal in the request context. apiKeys may be nil to
+// accept access tokens only.
+func JWTAuth(verifier *auth.Verifier, apiKeys APIKeyAuthenticator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		if apiKey := utils.APIKeyCredential(authHeader, c.GetHeader("X-API-Key")); apiKey != "" {
+			authenticateAPIKey(c, apiKeys, apiKey)
+			return
+		}
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "authorization header missing"})
-			c.Abort()
+			apperr.WriteProblem(c, apperr.New(apperr.ErrUnauthorized, "authorization header missing"))
 			return
 		}
 
 		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenStr == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token format"})
-			c.Abort()
+			apperr.WriteProblem(c, apperr.New(apperr.ErrUnauthorized, "invalid token format"))
 			return
 		}
 
-		claims, err := utils.ValidateJWT(tokenStr, secret)
+		principal, err := verifier.Verify(c.Request.Context(), tokenStr)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
-			c.Abort()
+			apperr.WriteProblem(c, err)
 			return
 		}
+		setPrincipal(c, principal)
+		c.Next()
+	}
+}
 
-		userID, ok := claims["user_id"].(string)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token payload"})
-			c.Abort()
-			return
-		}
+func authenticateAPIKey(c *gin.Context, apiKeys APIKeyAuthenticator, secret string) {
+	if apiKeys == nil {
+		apperr.WriteProblem(c, apperr.New(apperr.ErrUnauthorized, "api keys are not accepted"))
+		return
+	}
+	key, user, err := apiKeys.AuthenticateAPIKey(c.Request.Context(), secret)
+	if err != nil {
+		apperr.WriteProblem(c, err)
+		return
+	}
+	setPrincipal(c, auth.APIKeyPrincipal(key, user))
+	c.Next()
+}
 
-		user, err := userRepo.GetByID(c.Request.Context(), userID)
-		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "user does not exist (possibly deleted)"})
-				c.Abort()
-				return
-			}
-
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error checking user"})
-			c.Abort()
+func setPrincipal(c *gin.Context, p *auth.Principal) {
+	c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), p))
+}
+
+// Principal returns the caller JWTAuth authenticated, or an empty principal
+// on routes without it.
+func Principal(c *gin.Context) *auth.Principal {
+	if p, ok := auth.FromContext(c.Request.Context()); ok {
+		return p
+	}
+	return &auth.Principal{}
+}
+
+// RequireSession must run after JWTAuth. It refuses API keys on routes that
+// manage the account itself, such as changing the password or creating
+// further keys, so a leaked key cannot take the account over.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if Principal(c).IsAPIKey() {
+			apperr.WriteProblem(c, apperr.New(apperr.ErrForbidden, "api keys cannot be used for this request"))
 			return
 		}
-
-		c.Set("user_id", user.ID.Hex())
 		c.Next()
 	}
 }
@@ -0,0 +1,129 @@
+package middleware_test
+
+import (
+	"7-solutions/auth"
+	"7-solutions/middleware"
+	"7-solutions/model"
+	"7-solutions/repository"
+	"7-solutions/usecase"
+	"7-solutions/utils"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type stubAPIKeys map[string]*model.User
+
+func (s stubAPIKeys) AuthenticateAPIKey(ctx context.Context, secret string) (*model.APIKey, *model.User, error) {
+	user, ok := s[secret]
+	if !ok {
+		return nil, nil, usecase.ErrInvalidAPIKey
+	}
+	return &model.APIKey{ID: primitive.NewObjectID(), UserID: user.ID.Hex(), Scopes: []string{"users:read"}}, user, nil
+}
+
+func newAPIKeyRouter(apiKeys middleware.APIKeyAuthenticator) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	verifier := auth.NewVerifier(testKeys, "test", auth.DefaultAudience, auth.WithRevocations(repository.NewMemoryTokenStore()))
+	jwtAuth := middleware.JWTAuth(verifier, apiKeys)
+	r.GET("/me", jwtAuth, func(c *gin.Context) {
+		principal := middleware.Principal(c)
+		c.String(http.StatusOK, principal.UserID+" "+string(principal.Role))
+	})
+	r.PUT("/me/password", jwtAuth, middleware.RequireSession(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func TestJWTAuth_AccessToken(t *testing.T) {
+	r := newAPIKeyRouter(nil)
+	signer := auth.NewSigner(testKeys, "test", auth.DefaultAudience)
+	token, err := utils.GenerateJWT("alice", "user", "session", nil, signer, time.Minute)
+	require.NoError(t, err)
+	foreign, err := utils.GenerateJWT("alice", "user", "session", nil, auth.NewSigner(testKeys, "test", "other-api"), time.Minute)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"valid", token, http.StatusOK},
+		{"other audience", foreign, http.StatusUnauthorized},
+		{"garbage", "not-a-token", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, "alice user", w.Body.String())
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/me/password", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "sessions may use session routes")
+}
+
+func TestJWTAuth_APIKey(t *testing.T) {
+	alice := &model.User{ID: primitive.NewObjectID(), Role: model.RoleAdmin}
+	r := newAPIKeyRouter(stubAPIKeys{"7s_alice": alice})
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{"api key header", "X-API-Key", "7s_alice", http.StatusOK},
+		{"authorization scheme", "Authorization", "ApiKey 7s_alice", http.StatusOK},
+		{"unknown key", "X-API-Key", "7s_bob", http.StatusUnauthorized},
+		{"bearer takes precedence", "Authorization", "Bearer not-a-token", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			req.Header.Set(tt.header, tt.value)
+			if tt.header == "Authorization" {
+				req.Header.Set("X-API-Key", "7s_alice")
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, alice.ID.Hex()+" admin", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestJWTAuth_APIKeysDisabled(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("X-API-Key", "7s_alice")
+	w := httptest.NewRecorder()
+	newAPIKeyRouter(nil).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRequireSession_RefusesAPIKeys(t *testing.T) {
+	r := newAPIKeyRouter(stubAPIKeys{"7s_alice": {ID: primitive.NewObjectID()}})
+	req := httptest.NewRequest(http.MethodPut, "/me/password", nil)
+	req.Header.Set("X-API-Key", "7s_alice")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
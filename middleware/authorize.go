@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/policy"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Authorize must run after JWTAuth. The ":id" path parameter, when present,
+// is the resource the action targets.
+func Authorize(p policy.Policy, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !p.Allow(action, Principal(c).Subject(), c.Param("id")) {
+			apperr.WriteProblem(c, apperr.New(apperr.ErrForbidden, "permission denied"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScopes must run after JWTAuth. It refuses tokens and API keys that
+// were not granted every one of scopes, whatever their user's role allows.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	required := strings.Join(scopes, " ")
+	return func(c *gin.Context) {
+		if !policy.HasScopes(Principal(c).Scopes, scopes...) {
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, required))
+			apperr.WriteProblem(c, apperr.New(apperr.ErrForbidden, "insufficient scope, requires "+required))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,89 @@
+package middleware_test
+
+import (
+	"7-solutions/auth"
+	"7-solutions/middleware"
+	"7-solutions/model"
+	"7-solutions/policy"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAuthorizeRouter(userID, role string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	fakeAuth := func(c *gin.Context) {
+		principal := &auth.Principal{UserID: userID, Role: model.Role(role)}
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), principal))
+	}
+	r.PUT("/users/:id", fakeAuth, middleware.Authorize(policy.Default(), policy.ActionUpdateUser), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.DELETE("/users/:id", fakeAuth, middleware.Authorize(policy.Default(), policy.ActionDeleteUser), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return r
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name   string
+		role   string
+		method string
+		path   string
+		status int
+	}{
+		{"user updates self", "user", http.MethodPut, "/users/alice", http.StatusOK},
+		{"user updates other", "user", http.MethodPut, "/users/bob", http.StatusForbidden},
+		{"admin updates other", "admin", http.MethodPut, "/users/bob", http.StatusOK},
+		{"user deletes self", "user", http.MethodDelete, "/users/alice", http.StatusForbidden},
+		{"admin deletes other", "admin", http.MethodDelete, "/users/bob", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newAuthorizeRouter("alice", tt.role)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestRequireScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		status int
+	}{
+		{"exact scope", []string{policy.ScopeUsersWrite}, http.StatusOK},
+		{"implied scope", []string{policy.ScopeUsersAdmin}, http.StatusOK},
+		{"narrower scope", []string{policy.ScopeUsersRead}, http.StatusForbidden},
+		{"no scopes", nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			fakeAuth := func(c *gin.Context) {
+				principal := &auth.Principal{UserID: "alice", Scopes: tt.scopes}
+				c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), principal))
+			}
+			r.PUT("/users/:id", fakeAuth, middleware.RequireScopes(policy.ScopeUsersWrite), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/users/alice", nil))
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusForbidden {
+				assert.Equal(t, `Bearer error="insufficient_scope", scope="users:write"`, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
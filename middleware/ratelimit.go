@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/auth"
+	"7-solutions/ratelimit"
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit takes a token from the caller's bucket for the matched route,
+// keyed as described by ratelimit.ClientKey. It sets RateLimit-* headers and
+// answers 429 with Retry-After once the bucket is empty. If the limiter
+// fails, requests are let through.
+func RateLimit(limiter ratelimit.Limiter, rules ratelimit.Rules, verifier *auth.Verifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, bucket := rules.For(c.Request.Method + " " + c.FullPath())
+		if limit.Unlimited() {
+			c.Next()
+			return
+		}
+
+		client := ratelimit.ClientKey(c.Request.Context(), verifier, c.GetHeader("Authorization"), c.GetHeader("X-API-Key"), c.ClientIP())
+		res, err := limiter.Allow(c.Request.Context(), bucket+"|"+client, limit)
+		if err != nil {
+			log.Printf("rate limiter: %v", err)
+			c.Next()
+			return
+		}
+
+		for name, value := range res.Header() {
+			c.Header(name, value)
+		}
+		if !res.Allowed {
+			apperr.WriteProblem(c, apperr.WithRetryAfter(ratelimit.ErrRateLimited, res.RetryAfter))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,92 @@
+package middleware_test
+
+import (
+	"7-solutions/auth"
+	"7-solutions/keys"
+	"7-solutions/middleware"
+	"7-solutions/ratelimit"
+	"7-solutions/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testKeys          = keys.NewHMACManager("secret")
+	rateLimitSigner   = auth.NewSigner(testKeys, "test", auth.DefaultAudience)
+	rateLimitVerifier = auth.NewVerifier(testKeys, "test", auth.DefaultAudience)
+)
+
+func newRateLimitRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	rules, err := ratelimit.ParseRules("3/1m", []string{"POST /login=1/1m"})
+	require.NoError(t, err)
+	r := gin.New()
+	r.Use(middleware.RateLimit(ratelimit.NewMemoryLimiter(), rules, rateLimitVerifier))
+	r.POST("/login", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/users/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func serve(r *gin.Engine, method, path, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimit_PerRoute(t *testing.T) {
+	r := newRateLimitRouter(t)
+
+	w := serve(r, http.MethodPost, "/login", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	w = serve(r, http.MethodPost, "/login", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+
+	w = serve(r, http.MethodGet, "/users/1", "")
+	assert.Equal(t, http.StatusOK, w.Code, "other routes use the default bucket")
+	assert.Equal(t, "3", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Remaining"))
+}
+
+func TestRateLimit_KeysByUser(t *testing.T) {
+	r := newRateLimitRouter(t)
+	alice, err := utils.GenerateJWT("alice", "user", "session", nil, rateLimitSigner, time.Minute)
+	require.NoError(t, err)
+	bob, err := utils.GenerateJWT("bob", "user", "session", nil, rateLimitSigner, time.Minute)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/users/1", "Bearer "+alice).Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, serve(r, http.MethodGet, "/users/1", "Bearer "+alice).Code)
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/users/1", "Bearer "+bob).Code)
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/users/1", "").Code)
+}
+
+func TestRateLimit_KeysByAPIKey(t *testing.T) {
+	r := newRateLimitRouter(t)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/users/1", "ApiKey 7s_alice").Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("X-API-Key", "7s_alice")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "both headers count against the same key")
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/users/1", "ApiKey 7s_bob").Code)
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/users/1", "").Code)
+}
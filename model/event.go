@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+type UserEventType string
+
+const (
+	UserCreated UserEventType = "created"
+	UserUpdated UserEventType = "updated"
+	UserDeleted UserEventType = "deleted"
+)
+
+type UserEvent struct {
+	Type       UserEventType
+	User       User
+	OccurredAt time.Time
+}
@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// OAuthClient is an application that signs users in through the OpenID
+// Connect provider. Public clients, such as single-page apps, have no secret.
+type OAuthClient struct {
+	ID           string    `bson:"_id" json:"client_id"`
+	Name         string    `bson:"name" json:"name"`
+	SecretHash   string    `bson:"secret_hash,omitempty" json:"-"`
+	RedirectURIs []string  `bson:"redirect_uris" json:"redirect_uris"`
+	CreatedAt    time.Time `bson:"created_at" json:"created_at"`
+}
+
+// Public reports whether the client authenticates with PKCE alone.
+func (c *OAuthClient) Public() bool {
+	return c.SecretHash == ""
+}
+
+// AllowsRedirect reports whether uri exactly matches a registered redirect URI.
+func (c *OAuthClient) AllowsRedirect(uri string) bool {
+	for _, allowed := range c.RedirectURIs {
+		if uri == allowed {
+			return true
+		}
+	}
+	return false
+}
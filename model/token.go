@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	FamilyID  string             `bson:"family_id" json:"family_id"`
+	UserID    string             `bson:"user_id" json:"user_id"`
+	Used      bool               `bson:"used" json:"used"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// OneTimeToken is a hashed token that can be redeemed once, such as a password
+// reset token. Purpose keeps tokens issued for one flow out of the others.
+type OneTimeToken struct {
+	TokenHash string    `bson:"_id" json:"-"`
+	Purpose   string    `bson:"purpose" json:"purpose"`
+	UserID    string    `bson:"user_id" json:"user_id"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	// Data carries what the flow must recall on redemption, such as the
+	// client and PKCE challenge of an authorization code.
+	Data map[string]string `bson:"data,omitempty" json:"-"`
+}
@@ -6,10 +6,41 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+type Role string
+
+const (
+	RoleAdmin Role = "admin"
+	RoleUser  Role = "user"
+)
+
+// User is an account. Password is empty for accounts created through an
+// external identity provider until the user sets one with a password reset.
 type User struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name      string             `bson:"name" json:"name" validate:"required"`
-	Email     string             `bson:"email" json:"email" validate:"required,email"`
-	Password  string             `bson:"password" json:"-" validate:"required"`
-	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name       string             `bson:"name" json:"name" validate:"required"`
+	Email      string             `bson:"email" json:"email" validate:"required,email"`
+	Password   string             `bson:"password" json:"-"`
+	Role       Role               `bson:"role" json:"role"`
+	Verified   bool               `bson:"verified" json:"verified"`
+	MFA        MFA                `bson:"mfa,omitempty" json:"-"`
+	Identities []Identity         `bson:"identities,omitempty" json:"identities,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Identity links a user to their account at an external identity provider.
+// Subject is the provider's stable id for that account.
+type Identity struct {
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"subject"`
+	Email    string    `bson:"email,omitempty" json:"email,omitempty"`
+	LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
+}
+
+// MFA is a user's second factor. TOTPSecret is set at enrollment and
+// TOTPEnabled once the user has confirmed it with a code.
+type MFA struct {
+	TOTPSecret  string `bson:"totp_secret,omitempty"`
+	TOTPEnabled bool   `bson:"totp_enabled,omitempty"`
+	// RecoveryCodes holds the hashes of the unused recovery codes.
+	RecoveryCodes []string `bson:"recovery_codes,omitempty"`
 }
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// UserFilter narrows a user listing. Zero values are ignored.
+type UserFilter struct {
+	NamePrefix    string
+	EmailPrefix   string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// UserQuery selects one page of users. After is the opaque cursor returned as
+// NextCursor by the previous page.
+type UserQuery struct {
+	Filter     UserFilter
+	SortBy     string
+	Descending bool
+	After      string
+	Limit      int64
+}
+
+type UserPage struct {
+	Users      []User `json:"data"`
+	NextCursor string `json:"next_cursor"`
+	Total      int64  `json:"total"`
+}
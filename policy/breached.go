@@ -0,0 +1,178 @@
+package policy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// BreachedPasswords reports passwords known from data breaches.
+type BreachedPasswords interface {
+	Contains(password string) bool
+}
+
+const (
+	digestLength = 2 * sha1.Size
+	// breachedScanWindow is how close the binary search narrows in before
+	// the remaining lines are read one by one.
+	breachedScanWindow = 4096
+)
+
+// breachedList looks passwords up in a sorted digest file without loading
+// it, so the full Pwned Passwords corpus (tens of gigabytes) can be used.
+// Each lookup is a binary search over byte offsets, reading a few dozen
+// lines.
+type breachedList struct {
+	r    io.ReaderAt
+	size int64
+}
+
+// LoadBreachedPasswords opens a breach corpus for lookups; see
+// NewBreachedPasswords for the format. The file stays open for the life of
+// the process.
+func LoadBreachedPasswords(path string) (BreachedPasswords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	list, err := NewBreachedPasswords(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return list, nil
+}
+
+// NewBreachedPasswords searches the size bytes of r, which must be in the
+// Pwned Passwords SHA-1 download format ordered by hash: one 40-digit
+// hexadecimal SHA-1 digest per line, optionally followed by ":count", sorted
+// in ascending order and all in the same letter case. Lines end with "\n" or
+// "\r\n". Comments and blank lines are not allowed, since lookups jump into
+// the middle of the file. Only the first line is checked up front.
+func NewBreachedPasswords(r io.ReaderAt, size int64) (BreachedPasswords, error) {
+	list := &breachedList{r: r, size: size}
+	if size == 0 {
+		return list, nil
+	}
+	first, err := list.readLine(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := lineDigest(first); !ok {
+		return nil, errors.New("breached passwords line 1: not a SHA-1 hex digest")
+	}
+	return list, nil
+}
+
+func (l *breachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	found, err := l.search(digest)
+	if err != nil {
+		log.Printf("searching breached passwords: %v", err)
+	}
+	return found
+}
+
+// search narrows [lo, hi) so that lo starts a line sorting before digest, or
+// is 0, and the line that would hold digest starts at or before hi, then
+// reads forward from lo.
+func (l *breachedList) search(digest string) (bool, error) {
+	lo, hi := int64(0), l.size
+	for hi-lo > breachedScanWindow {
+		start, err := l.lineStart((lo + hi) / 2)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			break
+		}
+		line, err := l.readLine(start)
+		if err != nil {
+			return false, err
+		}
+		d, ok := lineDigest(line)
+		if !ok {
+			return false, fmt.Errorf("malformed line at offset %d", start)
+		}
+		if d < digest {
+			lo = start
+		} else {
+			hi = start
+		}
+	}
+
+	scanner := bufio.NewScanner(io.NewSectionReader(l.r, lo, l.size-lo))
+	for scanner.Scan() {
+		d, ok := lineDigest(scanner.Bytes())
+		if !ok {
+			continue
+		}
+		if d == digest {
+			return true, nil
+		}
+		if d > digest {
+			break
+		}
+	}
+	return false, scanner.Err()
+}
+
+// lineStart returns the offset of the first line starting at or after off.
+func (l *breachedList) lineStart(off int64) (int64, error) {
+	if off == 0 {
+		return 0, nil
+	}
+	buf := make([]byte, 128)
+	for pos := off - 1; pos < l.size; pos += int64(len(buf)) {
+		n, err := l.r.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return l.size, nil
+}
+
+// readLine returns the line starting at off, without its line ending.
+func (l *breachedList) readLine(off int64) ([]byte, error) {
+	buf := make([]byte, 128)
+	n, err := l.r.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	line := buf[:n]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	return bytes.TrimRight(line, "\r"), nil
+}
+
+// lineDigest returns the upper-case digest at the start of line.
+func lineDigest(line []byte) (string, bool) {
+	if len(line) < digestLength || (len(line) > digestLength && line[digestLength] != ':' && line[digestLength] != '\r') {
+		return "", false
+	}
+	digest := strings.ToUpper(string(line[:digestLength]))
+	if _, err := hex.DecodeString(digest); err != nil {
+		return "", false
+	}
+	return digest, true
+}
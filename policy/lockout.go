@@ -0,0 +1,61 @@
+package policy
+
+import "time"
+
+// LockoutPolicy limits failed logins. Failures are counted per account and
+// per client IP over Window. Once an account has FreeAttempts failures, each
+// further failure makes it wait BaseDelay before the next attempt, doubling up
+// to MaxDelay. MaxAttempts failures lock the account for LockoutDuration, and
+// MaxIPAttempts failures block the client IP for the same time. A zero limit
+// disables that rule.
+type LockoutPolicy struct {
+	Window          time.Duration
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	MaxAttempts     int
+	LockoutDuration time.Duration
+	MaxIPAttempts   int
+}
+
+func DefaultLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		Window:          15 * time.Minute,
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		MaxAttempts:     10,
+		LockoutDuration: 15 * time.Minute,
+		MaxIPAttempts:   100,
+	}
+}
+
+// Backoff returns how long an account must wait after its failures-th
+// failure. It is zero while failures is within FreeAttempts.
+func (p LockoutPolicy) Backoff(failures int64) time.Duration {
+	extra := failures - int64(p.FreeAttempts)
+	if extra <= 0 || p.BaseDelay <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := int64(1); i < extra; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// LocksAccount reports whether failures reach the account lockout limit.
+func (p LockoutPolicy) LocksAccount(failures int64) bool {
+	return p.MaxAttempts > 0 && failures >= int64(p.MaxAttempts)
+}
+
+// BlocksIP reports whether failures reach the client IP limit.
+func (p LockoutPolicy) BlocksIP(failures int64) bool {
+	return p.MaxIPAttempts > 0 && failures >= int64(p.MaxIPAttempts)
+}
@@ -0,0 +1,42 @@
+package policy_test
+
+import (
+	"7-solutions/policy"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockoutPolicy_Backoff(t *testing.T) {
+	p := policy.LockoutPolicy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{1, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{60, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, p.Backoff(tt.failures), "after %d failures", tt.failures)
+	}
+
+	assert.Zero(t, policy.LockoutPolicy{FreeAttempts: 3}.Backoff(10), "no base delay disables backoff")
+}
+
+func TestLockoutPolicy_Limits(t *testing.T) {
+	p := policy.LockoutPolicy{MaxAttempts: 5, MaxIPAttempts: 20}
+	assert.False(t, p.LocksAccount(4))
+	assert.True(t, p.LocksAccount(5))
+	assert.False(t, p.BlocksIP(19))
+	assert.True(t, p.BlocksIP(20))
+
+	var disabled policy.LockoutPolicy
+	assert.False(t, disabled.LocksAccount(1000))
+	assert.False(t, disabled.BlocksIP(1000))
+}
@@ -0,0 +1,96 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bcryptMaxBytes is the longest input bcrypt hashes; later bytes are ignored.
+const bcryptMaxBytes = 72
+
+// PasswordPolicy decides which new passwords are acceptable.
+type PasswordPolicy struct {
+	MinLength     int // in characters
+	MaxLength     int // in bytes
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// Breached rejects known-compromised passwords when set.
+	Breached BreachedPasswords
+}
+
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{MinLength: 8, MaxLength: bcryptMaxBytes}
+}
+
+// Check returns every rule password breaks, or nil when it is acceptable.
+// personal holds the user's own details, such as email and name, which the
+// password must not contain.
+func (p PasswordPolicy) Check(password string, personal ...string) []string {
+	var problems []string
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters", p.MinLength))
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		problems = append(problems, fmt.Sprintf("must be at most %d bytes", p.MaxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		problems = append(problems, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		problems = append(problems, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		problems = append(problems, "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		problems = append(problems, "must contain a symbol")
+	}
+
+	if containsPersonal(password, personal) {
+		problems = append(problems, "must not contain your email or name")
+	}
+	if p.Breached != nil && p.Breached.Contains(password) {
+		problems = append(problems, "appears in a known data breach; choose another")
+	}
+	return problems
+}
+
+// minPersonalLength keeps short name parts such as "Al" from rejecting
+// unrelated passwords.
+const minPersonalLength = 3
+
+// containsPersonal reports whether password contains, ignoring case, any of
+// the given details, the local part of an email, or a word of a name.
+func containsPersonal(password string, personal []string) bool {
+	lower := strings.ToLower(password)
+	for _, detail := range personal {
+		candidates := strings.Fields(strings.ToLower(detail))
+		if local, _, ok := strings.Cut(strings.ToLower(detail), "@"); ok {
+			candidates = append(candidates, local)
+		}
+		for _, c := range candidates {
+			if utf8.RuneCountInString(c) >= minPersonalLength && strings.Contains(lower, c) {
+				return true
+			}
+		}
+	}
+	return false
+}
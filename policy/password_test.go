@@ -0,0 +1,103 @@
+package policy_test
+
+import (
+	"7-solutions/policy"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	strict := policy.PasswordPolicy{
+		MinLength:     10,
+		MaxLength:     72,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+	}
+
+	tests := []struct {
+		name     string
+		policy   policy.PasswordPolicy
+		password string
+		problems []string
+	}{
+		{"default accepts passphrase", policy.DefaultPasswordPolicy(), "correct horse battery", nil},
+		{"too short", policy.DefaultPasswordPolicy(), "abc", []string{"must be at least 8 characters"}},
+		{"length counts characters", policy.DefaultPasswordPolicy(), "ééééééé", []string{"must be at least 8 characters"}},
+		{"beyond bcrypt limit", policy.DefaultPasswordPolicy(), strings.Repeat("a", 73), []string{"must be at most 72 bytes"}},
+		{"strict accepts mixed", strict, "Tr0ub4dor&3x", nil},
+		{"strict missing classes", strict, "lowercaseonly", []string{
+			"must contain an uppercase letter",
+			"must contain a digit",
+			"must contain a symbol",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.problems, tt.policy.Check(tt.password))
+		})
+	}
+}
+
+func TestPasswordPolicy_RejectsPersonalDetails(t *testing.T) {
+	p := policy.DefaultPasswordPolicy()
+	personal := []string{"Wasawat.Test@example.com", "Wasawat Test"}
+
+	assert.Contains(t, p.Check("my-wasawat.test@example.com", personal...), "must not contain your email or name")
+	assert.Contains(t, p.Check("WASAWAT.TEST!!", personal...), "must not contain your email or name")
+	assert.Contains(t, p.Check("i-am-wasawat", personal...), "must not contain your email or name")
+	assert.Empty(t, p.Check("correct horse battery", personal...))
+	assert.Empty(t, p.Check("al-is-short", "Al"))
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	// SHA-1 of "password" and "123456", sorted.
+	corpus := "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\r\n7C4A8D09CA3762AF61E59520943DC26494F8941B:37359195\r\n"
+	list, err := policy.NewBreachedPasswords(strings.NewReader(corpus), int64(len(corpus)))
+	require.NoError(t, err)
+	assert.True(t, list.Contains("password"))
+	assert.True(t, list.Contains("123456"))
+	assert.False(t, list.Contains("correct horse battery"))
+
+	p := policy.DefaultPasswordPolicy()
+	p.Breached = list
+	assert.Equal(t, []string{"appears in a known data breach; choose another"}, p.Check("password"))
+
+	_, err = policy.NewBreachedPasswords(strings.NewReader("not-a-hash\n"), 11)
+	assert.Error(t, err)
+}
+
+func TestBreachedPasswords_LargeFile(t *testing.T) {
+	var digests []string
+	for i := 0; i < 5000; i++ {
+		sum := sha1.Sum([]byte(fmt.Sprintf("breached-%d", i)))
+		digests = append(digests, strings.ToUpper(hex.EncodeToString(sum[:])))
+	}
+	sort.Strings(digests)
+	var corpus strings.Builder
+	for i, digest := range digests {
+		fmt.Fprintf(&corpus, "%s:%d\n", digest, i+1)
+	}
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	require.NoError(t, os.WriteFile(path, []byte(corpus.String()), 0o600))
+
+	list, err := policy.LoadBreachedPasswords(path)
+	require.NoError(t, err)
+	for i := 0; i < 5000; i++ {
+		assert.True(t, list.Contains(fmt.Sprintf("breached-%d", i)), "breached-%d", i)
+	}
+	for i := 0; i < 100; i++ {
+		assert.False(t, list.Contains(fmt.Sprintf("safe-%d", i)), "safe-%d", i)
+	}
+}
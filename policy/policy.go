@@ -0,0 +1,69 @@
+package policy
+
+import "7-solutions/model"
+
+const (
+	ActionListUsers  = "users:list"
+	ActionGetUser    = "users:get"
+	ActionUpdateUser = "users:update"
+	ActionDeleteUser = "users:delete"
+	ActionUnlockUser = "users:unlock"
+
+	ActionRegisterOAuthClient = "oauth_clients:create"
+)
+
+// Subject is the authenticated caller a rule is evaluated for.
+type Subject struct {
+	UserID string
+	Role   model.Role
+}
+
+// Rule decides whether subject may act on the resource with resourceID.
+type Rule func(subject Subject, resourceID string) bool
+
+func Authenticated(subject Subject, resourceID string) bool {
+	return subject.UserID != ""
+}
+
+func Admin(subject Subject, resourceID string) bool {
+	return subject.Role == model.RoleAdmin
+}
+
+func Self(subject Subject, resourceID string) bool {
+	return subject.UserID != "" && subject.UserID == resourceID
+}
+
+// AnyOf allows the action when at least one of rules does.
+func AnyOf(rules ...Rule) Rule {
+	return func(subject Subject, resourceID string) bool {
+		for _, rule := range rules {
+			if rule(subject, resourceID) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Policy maps actions to the rule guarding them. Actions without a rule are denied.
+type Policy map[string]Rule
+
+func Default() Policy {
+	return Policy{
+		ActionListUsers:  Admin,
+		ActionGetUser:    AnyOf(Self, Admin),
+		ActionUpdateUser: AnyOf(Self, Admin),
+		ActionDeleteUser: Admin,
+		ActionUnlockUser: Admin,
+
+		ActionRegisterOAuthClient: Admin,
+	}
+}
+
+func (p Policy) Allow(action string, subject Subject, resourceID string) bool {
+	rule, ok := p[action]
+	if !ok {
+		return false
+	}
+	return rule(subject, resourceID)
+}
@@ -0,0 +1,45 @@
+package policy_test
+
+import (
+	"7-solutions/model"
+	"7-solutions/policy"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	p := policy.Default()
+	admin := policy.Subject{UserID: "admin-1", Role: model.RoleAdmin}
+	alice := policy.Subject{UserID: "alice", Role: model.RoleUser}
+
+	tests := []struct {
+		action   string
+		subject  policy.Subject
+		resource string
+		allowed  bool
+	}{
+		{policy.ActionListUsers, admin, "", true},
+		{policy.ActionListUsers, alice, "", false},
+		{policy.ActionGetUser, alice, "alice", true},
+		{policy.ActionGetUser, alice, "bob", false},
+		{policy.ActionGetUser, admin, "bob", true},
+		{policy.ActionUpdateUser, alice, "alice", true},
+		{policy.ActionUpdateUser, alice, "bob", false},
+		{policy.ActionUpdateUser, admin, "bob", true},
+		{policy.ActionDeleteUser, alice, "alice", false},
+		{policy.ActionDeleteUser, admin, "bob", true},
+		{policy.ActionUnlockUser, alice, "alice", false},
+		{policy.ActionUnlockUser, admin, "bob", true},
+		{"users:unknown", admin, "", false},
+	}
+
+	for _, tt := range tests {
+		got := p.Allow(tt.action, tt.subject, tt.resource)
+		assert.Equal(t, tt.allowed, got, "%s by %s on %q", tt.action, tt.subject.UserID, tt.resource)
+	}
+}
+
+func TestSelf_RequiresUserID(t *testing.T) {
+	assert.False(t, policy.Self(policy.Subject{}, ""))
+}
@@ -0,0 +1,57 @@
+package policy
+
+import (
+	"7-solutions/model"
+	"strings"
+)
+
+// Scopes limit what a token or API key may do, on top of what its user's role
+// allows. A scope includes the scopes below it: users:admin includes
+// users:write, which includes users:read.
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeUsersAdmin = "users:admin"
+)
+
+var impliedScopes = map[string][]string{
+	ScopeUsersRead:  {ScopeUsersRead},
+	ScopeUsersWrite: {ScopeUsersWrite, ScopeUsersRead},
+	ScopeUsersAdmin: {ScopeUsersAdmin, ScopeUsersWrite, ScopeUsersRead},
+}
+
+// KnownScope reports whether scope is one of the scopes above.
+func KnownScope(scope string) bool {
+	_, ok := impliedScopes[scope]
+	return ok
+}
+
+// RoleScopes returns every scope useful to role. Sessions started with a
+// password get all of them.
+func RoleScopes(role model.Role) []string {
+	if role == model.RoleAdmin {
+		return []string{ScopeUsersRead, ScopeUsersWrite, ScopeUsersAdmin}
+	}
+	return []string{ScopeUsersRead, ScopeUsersWrite}
+}
+
+// HasScopes reports whether granted includes every scope in required.
+func HasScopes(granted []string, required ...string) bool {
+	have := make(map[string]bool)
+	for _, scope := range granted {
+		for _, implied := range impliedScopes[scope] {
+			have[implied] = true
+		}
+	}
+	for _, scope := range required {
+		if !have[scope] {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseScope splits a space-delimited scope claim (RFC 6749 section 3.3).
+func ParseScope(scope string) []string {
+	return strings.Fields(scope)
+}
@@ -0,0 +1,41 @@
+package policy_test
+
+import (
+	"7-solutions/model"
+	"7-solutions/policy"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasScopes(t *testing.T) {
+	tests := []struct {
+		granted  []string
+		required []string
+		want     bool
+	}{
+		{[]string{policy.ScopeUsersRead}, []string{policy.ScopeUsersRead}, true},
+		{[]string{policy.ScopeUsersRead}, []string{policy.ScopeUsersWrite}, false},
+		{[]string{policy.ScopeUsersWrite}, []string{policy.ScopeUsersRead}, true},
+		{[]string{policy.ScopeUsersAdmin}, []string{policy.ScopeUsersRead, policy.ScopeUsersWrite}, true},
+		{[]string{"users:unknown"}, []string{policy.ScopeUsersRead}, false},
+		{nil, []string{policy.ScopeUsersRead}, false},
+		{nil, nil, true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, policy.HasScopes(tt.granted, tt.required...), "%v requires %v", tt.granted, tt.required)
+	}
+}
+
+func TestRoleScopes(t *testing.T) {
+	assert.False(t, policy.HasScopes(policy.RoleScopes(model.RoleUser), policy.ScopeUsersAdmin))
+	assert.True(t, policy.HasScopes(policy.RoleScopes(model.RoleUser), policy.ScopeUsersWrite))
+	assert.True(t, policy.HasScopes(policy.RoleScopes(model.RoleAdmin), policy.ScopeUsersAdmin))
+}
+
+func TestParseScope(t *testing.T) {
+	assert.Equal(t, []string{"users:read", "users:write"}, policy.ParseScope(" users:read  users:write "))
+	assert.Empty(t, policy.ParseScope(""))
+	assert.True(t, policy.KnownScope(policy.ScopeUsersWrite))
+	assert.False(t, policy.KnownScope("users:*"))
+}
@@ -0,0 +1,179 @@
+// Package ratelimit implements token bucket request limits shared by the HTTP
+// and gRPC servers. Buckets live in process memory or in Redis.
+package ratelimit
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/auth"
+	"7-solutions/utils"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrRateLimited = apperr.New(apperr.ErrTooManyRequests, "rate limit exceeded")
+
+// Limit allows bursts of Requests, refilled evenly over Per. The zero Limit
+// is unlimited.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit reads "<requests>/<duration>", e.g. "100/1m". "0" and "off"
+// mean unlimited.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "0" || s == "off" {
+		return Limit{}, nil
+	}
+	requests, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q: want <requests>/<duration>", s)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 0 {
+		return Limit{}, fmt.Errorf("rate limit %q: invalid request count", s)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q: invalid duration", s)
+	}
+	return Limit{Requests: n, Per: d}, nil
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Requests <= 0 || l.Per <= 0
+}
+
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Per)
+}
+
+// perSecond is the refill rate in tokens per second.
+func (l Limit) perSecond() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining int
+	// RetryAfter is how long until a token is available; zero when Allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+func newResult(limit Limit, tokens float64, allowed bool) Result {
+	rate := limit.perSecond()
+	r := Result{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(limit.Requests) - tokens) / rate),
+	}
+	if !allowed {
+		r.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	return r
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Header returns the RateLimit-* response fields for r, with reset times in
+// whole seconds.
+func (r Result) Header() map[string]string {
+	return map[string]string{
+		"RateLimit-Limit":     strconv.Itoa(r.Limit.Requests),
+		"RateLimit-Remaining": strconv.Itoa(r.Remaining),
+		"RateLimit-Reset":     strconv.Itoa(int(math.Ceil(r.Reset.Seconds()))),
+		"RateLimit-Policy":    fmt.Sprintf("%d;w=%d", r.Limit.Requests, int(math.Ceil(r.Limit.Per.Seconds()))),
+	}
+}
+
+// Limiter takes one token from the bucket named key, creating a full bucket
+// for limit when it does not exist yet.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// Rules assigns limits to routes: "METHOD /path" patterns as registered with
+// Gin, or full gRPC method names. Routes not listed share the Default bucket.
+type Rules struct {
+	Default Limit
+	Routes  map[string]Limit
+}
+
+// DefaultRoutes are tighter limits for the endpoints that accept credentials,
+// create accounts or send email.
+var DefaultRoutes = []string{
+	"POST /login=10/1m",
+	"POST /login/mfa=10/1m",
+	"POST /authorize=10/1m",
+	"GET /auth/:provider/callback=10/1m",
+	"POST /register=10/1m",
+	"POST /password/forgot=5/1m",
+	"POST /verify-email/resend=5/1m",
+	"/user.UserService/Login=10/1m",
+	"/user.UserService/LoginMFA=10/1m",
+	"/user.UserService/CreateUser=10/1m",
+}
+
+// ParseRules reads routes given as "<route>=<limit>".
+func ParseRules(defaultLimit string, routes []string) (Rules, error) {
+	def, err := ParseLimit(defaultLimit)
+	if err != nil {
+		return Rules{}, err
+	}
+	rules := Rules{Default: def, Routes: make(map[string]Limit, len(routes))}
+	for _, entry := range routes {
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return Rules{}, fmt.Errorf("rate limit route %q: want <route>=<limit>", entry)
+		}
+		limit, err := ParseLimit(entry[i+1:])
+		if err != nil {
+			return Rules{}, err
+		}
+		rules.Routes[strings.TrimSpace(entry[:i])] = limit
+	}
+	return rules, nil
+}
+
+// For returns the limit of route and the bucket prefix it counts against.
+func (r Rules) For(route string) (Limit, string) {
+	if limit, ok := r.Routes[route]; ok {
+		return limit, route
+	}
+	return r.Default, "*"
+}
+
+// ClientKey names the caller a bucket belongs to: the user of a bearer token
+// in authorization that verifier accepts, else the API key in authorization
+// or apiKey, else the client IP. Tokens go through the same checks as
+// authentication, so a revoked token or one for another audience counts
+// against the IP. API keys are told apart by the hash of the presented
+// secret without looking them up, so limiting costs no database round trip;
+// authentication is left to the auth middleware.
+func ClientKey(ctx context.Context, verifier *auth.Verifier, authorization, apiKey, ip string) string {
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if ok && strings.EqualFold(scheme, "bearer") {
+		if principal, err := verifier.Verify(ctx, token); err == nil {
+			return "user:" + principal.UserID
+		}
+	}
+	if secret := utils.APIKeyCredential(authorization, apiKey); secret != "" {
+		return "key:" + utils.HashToken(secret)
+	}
+	return "ip:" + ip
+}
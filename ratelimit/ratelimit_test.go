@@ -0,0 +1,140 @@
+package ratelimit_test
+
+import (
+	"7-solutions/auth"
+	"7-solutions/keys"
+	"7-solutions/ratelimit"
+	"7-solutions/repository"
+	"7-solutions/utils"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func limiters(t *testing.T) map[string]ratelimit.Limiter {
+	srv := miniredis.RunT(t)
+	return map[string]ratelimit.Limiter{
+		"memory": ratelimit.NewMemoryLimiter(),
+		"redis":  ratelimit.NewRedisLimiter(redis.NewClient(&redis.Options{Addr: srv.Addr()})),
+	}
+}
+
+func TestLimiter_TokenBucket(t *testing.T) {
+	limit := ratelimit.Limit{Requests: 3, Per: time.Minute}
+	for name, limiter := range limiters(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for want := 2; want >= 0; want-- {
+				res, err := limiter.Allow(ctx, "ip:10.0.0.1", limit)
+				require.NoError(t, err)
+				assert.True(t, res.Allowed)
+				assert.Equal(t, want, res.Remaining)
+			}
+
+			res, err := limiter.Allow(ctx, "ip:10.0.0.1", limit)
+			require.NoError(t, err)
+			assert.False(t, res.Allowed)
+			assert.Equal(t, 0, res.Remaining)
+			// One token refills every 20s.
+			assert.InDelta(t, 20, res.RetryAfter.Seconds(), 1)
+			assert.InDelta(t, 60, res.Reset.Seconds(), 1)
+
+			res, err = limiter.Allow(ctx, "ip:10.0.0.2", limit)
+			require.NoError(t, err)
+			assert.True(t, res.Allowed, "buckets are per key")
+		})
+	}
+}
+
+func TestLimiter_Refills(t *testing.T) {
+	limit := ratelimit.Limit{Requests: 1, Per: 50 * time.Millisecond}
+	for name, limiter := range limiters(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			res, err := limiter.Allow(ctx, "k", limit)
+			require.NoError(t, err)
+			require.True(t, res.Allowed)
+			res, err = limiter.Allow(ctx, "k", limit)
+			require.NoError(t, err)
+			require.False(t, res.Allowed)
+
+			time.Sleep(60 * time.Millisecond)
+			res, err = limiter.Allow(ctx, "k", limit)
+			require.NoError(t, err)
+			assert.True(t, res.Allowed)
+		})
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ratelimit.ParseRules("100/1m", []string{"POST /login=5/1m", "/user.UserService/Login=off"})
+	require.NoError(t, err)
+
+	limit, bucket := rules.For("POST /login")
+	assert.Equal(t, ratelimit.Limit{Requests: 5, Per: time.Minute}, limit)
+	assert.Equal(t, "POST /login", bucket)
+	limit, _ = rules.For("/user.UserService/Login")
+	assert.True(t, limit.Unlimited())
+	limit, bucket = rules.For("GET /users/:id")
+	assert.Equal(t, ratelimit.Limit{Requests: 100, Per: time.Minute}, limit)
+	assert.Equal(t, "*", bucket)
+
+	for _, bad := range []string{"100", "x/1m", "10/soon", "10/0s"} {
+		_, err := ratelimit.ParseLimit(bad)
+		assert.Error(t, err, bad)
+	}
+	_, err = ratelimit.ParseRules("100/1m", []string{"POST /login"})
+	assert.Error(t, err)
+}
+
+func TestResult_Header(t *testing.T) {
+	res, err := ratelimit.NewMemoryLimiter().Allow(context.Background(), "k", ratelimit.Limit{Requests: 10, Per: time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"RateLimit-Limit":     "10",
+		"RateLimit-Remaining": "9",
+		"RateLimit-Reset":     "6",
+		"RateLimit-Policy":    "10;w=60",
+	}, res.Header())
+}
+
+func TestClientKey(t *testing.T) {
+	tokenKeys := keys.NewHMACManager("secret")
+	revocations := repository.NewMemoryTokenStore()
+	verifier := auth.NewVerifier(tokenKeys, "test", auth.DefaultAudience, auth.WithRevocations(revocations))
+	sign := func(userID string, signer utils.TokenSigner) string {
+		token, err := utils.GenerateJWT(userID, "user", "session", nil, signer, time.Minute)
+		require.NoError(t, err)
+		return token
+	}
+	token := sign("alice", auth.NewSigner(tokenKeys, "test", auth.DefaultAudience))
+	ctx := context.Background()
+	revoked := sign("bob", auth.NewSigner(tokenKeys, "test", auth.DefaultAudience))
+	require.NoError(t, revocations.RevokeUserTokens(ctx, "bob", time.Now(), ""))
+	aliceKey := "key:" + utils.HashToken("7s_alice")
+
+	tests := []struct {
+		name          string
+		authorization string
+		apiKey        string
+		want          string
+	}{
+		{"bearer token", "Bearer " + token, "7s_alice", "user:alice"},
+		{"forged bearer token", "Bearer forged", "", "ip:10.0.0.1"},
+		{"token from other key", "Bearer " + sign("alice", auth.NewSigner(keys.NewHMACManager("other-secret"), "test", auth.DefaultAudience)), "", "ip:10.0.0.1"},
+		{"token for other audience", "Bearer " + sign("alice", auth.NewSigner(tokenKeys, "test", "other-api")), "", "ip:10.0.0.1"},
+		{"token without issuer", "Bearer " + sign("alice", tokenKeys), "", "ip:10.0.0.1"},
+		{"revoked token", "Bearer " + revoked, "", "ip:10.0.0.1"},
+		{"api key header", "", "7s_alice", aliceKey},
+		{"api key authorization", "ApiKey 7s_alice", "", aliceKey},
+		{"no credentials", "", "", "ip:10.0.0.1"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ratelimit.ClientKey(ctx, verifier, tt.authorization, tt.apiKey, "10.0.0.1"), tt.name)
+	}
+}
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/model"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrAPIKeyNotFound = apperr.New(apperr.ErrNotFound, "api key not found")
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *model.APIKey) (*model.APIKey, error)
+	GetByHash(ctx context.Context, keyHash string) (*model.APIKey, error)
+	// ListByUser returns the user's keys, newest first.
+	ListByUser(ctx context.Context, userID string) ([]model.APIKey, error)
+	// Delete removes the key with id if it belongs to userID.
+	Delete(ctx context.Context, userID, id string) error
+	// DeleteByUser removes every key of userID.
+	DeleteByUser(ctx context.Context, userID string) error
+	MarkUsed(ctx context.Context, id primitive.ObjectID, at time.Time) error
+}
+
+type MongoAPIKeyRepository struct {
+	collection CollectionInterface
+}
+
+// NewAPIKeyRepository ensures the unique index keys are looked up by and the
+// index listing a user's keys.
+func NewAPIKeyRepository(ctx context.Context, db *mongo.Database) (APIKeyRepository, error) {
+	coll := db.Collection("api_keys")
+	_, err := coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MongoAPIKeyRepository{collection: coll}, nil
+}
+
+func NewAPIKeyRepositoryFromCollection(coll CollectionInterface) APIKeyRepository {
+	return &MongoAPIKeyRepository{collection: coll}
+}
+
+func (r *MongoAPIKeyRepository) Create(ctx context.Context, key *model.APIKey) (*model.APIKey, error) {
+	created := *key
+	if created.ID.IsZero() {
+		created.ID = primitive.NewObjectID()
+	}
+	created.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	if _, err := r.collection.InsertOne(ctx, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (r *MongoAPIKeyRepository) GetByHash(ctx context.Context, keyHash string) (*model.APIKey, error) {
+	var key model.APIKey
+	err := r.collection.FindOne(ctx, bson.M{"key_hash": keyHash}).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *MongoAPIKeyRepository) ListByUser(ctx context.Context, userID string) ([]model.APIKey, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []model.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *MongoAPIKeyRepository) Delete(ctx context.Context, userID, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrAPIKeyNotFound
+	}
+	res, err := r.collection.DeleteOne(ctx, bson.M{"_id": objID, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (r *MongoAPIKeyRepository) DeleteByUser(ctx context.Context, userID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+func (r *MongoAPIKeyRepository) MarkUsed(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_used_at": at.UTC().Truncate(time.Millisecond)}})
+	return err
+}
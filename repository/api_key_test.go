@@ -0,0 +1,66 @@
+package repository_test
+
+import (
+	"7-solutions/model"
+	"7-solutions/repository"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestAPIKeyRepository_CreateAndGet(t *testing.T) {
+	mockColl := new(MockCollection)
+	repo := repository.NewAPIKeyRepositoryFromCollection(mockColl)
+
+	mockColl.On("InsertOne", mock.Anything, mock.AnythingOfType("*model.APIKey")).Return(&mongo.InsertOneResult{}, nil)
+	created, err := repo.Create(context.Background(), &model.APIKey{UserID: "u1", Name: "ci", KeyHash: "hash", Scopes: []string{"users:read"}})
+	require.NoError(t, err)
+	assert.False(t, created.ID.IsZero())
+	assert.False(t, created.CreatedAt.IsZero())
+
+	raw, err := bson.Marshal(created)
+	require.NoError(t, err)
+	mockColl.On("FindOne", mock.Anything, bson.M{"key_hash": "hash"}).Return(bson.Raw(raw))
+	found, err := repo.GetByHash(context.Background(), "hash")
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, found.ID)
+	assert.Equal(t, []string{"users:read"}, found.Scopes)
+	assert.Nil(t, found.ExpiresAt)
+	mockColl.AssertExpectations(t)
+}
+
+func TestAPIKeyRepository_DeleteOnlyOwnKeys(t *testing.T) {
+	mockColl := new(MockCollection)
+	repo := repository.NewAPIKeyRepositoryFromCollection(mockColl)
+	id := primitive.NewObjectID()
+
+	mockColl.On("DeleteOne", mock.Anything, bson.M{"_id": id, "user_id": "u1"}).Return(&mongo.DeleteResult{DeletedCount: 1}, nil)
+	mockColl.On("DeleteOne", mock.Anything, bson.M{"_id": id, "user_id": "u2"}).Return(&mongo.DeleteResult{}, nil)
+	assert.NoError(t, repo.Delete(context.Background(), "u1", id.Hex()))
+	assert.ErrorIs(t, repo.Delete(context.Background(), "u2", id.Hex()), repository.ErrAPIKeyNotFound)
+	assert.ErrorIs(t, repo.Delete(context.Background(), "u1", "not-an-id"), repository.ErrAPIKeyNotFound)
+}
+
+func TestAPIKeyRepository_DeleteByUser(t *testing.T) {
+	mockColl := new(MockCollection)
+	repo := repository.NewAPIKeyRepositoryFromCollection(mockColl)
+
+	mockColl.On("DeleteMany", mock.Anything, bson.M{"user_id": "u1"}).Return(&mongo.DeleteResult{DeletedCount: 2}, nil)
+	assert.NoError(t, repo.DeleteByUser(context.Background(), "u1"))
+	mockColl.AssertExpectations(t)
+}
+
+func TestAPIKey_Expired(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Second), now.Add(time.Hour)
+	assert.False(t, (&model.APIKey{}).Expired(now))
+	assert.True(t, (&model.APIKey{ExpiresAt: &past}).Expired(now))
+	assert.False(t, (&model.APIKey{ExpiresAt: &future}).Expired(now))
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/model"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrClientNotFound = apperr.New(apperr.ErrNotFound, "oauth client not found")
+
+type OAuthClientRepository interface {
+	Create(ctx context.Context, client *model.OAuthClient) (*model.OAuthClient, error)
+	GetByID(ctx context.Context, clientID string) (*model.OAuthClient, error)
+}
+
+// MongoOAuthClientRepository keeps clients keyed by their client id.
+type MongoOAuthClientRepository struct {
+	collection CollectionInterface
+}
+
+func NewOAuthClientRepository(db *mongo.Database) OAuthClientRepository {
+	return &MongoOAuthClientRepository{collection: db.Collection("oauth_clients")}
+}
+
+func NewOAuthClientRepositoryFromCollection(coll CollectionInterface) OAuthClientRepository {
+	return &MongoOAuthClientRepository{collection: coll}
+}
+
+func (r *MongoOAuthClientRepository) Create(ctx context.Context, client *model.OAuthClient) (*model.OAuthClient, error) {
+	created := *client
+	created.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	if _, err := r.collection.InsertOne(ctx, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (r *MongoOAuthClientRepository) GetByID(ctx context.Context, clientID string) (*model.OAuthClient, error) {
+	var client model.OAuthClient
+	err := r.collection.FindOne(ctx, bson.M{"_id": clientID}).Decode(&client)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrClientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
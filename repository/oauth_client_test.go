@@ -0,0 +1,36 @@
+package repository_test
+
+import (
+	"7-solutions/model"
+	"7-solutions/repository"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestOAuthClientRepository_CreateAndGet(t *testing.T) {
+	mockColl := new(MockCollection)
+	repo := repository.NewOAuthClientRepositoryFromCollection(mockColl)
+
+	client := &model.OAuthClient{ID: "app", Name: "App", RedirectURIs: []string{"https://app.example.com/callback"}}
+	mockColl.On("InsertOne", mock.Anything, mock.AnythingOfType("*model.OAuthClient")).Return(&mongo.InsertOneResult{}, nil)
+	created, err := repo.Create(context.Background(), client)
+	require.NoError(t, err)
+	assert.False(t, created.CreatedAt.IsZero())
+
+	raw, err := bson.Marshal(created)
+	require.NoError(t, err)
+	mockColl.On("FindOne", mock.Anything, bson.M{"_id": "app"}).Return(bson.Raw(raw))
+	found, err := repo.GetByID(context.Background(), "app")
+	require.NoError(t, err)
+	assert.Equal(t, created.RedirectURIs, found.RedirectURIs)
+	assert.True(t, found.Public())
+	assert.True(t, found.AllowsRedirect("https://app.example.com/callback"))
+	assert.False(t, found.AllowsRedirect("https://app.example.com/callback/evil"))
+	mockColl.AssertExpectations(t)
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/model"
+	"context"
+	"time"
+)
+
+var ErrTokenNotFound = apperr.New(apperr.ErrNotFound, "token not found")
+
+type RefreshTokenStore interface {
+	SaveRefreshToken(ctx context.Context, token *model.RefreshToken) error
+	GetRefreshToken(ctx context.Context, hash string) (*model.RefreshToken, error)
+	// MarkRefreshTokenUsed flags an unused token as rotated. It reports false
+	// when the token was already used, so concurrent refreshes cannot both succeed.
+	MarkRefreshTokenUsed(ctx context.Context, hash string) (bool, error)
+	RevokeRefreshFamily(ctx context.Context, familyID string) error
+	// RevokeUserRefreshTokens deletes every refresh token of the user except
+	// those in family exceptFamilyID, when it is not empty.
+	RevokeUserRefreshTokens(ctx context.Context, userID, exceptFamilyID string) error
+}
+
+// RevocationStore tracks access tokens that must be rejected before they expire.
+// Single tokens are revoked by jti; RevokeUserTokens revokes every token of a
+// user issued up to the given time, except those of session exceptSessionID
+// when it is not empty.
+type RevocationStore interface {
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeUserTokens(ctx context.Context, userID string, issuedBefore time.Time, exceptSessionID string) error
+	IsRevoked(ctx context.Context, jti, userID, sessionID string, issuedAt time.Time) (bool, error)
+}
+
+// AttemptStore keeps counters that reset once their window has elapsed since
+// the first increment, and blocks that keep a key closed until a given time.
+type AttemptStore interface {
+	IncrementAttempts(ctx context.Context, key string, window time.Duration) (int64, error)
+	GetAttempts(ctx context.Context, key string) (int64, error)
+	// BlockAttempts closes key until the given time.
+	BlockAttempts(ctx context.Context, key string, until time.Time) error
+	// AttemptsBlockedUntil returns when the block on key ends, or the zero
+	// time when key is not blocked.
+	AttemptsBlockedUntil(ctx context.Context, key string) (time.Time, error)
+	// ResetAttempts clears both the counter and the block of key.
+	ResetAttempts(ctx context.Context, key string) error
+}
+
+// OneTimeTokenStore holds hashed single-use tokens.
+type OneTimeTokenStore interface {
+	SaveOneTimeToken(ctx context.Context, token *model.OneTimeToken) error
+	// ConsumeOneTimeToken deletes and returns an unexpired token of purpose, so
+	// each token is redeemed at most once.
+	ConsumeOneTimeToken(ctx context.Context, purpose, hash string) (*model.OneTimeToken, error)
+	DeleteUserOneTimeTokens(ctx context.Context, purpose, userID string) error
+}
+
+// TokenStore is the storage behind sessions: refresh tokens, revoked access
+// tokens, login attempt counters and one-time tokens.
+type TokenStore interface {
+	RefreshTokenStore
+	RevocationStore
+	AttemptStore
+	OneTimeTokenStore
+}
+
+// userRevocation is the cutoff recorded by RevokeUserTokens.
+type userRevocation struct {
+	Before        time.Time `bson:"revoked_before"`
+	ExceptSession string    `bson:"except_session,omitempty"`
+}
+
+func (r userRevocation) revokes(sessionID string, issuedAt time.Time) bool {
+	if r.ExceptSession != "" && r.ExceptSession == sessionID {
+		return false
+	}
+	return issuedBefore(issuedAt, r.Before)
+}
+
+// issuedBefore compares at second precision because iat carries no fraction.
+func issuedBefore(issuedAt, cutoff time.Time) bool {
+	return issuedAt.Unix() <= cutoff.Unix()
+}
@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"7-solutions/model"
+	"context"
+	"sync"
+	"time"
+)
+
+type attemptCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+type MemoryTokenStore struct {
+	mu            sync.Mutex
+	refreshTokens map[string]model.RefreshToken
+	revokedTokens map[string]time.Time
+	revokedUsers  map[string]userRevocation
+	attempts      map[string]attemptCounter
+	blocks        map[string]time.Time
+	oneTimeTokens map[string]model.OneTimeToken
+}
+
+func NewMemoryTokenStore() TokenStore {
+	return &MemoryTokenStore{
+		refreshTokens: make(map[string]model.RefreshToken),
+		revokedTokens: make(map[string]time.Time),
+		revokedUsers:  make(map[string]userRevocation),
+		attempts:      make(map[string]attemptCounter),
+		blocks:        make(map[string]time.Time),
+		oneTimeTokens: make(map[string]model.OneTimeToken),
+	}
+}
+
+func (s *MemoryTokenStore) SaveRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, t := range s.refreshTokens {
+		if now.After(t.ExpiresAt) {
+			delete(s.refreshTokens, hash)
+		}
+	}
+	token.CreatedAt = now
+	s.refreshTokens[token.TokenHash] = *token
+	return nil
+}
+
+func (s *MemoryTokenStore) GetRefreshToken(ctx context.Context, hash string) (*model.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshTokens[hash]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	return &token, nil
+}
+
+func (s *MemoryTokenStore) MarkRefreshTokenUsed(ctx context.Context, hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshTokens[hash]
+	if !ok || token.Used {
+		return false, nil
+	}
+	token.Used = true
+	s.refreshTokens[hash] = token
+	return true, nil
+}
+
+func (s *MemoryTokenStore) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, token := range s.refreshTokens {
+		if token.FamilyID == familyID {
+			delete(s.refreshTokens, hash)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryTokenStore) RevokeUserRefreshTokens(ctx context.Context, userID, exceptFamilyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, token := range s.refreshTokens {
+		if token.UserID == userID && (exceptFamilyID == "" || token.FamilyID != exceptFamilyID) {
+			delete(s.refreshTokens, hash)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryTokenStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, exp := range s.revokedTokens {
+		if now.After(exp) {
+			delete(s.revokedTokens, id)
+		}
+	}
+	s.revokedTokens[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryTokenStore) RevokeUserTokens(ctx context.Context, userID string, issuedBefore time.Time, exceptSessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokedUsers[userID] = userRevocation{Before: issuedBefore, ExceptSession: exceptSessionID}
+	return nil
+}
+
+func (s *MemoryTokenStore) IsRevoked(ctx context.Context, jti, userID, sessionID string, issuedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if exp, ok := s.revokedTokens[jti]; ok && time.Now().Before(exp) {
+		return true, nil
+	}
+	if revocation, ok := s.revokedUsers[userID]; ok && revocation.revokes(sessionID, issuedAt) {
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *MemoryTokenStore) IncrementAttempts(ctx context.Context, key string, window time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	counter, ok := s.attempts[key]
+	if !ok || now.After(counter.expiresAt) {
+		counter = attemptCounter{expiresAt: now.Add(window)}
+	}
+	counter.count++
+	s.attempts[key] = counter
+	return counter.count, nil
+}
+
+func (s *MemoryTokenStore) GetAttempts(ctx context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, ok := s.attempts[key]
+	if !ok || time.Now().After(counter.expiresAt) {
+		return 0, nil
+	}
+	return counter.count, nil
+}
+
+func (s *MemoryTokenStore) BlockAttempts(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks[key] = until
+	return nil
+}
+
+func (s *MemoryTokenStore) AttemptsBlockedUntil(ctx context.Context, key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.blocks[key]
+	if !ok || !time.Now().Before(until) {
+		delete(s.blocks, key)
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+func (s *MemoryTokenStore) ResetAttempts(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	delete(s.blocks, key)
+	return nil
+}
+
+func (s *MemoryTokenStore) SaveOneTimeToken(ctx context.Context, token *model.OneTimeToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, t := range s.oneTimeTokens {
+		if now.After(t.ExpiresAt) {
+			delete(s.oneTimeTokens, hash)
+		}
+	}
+	token.CreatedAt = now
+	s.oneTimeTokens[token.TokenHash] = *token
+	return nil
+}
+
+func (s *MemoryTokenStore) ConsumeOneTimeToken(ctx context.Context, purpose, hash string) (*model.OneTimeToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.oneTimeTokens[hash]
+	if !ok || token.Purpose != purpose {
+		return nil, ErrTokenNotFound
+	}
+	delete(s.oneTimeTokens, hash)
+	if time.Now().After(token.ExpiresAt) {
+		return nil, ErrTokenNotFound
+	}
+	return &token, nil
+}
+
+func (s *MemoryTokenStore) DeleteUserOneTimeTokens(ctx context.Context, purpose, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, token := range s.oneTimeTokens {
+		if token.Purpose == purpose && token.UserID == userID {
+			delete(s.oneTimeTokens, hash)
+		}
+	}
+	return nil
+}
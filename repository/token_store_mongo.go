@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"7-solutions/model"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoTokenStore struct {
+	refreshTokens CollectionInterface
+	revokedTokens CollectionInterface
+	revokedUsers  CollectionInterface
+	attempts      CollectionInterface
+	oneTimeTokens CollectionInterface
+}
+
+// NewMongoTokenStore uses TTL indexes on expires_at so MongoDB purges expired
+// refresh tokens, revocations, attempt counters and one-time tokens on its own.
+func NewMongoTokenStore(ctx context.Context, db *mongo.Database) (TokenStore, error) {
+	names := []string{"refresh_tokens", "revoked_tokens", "login_attempts", "one_time_tokens"}
+	for _, name := range names {
+		_, err := db.Collection(name).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	refreshTokens := db.Collection("refresh_tokens")
+	for _, key := range []string{"token_hash", "family_id", "user_id"} {
+		_, err := refreshTokens.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: key, Value: 1}},
+			Options: options.Index().SetUnique(key == "token_hash"),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	oneTimeTokens := db.Collection("one_time_tokens")
+	_, err := oneTimeTokens.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "purpose", Value: 1}, {Key: "user_id", Value: 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoTokenStore{
+		refreshTokens: refreshTokens,
+		revokedTokens: db.Collection("revoked_tokens"),
+		revokedUsers:  db.Collection("user_revocations"),
+		attempts:      db.Collection("login_attempts"),
+		oneTimeTokens: oneTimeTokens,
+	}, nil
+}
+
+func NewMongoTokenStoreFromCollections(refreshTokens, revokedTokens, revokedUsers, attempts, oneTimeTokens CollectionInterface) TokenStore {
+	return &MongoTokenStore{
+		refreshTokens: refreshTokens,
+		revokedTokens: revokedTokens,
+		revokedUsers:  revokedUsers,
+		attempts:      attempts,
+		oneTimeTokens: oneTimeTokens,
+	}
+}
+
+func (s *MongoTokenStore) SaveRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	token.CreatedAt = time.Now()
+	_, err := s.refreshTokens.InsertOne(ctx, token)
+	return err
+}
+
+func (s *MongoTokenStore) GetRefreshToken(ctx context.Context, hash string) (*model.RefreshToken, error) {
+	var token model.RefreshToken
+	err := s.refreshTokens.FindOne(ctx, bson.M{"token_hash": hash}).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *MongoTokenStore) MarkRefreshTokenUsed(ctx context.Context, hash string) (bool, error) {
+	res, err := s.refreshTokens.UpdateOne(ctx, bson.M{"token_hash": hash, "used": false}, bson.M{"$set": bson.M{"used": true}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (s *MongoTokenStore) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	_, err := s.refreshTokens.DeleteMany(ctx, bson.M{"family_id": familyID})
+	return err
+}
+
+func (s *MongoTokenStore) RevokeUserRefreshTokens(ctx context.Context, userID, exceptFamilyID string) error {
+	filter := bson.M{"user_id": userID}
+	if exceptFamilyID != "" {
+		filter["family_id"] = bson.M{"$ne": exceptFamilyID}
+	}
+	_, err := s.refreshTokens.DeleteMany(ctx, filter)
+	return err
+}
+
+func (s *MongoTokenStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := s.revokedTokens.UpdateOne(ctx,
+		bson.M{"_id": jti},
+		bson.M{"$set": bson.M{"expires_at": expiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (s *MongoTokenStore) RevokeUserTokens(ctx context.Context, userID string, issuedBefore time.Time, exceptSessionID string) error {
+	_, err := s.revokedUsers.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"revoked_before": issuedBefore, "except_session": exceptSessionID}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (s *MongoTokenStore) IsRevoked(ctx context.Context, jti, userID, sessionID string, issuedAt time.Time) (bool, error) {
+	var token struct {
+		ExpiresAt time.Time `bson:"expires_at"`
+	}
+	err := s.revokedTokens.FindOne(ctx, bson.M{"_id": jti}).Decode(&token)
+	if err == nil && time.Now().Before(token.ExpiresAt) {
+		return true, nil
+	}
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return false, err
+	}
+
+	var revocation userRevocation
+	err = s.revokedUsers.FindOne(ctx, bson.M{"_id": userID}).Decode(&revocation)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return revocation.revokes(sessionID, issuedAt), nil
+}
+
+func (s *MongoTokenStore) IncrementAttempts(ctx context.Context, key string, window time.Duration) (int64, error) {
+	now := time.Now()
+	// The TTL monitor only runs once a minute, so drop a lapsed window ourselves.
+	_, err := s.attempts.DeleteOne(ctx, bson.M{"_id": key, "expires_at": bson.M{"$lte": now}})
+	if err != nil {
+		return 0, err
+	}
+
+	var counter struct {
+		Count int64 `bson:"count"`
+	}
+	err = s.attempts.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		bson.M{
+			"$inc":         bson.M{"count": 1},
+			"$setOnInsert": bson.M{"expires_at": now.Add(window)},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	return counter.Count, err
+}
+
+func (s *MongoTokenStore) GetAttempts(ctx context.Context, key string) (int64, error) {
+	var counter struct {
+		Count int64 `bson:"count"`
+	}
+	err := s.attempts.FindOne(ctx, bson.M{"_id": key, "expires_at": bson.M{"$gt": time.Now()}}).Decode(&counter)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return counter.Count, err
+}
+
+// Blocks share the attempts collection under a "block:" prefixed id, so the
+// TTL index removes them once they end.
+func attemptsBlockID(key string) string { return "block:" + key }
+
+func (s *MongoTokenStore) BlockAttempts(ctx context.Context, key string, until time.Time) error {
+	_, err := s.attempts.UpdateOne(ctx,
+		bson.M{"_id": attemptsBlockID(key)},
+		bson.M{"$set": bson.M{"expires_at": until}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (s *MongoTokenStore) AttemptsBlockedUntil(ctx context.Context, key string) (time.Time, error) {
+	var block struct {
+		ExpiresAt time.Time `bson:"expires_at"`
+	}
+	err := s.attempts.FindOne(ctx, bson.M{"_id": attemptsBlockID(key), "expires_at": bson.M{"$gt": time.Now()}}).Decode(&block)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return time.Time{}, nil
+	}
+	return block.ExpiresAt, err
+}
+
+func (s *MongoTokenStore) ResetAttempts(ctx context.Context, key string) error {
+	_, err := s.attempts.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": bson.A{key, attemptsBlockID(key)}}})
+	return err
+}
+
+func (s *MongoTokenStore) SaveOneTimeToken(ctx context.Context, token *model.OneTimeToken) error {
+	token.CreatedAt = time.Now()
+	_, err := s.oneTimeTokens.InsertOne(ctx, token)
+	return err
+}
+
+func (s *MongoTokenStore) ConsumeOneTimeToken(ctx context.Context, purpose, hash string) (*model.OneTimeToken, error) {
+	var token model.OneTimeToken
+	err := s.oneTimeTokens.FindOneAndDelete(ctx, bson.M{
+		"_id":        hash,
+		"purpose":    purpose,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *MongoTokenStore) DeleteUserOneTimeTokens(ctx context.Context, purpose, userID string) error {
+	_, err := s.oneTimeTokens.DeleteMany(ctx, bson.M{"purpose": purpose, "user_id": userID})
+	return err
+}
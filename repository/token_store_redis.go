@@ -0,0 +1,318 @@
+package repository
+
+import (
+	"7-solutions/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// markUsedScript flips "used" only when the token exists and is still unused,
+// making rotation atomic across concurrent refreshes.
+var markUsedScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "used") == "0" then
+	redis.call("HSET", KEYS[1], "used", "1")
+	return 1
+end
+return 0
+`)
+
+// consumeScript reads and deletes a one-time token in one step so it can only
+// be redeemed once.
+var consumeScript = redis.NewScript(`
+local fields = redis.call("HGETALL", KEYS[1])
+redis.call("DEL", KEYS[1])
+return fields
+`)
+
+type RedisTokenStore struct {
+	client redis.UniversalClient
+}
+
+func NewRedisTokenStore(client redis.UniversalClient) TokenStore {
+	return &RedisTokenStore{client: client}
+}
+
+func refreshTokenKey(hash string) string      { return "refresh_token:" + hash }
+func refreshFamilyKey(familyID string) string { return "refresh_family:" + familyID }
+func refreshUserKey(userID string) string     { return "refresh_user:" + userID }
+func revokedTokenKey(jti string) string       { return "revoked_token:" + jti }
+func revokedUserKey(userID string) string     { return "revoked_user:" + userID }
+func attemptsKey(key string) string           { return "login_attempts:" + key }
+func attemptsBlockKey(key string) string      { return "login_block:" + key }
+
+func oneTimeTokenKey(purpose, hash string) string {
+	return "one_time_token:" + purpose + ":" + hash
+}
+
+func oneTimeUserKey(purpose, userID string) string {
+	return "one_time_user:" + purpose + ":" + userID
+}
+
+func (s *RedisTokenStore) SaveRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	token.CreatedAt = time.Now()
+	ttl := time.Until(token.ExpiresAt)
+	key := refreshTokenKey(token.TokenHash)
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"family_id", token.FamilyID,
+			"user_id", token.UserID,
+			"used", boolToString(token.Used),
+			"expires_at", token.ExpiresAt.Unix(),
+			"created_at", token.CreatedAt.Unix(),
+		)
+		pipe.Expire(ctx, key, ttl)
+		pipe.SAdd(ctx, refreshFamilyKey(token.FamilyID), token.TokenHash)
+		pipe.Expire(ctx, refreshFamilyKey(token.FamilyID), ttl)
+		pipe.SAdd(ctx, refreshUserKey(token.UserID), token.TokenHash)
+		pipe.Expire(ctx, refreshUserKey(token.UserID), ttl)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisTokenStore) GetRefreshToken(ctx context.Context, hash string) (*model.RefreshToken, error) {
+	fields, err := s.client.HGetAll(ctx, refreshTokenKey(hash)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrTokenNotFound
+	}
+
+	expiresAt, _ := strconv.ParseInt(fields["expires_at"], 10, 64)
+	createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+	return &model.RefreshToken{
+		TokenHash: hash,
+		FamilyID:  fields["family_id"],
+		UserID:    fields["user_id"],
+		Used:      fields["used"] == "1",
+		ExpiresAt: time.Unix(expiresAt, 0),
+		CreatedAt: time.Unix(createdAt, 0),
+	}, nil
+}
+
+func (s *RedisTokenStore) MarkRefreshTokenUsed(ctx context.Context, hash string) (bool, error) {
+	n, err := markUsedScript.Run(ctx, s.client, []string{refreshTokenKey(hash)}).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (s *RedisTokenStore) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	return s.deleteTokenSet(ctx, refreshFamilyKey(familyID))
+}
+
+func (s *RedisTokenStore) RevokeUserRefreshTokens(ctx context.Context, userID, exceptFamilyID string) error {
+	if exceptFamilyID == "" {
+		return s.deleteTokenSet(ctx, refreshUserKey(userID))
+	}
+
+	setKey := refreshUserKey(userID)
+	hashes, err := s.client.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		familyID, err := s.client.HGet(ctx, refreshTokenKey(hash), "family_id").Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if familyID == exceptFamilyID {
+			continue
+		}
+		if err := s.client.Del(ctx, refreshTokenKey(hash)).Err(); err != nil {
+			return err
+		}
+		if err := s.client.SRem(ctx, setKey, hash).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RedisTokenStore) deleteTokenSet(ctx context.Context, setKey string) error {
+	hashes, err := s.client.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{setKey}
+	for _, hash := range hashes {
+		keys = append(keys, refreshTokenKey(hash))
+	}
+	return s.client.Del(ctx, keys...).Err()
+}
+
+func (s *RedisTokenStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(ctx, revokedTokenKey(jti), 1, ttl).Err()
+}
+
+// RevokeUserTokens stores "<unix cutoff>" or "<unix cutoff>:<session id>".
+func (s *RedisTokenStore) RevokeUserTokens(ctx context.Context, userID string, issuedBefore time.Time, exceptSessionID string) error {
+	value := strconv.FormatInt(issuedBefore.Unix(), 10)
+	if exceptSessionID != "" {
+		value += ":" + exceptSessionID
+	}
+	return s.client.Set(ctx, revokedUserKey(userID), value, 0).Err()
+}
+
+func (s *RedisTokenStore) IsRevoked(ctx context.Context, jti, userID, sessionID string, issuedAt time.Time) (bool, error) {
+	n, err := s.client.Exists(ctx, revokedTokenKey(jti)).Result()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return true, nil
+	}
+
+	value, err := s.client.Get(ctx, revokedUserKey(userID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	cutoff, exceptSession, _ := strings.Cut(value, ":")
+	unix, err := strconv.ParseInt(cutoff, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	return userRevocation{Before: time.Unix(unix, 0), ExceptSession: exceptSession}.revokes(sessionID, issuedAt), nil
+}
+
+func (s *RedisTokenStore) IncrementAttempts(ctx context.Context, key string, window time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, attemptsKey(key))
+		pipe.ExpireNX(ctx, attemptsKey(key), window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (s *RedisTokenStore) GetAttempts(ctx context.Context, key string) (int64, error) {
+	n, err := s.client.Get(ctx, attemptsKey(key)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (s *RedisTokenStore) BlockAttempts(ctx context.Context, key string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(ctx, attemptsBlockKey(key), until.UnixMilli(), ttl).Err()
+}
+
+func (s *RedisTokenStore) AttemptsBlockedUntil(ctx context.Context, key string) (time.Time, error) {
+	ms, err := s.client.Get(ctx, attemptsBlockKey(key)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(ms), nil
+}
+
+func (s *RedisTokenStore) ResetAttempts(ctx context.Context, key string) error {
+	return s.client.Del(ctx, attemptsKey(key), attemptsBlockKey(key)).Err()
+}
+
+func (s *RedisTokenStore) SaveOneTimeToken(ctx context.Context, token *model.OneTimeToken) error {
+	token.CreatedAt = time.Now()
+	ttl := time.Until(token.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	key := oneTimeTokenKey(token.Purpose, token.TokenHash)
+	userKey := oneTimeUserKey(token.Purpose, token.UserID)
+	data, err := json.Marshal(token.Data)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"user_id", token.UserID,
+			"expires_at", token.ExpiresAt.Unix(),
+			"created_at", token.CreatedAt.Unix(),
+			"data", data,
+		)
+		pipe.Expire(ctx, key, ttl)
+		pipe.SAdd(ctx, userKey, token.TokenHash)
+		pipe.Expire(ctx, userKey, ttl)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisTokenStore) ConsumeOneTimeToken(ctx context.Context, purpose, hash string) (*model.OneTimeToken, error) {
+	values, err := consumeScript.Run(ctx, s.client, []string{oneTimeTokenKey(purpose, hash)}).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, ErrTokenNotFound
+	}
+
+	fields := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		fields[values[i]] = values[i+1]
+	}
+	expiresAt, _ := strconv.ParseInt(fields["expires_at"], 10, 64)
+	createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+	var data map[string]string
+	if raw := fields["data"]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &data); err != nil {
+			return nil, err
+		}
+	}
+	s.client.SRem(ctx, oneTimeUserKey(purpose, fields["user_id"]), hash)
+	return &model.OneTimeToken{
+		TokenHash: hash,
+		Purpose:   purpose,
+		UserID:    fields["user_id"],
+		ExpiresAt: time.Unix(expiresAt, 0),
+		CreatedAt: time.Unix(createdAt, 0),
+		Data:      data,
+	}, nil
+}
+
+func (s *RedisTokenStore) DeleteUserOneTimeTokens(ctx context.Context, purpose, userID string) error {
+	userKey := oneTimeUserKey(purpose, userID)
+	hashes, err := s.client.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{userKey}
+	for _, hash := range hashes {
+		keys = append(keys, oneTimeTokenKey(purpose, hash))
+	}
+	return s.client.Del(ctx, keys...).Err()
+}
+
+func boolToString(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,338 @@
+package repository_test
+
+import (
+	"7-solutions/model"
+	"7-solutions/repository"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func newRedisTokenStore(t *testing.T) repository.TokenStore {
+	srv := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return repository.NewRedisTokenStore(client)
+}
+
+func tokenStores(t *testing.T) map[string]repository.TokenStore {
+	return map[string]repository.TokenStore{
+		"memory": repository.NewMemoryTokenStore(),
+		"redis":  newRedisTokenStore(t),
+	}
+}
+
+func TestTokenStore_RefreshTokens(t *testing.T) {
+	for name, store := range tokenStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			expiresAt := time.Now().Add(time.Hour)
+			for _, hash := range []string{"a", "b"} {
+				require.NoError(t, store.SaveRefreshToken(ctx, &model.RefreshToken{
+					TokenHash: hash,
+					FamilyID:  "family-" + hash,
+					UserID:    "user-1",
+					ExpiresAt: expiresAt,
+				}))
+			}
+
+			token, err := store.GetRefreshToken(ctx, "a")
+			require.NoError(t, err)
+			assert.Equal(t, "family-a", token.FamilyID)
+			assert.Equal(t, "user-1", token.UserID)
+			assert.False(t, token.Used)
+			assert.Equal(t, expiresAt.Unix(), token.ExpiresAt.Unix())
+
+			ok, err := store.MarkRefreshTokenUsed(ctx, "a")
+			require.NoError(t, err)
+			assert.True(t, ok)
+			ok, err = store.MarkRefreshTokenUsed(ctx, "a")
+			require.NoError(t, err)
+			assert.False(t, ok)
+			ok, err = store.MarkRefreshTokenUsed(ctx, "missing")
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			require.NoError(t, store.RevokeRefreshFamily(ctx, "family-a"))
+			_, err = store.GetRefreshToken(ctx, "a")
+			assert.ErrorIs(t, err, repository.ErrTokenNotFound)
+
+			require.NoError(t, store.RevokeUserRefreshTokens(ctx, "user-1", ""))
+			_, err = store.GetRefreshToken(ctx, "b")
+			assert.ErrorIs(t, err, repository.ErrTokenNotFound)
+		})
+	}
+}
+
+func TestTokenStore_MarkRefreshTokenUsedOnce(t *testing.T) {
+	for name, store := range tokenStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			require.NoError(t, store.SaveRefreshToken(ctx, &model.RefreshToken{
+				TokenHash: "a",
+				FamilyID:  "family-a",
+				UserID:    "user-1",
+				ExpiresAt: time.Now().Add(time.Hour),
+			}))
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			wins := 0
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					ok, err := store.MarkRefreshTokenUsed(ctx, "a")
+					assert.NoError(t, err)
+					if ok {
+						mu.Lock()
+						wins++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			assert.Equal(t, 1, wins)
+		})
+	}
+}
+
+func TestTokenStore_Revocation(t *testing.T) {
+	for name, store := range tokenStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+
+			require.NoError(t, store.RevokeToken(ctx, "jti-1", now.Add(time.Minute)))
+			revoked, err := store.IsRevoked(ctx, "jti-1", "user-1", "session-1", now)
+			require.NoError(t, err)
+			assert.True(t, revoked)
+
+			revoked, err = store.IsRevoked(ctx, "jti-2", "user-1", "session-1", now)
+			require.NoError(t, err)
+			assert.False(t, revoked)
+
+			require.NoError(t, store.RevokeUserTokens(ctx, "user-1", now, ""))
+			revoked, err = store.IsRevoked(ctx, "jti-2", "user-1", "session-1", now.Add(-time.Hour))
+			require.NoError(t, err)
+			assert.True(t, revoked)
+
+			revoked, err = store.IsRevoked(ctx, "jti-3", "user-1", "session-1", now.Add(time.Second))
+			require.NoError(t, err)
+			assert.False(t, revoked)
+
+			revoked, err = store.IsRevoked(ctx, "jti-4", "user-2", "session-1", now.Add(-time.Hour))
+			require.NoError(t, err)
+			assert.False(t, revoked)
+		})
+	}
+}
+
+func TestTokenStore_RevokeUserExceptSession(t *testing.T) {
+	for name, store := range tokenStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			for _, hash := range []string{"current", "other"} {
+				require.NoError(t, store.SaveRefreshToken(ctx, &model.RefreshToken{
+					TokenHash: hash,
+					FamilyID:  "session-" + hash,
+					UserID:    "user-1",
+					ExpiresAt: now.Add(time.Hour),
+				}))
+			}
+
+			require.NoError(t, store.RevokeUserTokens(ctx, "user-1", now, "session-current"))
+			revoked, err := store.IsRevoked(ctx, "jti-1", "user-1", "session-current", now)
+			require.NoError(t, err)
+			assert.False(t, revoked)
+			revoked, err = store.IsRevoked(ctx, "jti-2", "user-1", "session-other", now)
+			require.NoError(t, err)
+			assert.True(t, revoked)
+
+			require.NoError(t, store.RevokeUserRefreshTokens(ctx, "user-1", "session-current"))
+			_, err = store.GetRefreshToken(ctx, "current")
+			assert.NoError(t, err)
+			_, err = store.GetRefreshToken(ctx, "other")
+			assert.ErrorIs(t, err, repository.ErrTokenNotFound)
+		})
+	}
+}
+
+func TestTokenStore_Attempts(t *testing.T) {
+	for name, store := range tokenStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			for want := int64(1); want <= 3; want++ {
+				n, err := store.IncrementAttempts(ctx, "email:a@example.com", time.Minute)
+				require.NoError(t, err)
+				assert.Equal(t, want, n)
+			}
+
+			n, err := store.GetAttempts(ctx, "email:a@example.com")
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), n)
+
+			require.NoError(t, store.ResetAttempts(ctx, "email:a@example.com"))
+			n, err = store.GetAttempts(ctx, "email:a@example.com")
+			require.NoError(t, err)
+			assert.Equal(t, int64(0), n)
+		})
+	}
+}
+
+func TestTokenStore_AttemptBlocks(t *testing.T) {
+	for name, store := range tokenStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			until, err := store.AttemptsBlockedUntil(ctx, "email:a@example.com")
+			require.NoError(t, err)
+			assert.True(t, until.IsZero())
+
+			end := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+			require.NoError(t, store.BlockAttempts(ctx, "email:a@example.com", end))
+			_, err = store.IncrementAttempts(ctx, "email:a@example.com", time.Minute)
+			require.NoError(t, err)
+			until, err = store.AttemptsBlockedUntil(ctx, "email:a@example.com")
+			require.NoError(t, err)
+			assert.True(t, end.Equal(until), "blocked until %v, want %v", until, end)
+
+			require.NoError(t, store.ResetAttempts(ctx, "email:a@example.com"))
+			until, err = store.AttemptsBlockedUntil(ctx, "email:a@example.com")
+			require.NoError(t, err)
+			assert.True(t, until.IsZero())
+			n, err := store.GetAttempts(ctx, "email:a@example.com")
+			require.NoError(t, err)
+			assert.Equal(t, int64(0), n)
+		})
+	}
+}
+
+func TestTokenStore_OneTimeTokens(t *testing.T) {
+	for name, store := range tokenStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			save := func(hash, purpose string, ttl time.Duration) {
+				require.NoError(t, store.SaveOneTimeToken(ctx, &model.OneTimeToken{
+					TokenHash: hash,
+					Purpose:   purpose,
+					UserID:    "user-1",
+					ExpiresAt: time.Now().Add(ttl),
+				}))
+			}
+			save("a", "password_reset", time.Hour)
+			save("b", "password_reset", time.Hour)
+			require.NoError(t, store.SaveOneTimeToken(ctx, &model.OneTimeToken{
+				TokenHash: "c",
+				Purpose:   "other",
+				UserID:    "user-1",
+				ExpiresAt: time.Now().Add(time.Hour),
+				Data:      map[string]string{"client_id": "app"},
+			}))
+			save("expired", "password_reset", -time.Minute)
+
+			_, err := store.ConsumeOneTimeToken(ctx, "other", "a")
+			assert.ErrorIs(t, err, repository.ErrTokenNotFound)
+
+			token, err := store.ConsumeOneTimeToken(ctx, "password_reset", "a")
+			require.NoError(t, err)
+			assert.Equal(t, "user-1", token.UserID)
+			_, err = store.ConsumeOneTimeToken(ctx, "password_reset", "a")
+			assert.ErrorIs(t, err, repository.ErrTokenNotFound)
+
+			_, err = store.ConsumeOneTimeToken(ctx, "password_reset", "expired")
+			assert.ErrorIs(t, err, repository.ErrTokenNotFound)
+
+			require.NoError(t, store.DeleteUserOneTimeTokens(ctx, "password_reset", "user-1"))
+			_, err = store.ConsumeOneTimeToken(ctx, "password_reset", "b")
+			assert.ErrorIs(t, err, repository.ErrTokenNotFound)
+			token, err = store.ConsumeOneTimeToken(ctx, "other", "c")
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{"client_id": "app"}, token.Data)
+		})
+	}
+}
+
+func TestRedisTokenStore_AttemptWindowExpires(t *testing.T) {
+	srv := miniredis.RunT(t)
+	store := repository.NewRedisTokenStore(redis.NewClient(&redis.Options{Addr: srv.Addr()}))
+	ctx := context.Background()
+
+	_, err := store.IncrementAttempts(ctx, "ip:127.0.0.1", time.Minute)
+	require.NoError(t, err)
+	_, err = store.IncrementAttempts(ctx, "ip:127.0.0.1", time.Minute)
+	require.NoError(t, err)
+
+	srv.FastForward(time.Minute + time.Second)
+
+	n, err := store.GetAttempts(ctx, "ip:127.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+}
+
+func TestMongoTokenStore_IsRevoked(t *testing.T) {
+	revokedTokens := new(MockCollection)
+	revokedUsers := new(MockCollection)
+	store := repository.NewMongoTokenStoreFromCollections(new(MockCollection), revokedTokens, revokedUsers, new(MockCollection), new(MockCollection))
+
+	raw, _ := bson.Marshal(bson.M{"_id": "jti-1", "expires_at": time.Now().Add(time.Minute)})
+	revokedTokens.On("FindOne", mock.Anything, bson.M{"_id": "jti-1"}).Return(bson.Raw(raw))
+
+	revoked, err := store.IsRevoked(context.Background(), "jti-1", "user-1", "session-1", time.Now())
+	require.NoError(t, err)
+	assert.True(t, revoked)
+	revokedUsers.AssertNotCalled(t, "FindOne", mock.Anything, mock.Anything)
+}
+
+func TestMongoTokenStore_MarkRefreshTokenUsed(t *testing.T) {
+	refreshTokens := new(MockCollection)
+	store := repository.NewMongoTokenStoreFromCollections(refreshTokens, new(MockCollection), new(MockCollection), new(MockCollection), new(MockCollection))
+
+	refreshTokens.On("UpdateOne", mock.Anything, bson.M{"token_hash": "a", "used": false}, mock.Anything).
+		Return(&mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil)
+
+	ok, err := store.MarkRefreshTokenUsed(context.Background(), "a")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	refreshTokens.AssertExpectations(t)
+}
+
+func TestMongoTokenStore_IncrementAttempts(t *testing.T) {
+	attempts := new(MockCollection)
+	store := repository.NewMongoTokenStoreFromCollections(new(MockCollection), new(MockCollection), new(MockCollection), attempts, new(MockCollection))
+
+	raw, _ := bson.Marshal(bson.M{"_id": "email:a@example.com", "count": int64(2)})
+	attempts.On("DeleteOne", mock.Anything, mock.Anything).Return(&mongo.DeleteResult{}, nil)
+	attempts.On("FindOneAndUpdate", mock.Anything, bson.M{"_id": "email:a@example.com"}, mock.Anything).Return(bson.Raw(raw))
+
+	n, err := store.IncrementAttempts(context.Background(), "email:a@example.com", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	attempts.AssertExpectations(t)
+}
+
+func TestMongoTokenStore_ConsumeOneTimeToken(t *testing.T) {
+	oneTimeTokens := new(MockCollection)
+	store := repository.NewMongoTokenStoreFromCollections(new(MockCollection), new(MockCollection), new(MockCollection), new(MockCollection), oneTimeTokens)
+
+	raw, _ := bson.Marshal(bson.M{"_id": "a", "purpose": "password_reset", "user_id": "user-1", "expires_at": time.Now().Add(time.Hour)})
+	oneTimeTokens.On("FindOneAndDelete", mock.Anything, mock.MatchedBy(func(filter bson.M) bool {
+		return filter["_id"] == "a" && filter["purpose"] == "password_reset"
+	})).Return(bson.Raw(raw))
+
+	token, err := store.ConsumeOneTimeToken(context.Background(), "password_reset", "a")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", token.UserID)
+	oneTimeTokens.AssertExpectations(t)
+}
@@ -1,9 +1,13 @@
 package repository
 
 import (
+	"7-solutions/apperr"
 	"7-solutions/model"
 	"context"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -12,22 +16,66 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var (
+	ErrUserNotFound  = apperr.New(apperr.ErrNotFound, "user not found")
+	ErrInvalidUserID = apperr.New(apperr.ErrInvalidID, "invalid user id")
+	ErrEmailTaken    = apperr.New(apperr.ErrConflict, "email already registered")
+	ErrIdentityTaken = apperr.New(apperr.ErrConflict, "identity already linked to another user")
+)
+
+// Names of the unique indexes on users, as Mongo names them by default.
+// Duplicate key errors name the index they hit.
+const (
+	emailIndex    = "email_1"
+	identityIndex = "identities.provider_1_identities.subject_1"
+	nameIndex     = "name_1"
+)
+
+// userCollation compares emails and names case-insensitively. Queries on
+// email or name must use it to match, and be served by, their indexes.
+var userCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// collationMax sorts after every other character under userCollation, so
+// [p, p+collationMax) is the range of strings starting with p.
+const collationMax = "\uffff"
+
+// userSortFields maps the sort keys clients may use to document fields.
+var userSortFields = map[string]string{
+	"":           "_id",
+	"id":         "_id",
+	"name":       "name",
+	"email":      "email",
+	"created_at": "created_at",
+}
+
 type CollectionInterface interface {
 	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
 	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
 	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
 	DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult
+	FindOneAndDelete(ctx context.Context, filter interface{}, opts ...*options.FindOneAndDeleteOptions) *mongo.SingleResult
 	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
 	CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
 }
 
 type UsersRepository interface {
-	Create(ctx context.Context, user *model.User) error
+	Create(ctx context.Context, user *model.User) (*model.User, error)
 	GetByID(ctx context.Context, id string) (*model.User, error)
 	GetByEmail(ctx context.Context, email string) (*model.User, error)
 	Update(ctx context.Context, id string, user *model.User) error
+	MarkEmailVerified(ctx context.Context, id, email string) error
+	UpdatePassword(ctx context.Context, id, passwordHash string) error
+	UpdateMFA(ctx context.Context, id string, mfa model.MFA) error
+	// UseRecoveryCode removes codeHash from the user's recovery codes. It
+	// reports false when the code is not among them, so each code works once.
+	UseRecoveryCode(ctx context.Context, id, codeHash string) (bool, error)
+	// GetByIdentity returns the user linked to subject at provider.
+	GetByIdentity(ctx context.Context, provider, subject string) (*model.User, error)
+	LinkIdentity(ctx context.Context, id string, identity model.Identity) error
 	Delete(ctx context.Context, id string) error
-	List(ctx context.Context) ([]model.User, error)
+	List(ctx context.Context, query model.UserQuery) (*model.UserPage, error)
 	Count(ctx context.Context) (int64, error)
 }
 
@@ -35,81 +83,414 @@ type UserRepository struct {
 	collection CollectionInterface
 }
 
-func NewUserRepository(db *mongo.Database) UsersRepository {
-	return &UserRepository{collection: db.Collection("7-solutions")}
+// NewUserRepository ensures the unique, case-insensitive index on email, the
+// case-insensitive index on name used by listings and the unique index on
+// linked identities. It fails if existing users already share an email.
+func NewUserRepository(ctx context.Context, db *mongo.Database) (UsersRepository, error) {
+	coll := db.Collection("7-solutions")
+	_, err := coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName(emailIndex).SetUnique(true).SetCollation(userCollation),
+		},
+		{
+			Keys:    bson.D{{Key: "name", Value: 1}},
+			Options: options.Index().SetName(nameIndex).SetCollation(userCollation),
+		},
+		{
+			Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+			Options: options.Index().SetName(identityIndex).SetUnique(true).
+				SetPartialFilterExpression(bson.M{"identities": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &UserRepository{collection: coll}, nil
 }
 
 func NewUserRepositoryFromCollection(coll CollectionInterface) UsersRepository {
 	return &UserRepository{collection: coll}
 }
 
-func (r *UserRepository) Create(ctx context.Context, user *model.User) error {
-	user.CreatedAt = time.Now()
-	_, err := r.collection.InsertOne(ctx, user)
-	return err
+// Create inserts user and returns it with the assigned id and creation time.
+// CreatedAt is truncated to the millisecond precision Mongo stores, so the
+// returned user matches what a later read would return.
+func (r *UserRepository) Create(ctx context.Context, user *model.User) (*model.User, error) {
+	created := *user
+	if created.ID.IsZero() {
+		created.ID = primitive.NewObjectID()
+	}
+	created.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	_, err := r.collection.InsertOne(ctx, &created)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, duplicateKeyError(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
 }
 
 func (r *UserRepository) GetByID(ctx context.Context, id string) (*model.User, error) {
-	objID, _ := primitive.ObjectIDFromHex(id)
-	var user model.User
-	err := r.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&user)
-	return &user, err
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidUserID
+	}
+	return r.findOne(ctx, bson.M{"_id": objID})
 }
 
 func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	return r.findOne(ctx, bson.M{"email": email}, options.FindOne().SetCollation(userCollation))
+}
+
+func (r *UserRepository) findOne(ctx context.Context, filter bson.M, opts ...*options.FindOneOptions) (*model.User, error) {
 	var user model.User
-	err := r.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
-	return &user, err
+	err := r.collection.FindOne(ctx, filter, opts...).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
 }
 
 func (r *UserRepository) Update(ctx context.Context, id string, user *model.User) error {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
+		return ErrInvalidUserID
+	}
+
+	set := bson.M{}
+	if user.Name != "" {
+		set["name"] = user.Name
+	}
+	if user.Email != "" {
+		// A new address has to be verified again.
+		set["email"] = user.Email
+		set["verified"] = false
+	}
+	if len(set) == 0 {
+		n, err := r.collection.CountDocuments(ctx, bson.M{"_id": objID})
+		if err == nil && n == 0 {
+			err = ErrUserNotFound
+		}
 		return err
 	}
 
-	res, err := r.collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"name": user.Name, "email": user.Email}})
+	res, err := r.collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": set})
+	if mongo.IsDuplicateKeyError(err) {
+		return duplicateKeyError(err)
+	}
 	if err != nil {
 		return err
 	}
 	if res.MatchedCount == 0 {
-		return errors.New("user not found")
+		return ErrUserNotFound
 	}
 	return nil
 }
 
-func (r *UserRepository) Delete(ctx context.Context, id string) error {
+// MarkEmailVerified sets the verified flag, but only while the user still has
+// that email, so a verification sent to a previous address cannot verify a new
+// one.
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, id, email string) error {
 	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidUserID
+	}
+
+	res, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": objID, "email": email},
+		bson.M{"$set": bson.M{"verified": true}},
+		options.Update().SetCollation(userCollation),
+	)
 	if err != nil {
 		return err
 	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) UpdatePassword(ctx context.Context, id, passwordHash string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidUserID
+	}
+
+	res, err := r.collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"password": passwordHash}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) UpdateMFA(ctx context.Context, id string, mfa model.MFA) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidUserID
+	}
+
+	res, err := r.collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"mfa": mfa}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) UseRecoveryCode(ctx context.Context, id, codeHash string) (bool, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, ErrInvalidUserID
+	}
+
+	res, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": objID, "mfa.recovery_codes": codeHash},
+		bson.M{"$pull": bson.M{"mfa.recovery_codes": codeHash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *UserRepository) GetByIdentity(ctx context.Context, provider, subject string) (*model.User, error) {
+	return r.findOne(ctx, bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}})
+}
+
+func (r *UserRepository) LinkIdentity(ctx context.Context, id string, identity model.Identity) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidUserID
+	}
+
+	// The unique index only stops two users sharing an identity, so the
+	// filter keeps the same user from storing it twice.
+	filter := bson.M{
+		"_id": objID,
+		"identities": bson.M{"$not": bson.M{"$elemMatch": bson.M{
+			"provider": identity.Provider,
+			"subject":  identity.Subject,
+		}}},
+	}
+	res, err := r.collection.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"identities": identity}})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrIdentityTaken
+	}
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+	// Either the user is gone or the identity is already linked to them.
+	n, err := r.collection.CountDocuments(ctx, bson.M{"_id": objID})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidUserID
+	}
 
 	res, err := r.collection.DeleteOne(ctx, bson.M{"_id": objID})
 	if err != nil {
 		return err
 	}
 	if res.DeletedCount == 0 {
-		return errors.New("user not found")
+		return ErrUserNotFound
 	}
 	return nil
 }
 
-func (r *UserRepository) List(ctx context.Context) ([]model.User, error) {
-	cursor, err := r.collection.Find(ctx, bson.M{})
+// List pages with a keyset on (sort field, _id): the cursor holds both values
+// of the last user returned, so pages stay stable while users are inserted.
+// The cursor also records the sort and filters it was issued for, and is
+// refused under any other. Names and emails sort and match case-insensitively
+// through userCollation, so prefix filters are index range scans.
+func (r *UserRepository) List(ctx context.Context, query model.UserQuery) (*model.UserPage, error) {
+	sortField, ok := userSortFields[query.SortBy]
+	if !ok {
+		return nil, apperr.Validation(apperr.FieldError{Field: "sort", Message: fmt.Sprintf("cannot sort by %q", query.SortBy)})
+	}
+	direction := 1
+	if query.Descending {
+		direction = -1
+	}
+
+	filter := userFilter(query.Filter)
+	total, err := r.collection.CountDocuments(ctx, filter, options.Count().SetCollation(userCollation))
+	if err != nil {
+		return nil, err
+	}
+
+	scope := listScope{Field: sortField, Descending: query.Descending, Filter: filterKey(query.Filter)}
+	if query.After != "" {
+		after, err := cursorFilter(query.After, scope, direction)
+		if err != nil {
+			return nil, err
+		}
+		filter = bson.M{"$and": bson.A{filter, after}}
+	}
+
+	sort := bson.D{{Key: sortField, Value: direction}}
+	if sortField != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: direction})
+	}
+	// Fetch one extra user to learn whether another page follows.
+	opts := options.Find().SetSort(sort).SetLimit(query.Limit + 1).SetCollation(userCollation)
+	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var users []model.User
-	for cursor.Next(ctx) {
-		var user model.User
-		cursor.Decode(&user)
-		users = append(users, user)
+	users := []model.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	page := &model.UserPage{Users: users, Total: total}
+	if int64(len(users)) > query.Limit {
+		page.Users = users[:query.Limit]
+		page.NextCursor, err = encodeCursor(page.Users[query.Limit-1], scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+func userFilter(f model.UserFilter) bson.M {
+	filter := bson.M{}
+	if f.NamePrefix != "" {
+		filter["name"] = prefixRange(f.NamePrefix)
+	}
+	if f.EmailPrefix != "" {
+		filter["email"] = prefixRange(f.EmailPrefix)
+	}
+
+	createdAt := bson.M{}
+	if !f.CreatedAfter.IsZero() {
+		createdAt["$gte"] = f.CreatedAfter
+	}
+	if !f.CreatedBefore.IsZero() {
+		createdAt["$lt"] = f.CreatedBefore
 	}
-	return users, nil
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+	return filter
+}
+
+func prefixRange(prefix string) bson.M {
+	return bson.M{"$gte": prefix, "$lt": prefix + collationMax}
+}
+
+// listScope is what a cursor is only valid for: the sort and the filters.
+type listScope struct {
+	Field      string `bson:"f"`
+	Descending bool   `bson:"d,omitempty"`
+	Filter     string `bson:"q,omitempty"`
+}
+
+// filterKey identifies the filters of a listing.
+func filterKey(f model.UserFilter) string {
+	if f == (model.UserFilter{}) {
+		return ""
+	}
+	return fmt.Sprintf("%q|%q|%d|%d", f.NamePrefix, f.EmailPrefix, unixNano(f.CreatedAfter), unixNano(f.CreatedBefore))
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+type listCursor struct {
+	Scope listScope          `bson:"s"`
+	Value interface{}        `bson:"v"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+func encodeCursor(last model.User, scope listScope) (string, error) {
+	c := listCursor{Scope: scope, ID: last.ID}
+	switch scope.Field {
+	case "name":
+		c.Value = last.Name
+	case "email":
+		c.Value = last.Email
+	case "created_at":
+		c.Value = last.CreatedAt
+	}
+
+	raw, err := bson.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func errInvalidCursor(msg string) error {
+	return apperr.Validation(apperr.FieldError{Field: "after", Message: msg})
+}
+
+func cursorFilter(cursor string, scope listScope, direction int) (bson.M, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor("malformed cursor")
+	}
+	var c listCursor
+	if err := bson.Unmarshal(raw, &c); err != nil || c.ID.IsZero() {
+		return nil, errInvalidCursor("malformed cursor")
+	}
+	if c.Scope.Field != scope.Field || c.Scope.Descending != scope.Descending {
+		return nil, errInvalidCursor("cursor was issued for a different sort")
+	}
+	if c.Scope.Filter != scope.Filter {
+		return nil, errInvalidCursor("cursor was issued for different filters")
+	}
+	sortField := scope.Field
+
+	op := "$gt"
+	if direction < 0 {
+		op = "$lt"
+	}
+	if sortField == "_id" {
+		return bson.M{"_id": bson.M{op: c.ID}}, nil
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{sortField: bson.M{op: c.Value}},
+		bson.M{sortField: c.Value, "_id": bson.M{op: c.ID}},
+	}}, nil
 }
 
 func (r *UserRepository) Count(ctx context.Context) (int64, error) {
 	return r.collection.CountDocuments(ctx, bson.M{})
 }
+
+// duplicateKeyError tells which unique index a duplicate key error hit.
+func duplicateKeyError(err error) error {
+	if strings.Contains(err.Error(), identityIndex) {
+		return ErrIdentityTaken
+	}
+	return ErrEmailTaken
+}
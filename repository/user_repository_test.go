@@ -1,13 +1,16 @@
 package repository_test
 
 import (
+	"7-solutions/apperr"
 	"7-solutions/model"
 	"7-solutions/repository"
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -30,6 +33,12 @@ func (m *MockCollection) FindOne(ctx context.Context, filter interface{}, opts .
 	return sr
 }
 
+func (m *MockCollection) FindOneAndDelete(ctx context.Context, filter interface{}, opts ...*options.FindOneAndDeleteOptions) *mongo.SingleResult {
+	args := m.Called(ctx, filter)
+	raw := args.Get(0).(bson.Raw)
+	return mongo.NewSingleResultFromDocument(raw, nil, nil)
+}
+
 func (m *MockCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
 	args := m.Called(ctx, filter, update)
 	return args.Get(0).(*mongo.UpdateResult), args.Error(1)
@@ -40,6 +49,17 @@ func (m *MockCollection) DeleteOne(ctx context.Context, filter interface{}, opts
 	return args.Get(0).(*mongo.DeleteResult), args.Error(1)
 }
 
+func (m *MockCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).(*mongo.DeleteResult), args.Error(1)
+}
+
+func (m *MockCollection) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	args := m.Called(ctx, filter, update)
+	raw := args.Get(0).(bson.Raw)
+	return mongo.NewSingleResultFromDocument(raw, nil, nil)
+}
+
 func (m *MockCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
 	args := m.Called(ctx, filter)
 	return args.Get(0).(*mongo.Cursor), args.Error(1)
@@ -61,8 +81,46 @@ func TestUserRepository_Create(t *testing.T) {
 
 	mockColl.On("InsertOne", mock.Anything, mock.AnythingOfType("*model.User")).Return(&mongo.InsertOneResult{}, nil)
 
-	err := repo.Create(context.Background(), user)
+	created, err := repo.Create(context.Background(), user)
 	assert.NoError(t, err)
+	assert.False(t, created.ID.IsZero())
+	assert.False(t, created.CreatedAt.IsZero())
+	assert.Equal(t, created.CreatedAt, created.CreatedAt.Truncate(time.Millisecond))
+	assert.Equal(t, "test@example.com", created.Email)
+	mockColl.AssertExpectations(t)
+}
+
+func TestUserRepository_CreateDuplicateEmail(t *testing.T) {
+	mockColl := new(MockCollection)
+	repo := repository.NewUserRepositoryFromCollection(mockColl)
+
+	dup := mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error"}}}
+	mockColl.On("InsertOne", mock.Anything, mock.AnythingOfType("*model.User")).Return(&mongo.InsertOneResult{}, dup)
+
+	_, err := repo.Create(context.Background(), &model.User{Email: "Test@Example.com"})
+	assert.ErrorIs(t, err, repository.ErrEmailTaken)
+}
+
+func TestUserRepository_CreateDuplicateIdentity(t *testing.T) {
+	mockColl := new(MockCollection)
+	repo := repository.NewUserRepositoryFromCollection(mockColl)
+
+	dupIdentity := mongo.WriteException{WriteErrors: []mongo.WriteError{{
+		Code:    11000,
+		Message: `E11000 duplicate key error collection: db.users index: identities.provider_1_identities.subject_1 dup key: { identities.provider: "google", identities.subject: "123" }`,
+	}}}
+	dupEmail := mongo.WriteException{WriteErrors: []mongo.WriteError{{
+		Code:    11000,
+		Message: `E11000 duplicate key error collection: db.users index: email_1 dup key: { email: "test@example.com" }`,
+	}}}
+	mockColl.On("InsertOne", mock.Anything, mock.AnythingOfType("*model.User")).Return(&mongo.InsertOneResult{}, dupIdentity).Once()
+	mockColl.On("InsertOne", mock.Anything, mock.AnythingOfType("*model.User")).Return(&mongo.InsertOneResult{}, dupEmail).Once()
+
+	user := &model.User{Email: "test@example.com", Identities: []model.Identity{{Provider: "google", Subject: "123"}}}
+	_, err := repo.Create(context.Background(), user)
+	assert.ErrorIs(t, err, repository.ErrIdentityTaken)
+	_, err = repo.Create(context.Background(), user)
+	assert.ErrorIs(t, err, repository.ErrEmailTaken)
 	mockColl.AssertExpectations(t)
 }
 
@@ -104,6 +162,88 @@ func TestUserRepository_Update(t *testing.T) {
 	mockColl.AssertExpectations(t)
 }
 
+func TestUserRepository_UpdateDuplicateEmail(t *testing.T) {
+	mockColl := new(MockCollection)
+	repo := repository.NewUserRepositoryFromCollection(mockColl)
+
+	userID := primitive.NewObjectID()
+	dup := mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error"}}}
+	mockColl.On("UpdateOne", mock.Anything, bson.M{"_id": userID}, bson.M{"$set": bson.M{"email": "taken@example.com", "verified": false}}).
+		Return(&mongo.UpdateResult{}, dup)
+
+	err := repo.Update(context.Background(), userID.Hex(), &model.User{Email: "taken@example.com"})
+	assert.ErrorIs(t, err, repository.ErrEmailTaken)
+	mockColl.AssertExpectations(t)
+}
+
+func TestUserRepository_MarkEmailVerified(t *testing.T) {
+	mockColl := new(MockCollection)
+	repo := repository.NewUserRepositoryFromCollection(mockColl)
+
+	userID := primitive.NewObjectID()
+	mockColl.On("UpdateOne", mock.Anything, bson.M{"_id": userID, "email": "alice@example.com"}, bson.M{"$set": bson.M{"verified": true}}).
+		Return(&mongo.UpdateResult{MatchedCount: 1}, nil).Once()
+	assert.NoError(t, repo.MarkEmailVerified(context.Background(), userID.Hex(), "alice@example.com"))
+
+	mockColl.On("UpdateOne", mock.Anything, bson.M{"_id": userID, "email": "old@example.com"}, mock.Anything).
+		Return(&mongo.UpdateResult{}, nil).Once()
+	assert.ErrorIs(t, repo.MarkEmailVerified(context.Background(), userID.Hex(), "old@example.com"), repository.ErrUserNotFound)
+	mockColl.AssertExpectations(t)
+}
+
+func TestUserRepository_UseRecoveryCode(t *testing.T) {
+	mockColl := new(MockCollection)
+	repo := repository.NewUserRepositoryFromCollection(mockColl)
+
+	userID := primitive.NewObjectID()
+	filter := bson.M{"_id": userID, "mfa.recovery_codes": "hash"}
+	update := bson.M{"$pull": bson.M{"mfa.recovery_codes": "hash"}}
+	mockColl.On("UpdateOne", mock.Anything, filter, update).Return(&mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil).Once()
+	mockColl.On("UpdateOne", mock.Anything, filter, update).Return(&mongo.UpdateResult{}, nil).Once()
+
+	used, err := repo.UseRecoveryCode(context.Background(), userID.Hex(), "hash")
+	require.NoError(t, err)
+	assert.True(t, used)
+	used, err = repo.UseRecoveryCode(context.Background(), userID.Hex(), "hash")
+	require.NoError(t, err)
+	assert.False(t, used)
+	mockColl.AssertExpectations(t)
+}
+
+func TestUserRepository_LinkIdentity(t *testing.T) {
+	mockColl := new(MockCollection)
+	repo := repository.NewUserRepositoryFromCollection(mockColl)
+
+	userID := primitive.NewObjectID()
+	identity := model.Identity{Provider: "google", Subject: "123", Email: "test@example.com"}
+	filter := bson.M{
+		"_id":        userID,
+		"identities": bson.M{"$not": bson.M{"$elemMatch": bson.M{"provider": "google", "subject": "123"}}},
+	}
+	update := bson.M{"$push": bson.M{"identities": identity}}
+	dup := mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error"}}}
+	mockColl.On("UpdateOne", mock.Anything, filter, update).Return(&mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil).Once()
+	mockColl.On("UpdateOne", mock.Anything, filter, update).Return(&mongo.UpdateResult{}, dup).Once()
+
+	require.NoError(t, repo.LinkIdentity(context.Background(), userID.Hex(), identity))
+	assert.ErrorIs(t, repo.LinkIdentity(context.Background(), userID.Hex(), identity), repository.ErrIdentityTaken)
+	mockColl.AssertExpectations(t)
+}
+
+func TestUserRepository_LinkIdentityTwice(t *testing.T) {
+	mockColl := new(MockCollection)
+	repo := repository.NewUserRepositoryFromCollection(mockColl)
+
+	linked, missing := primitive.NewObjectID(), primitive.NewObjectID()
+	identity := model.Identity{Provider: "google", Subject: "123"}
+	mockColl.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything).Return(&mongo.UpdateResult{}, nil)
+	mockColl.On("CountDocuments", mock.Anything, bson.M{"_id": linked}).Return(int64(1), nil)
+	mockColl.On("CountDocuments", mock.Anything, bson.M{"_id": missing}).Return(int64(0), nil)
+
+	assert.NoError(t, repo.LinkIdentity(context.Background(), linked.Hex(), identity), "already linked to this user")
+	assert.ErrorIs(t, repo.LinkIdentity(context.Background(), missing.Hex(), identity), repository.ErrUserNotFound)
+}
+
 func TestUserRepository_Delete(t *testing.T) {
 	mockColl := new(MockCollection)
 	repo := repository.NewUserRepositoryFromCollection(mockColl)
@@ -118,6 +258,100 @@ func TestUserRepository_Delete(t *testing.T) {
 	mockColl.AssertExpectations(t)
 }
 
+func TestUserRepository_List(t *testing.T) {
+	mockColl := new(MockCollection)
+	repo := repository.NewUserRepositoryFromCollection(mockColl)
+
+	users := []interface{}{
+		model.User{ID: primitive.NewObjectID(), Name: "Ann"},
+		model.User{ID: primitive.NewObjectID(), Name: "Anna"},
+		model.User{ID: primitive.NewObjectID(), Name: "Annie"},
+	}
+	cursor, _ := mongo.NewCursorFromDocuments(users, nil, nil)
+	filter := bson.M{"name": bson.M{"$gte": "Ann", "$lt": "Ann\uffff"}}
+
+	mockColl.On("CountDocuments", mock.Anything, filter).Return(int64(3), nil)
+	mockColl.On("Find", mock.Anything, filter).Return(cursor, nil)
+
+	page, err := repo.List(context.Background(), model.UserQuery{
+		Filter: model.UserFilter{NamePrefix: "Ann"},
+		SortBy: "name",
+		Limit:  2,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, page.Users, 2)
+	assert.Equal(t, int64(3), page.Total)
+	assert.NotEmpty(t, page.NextCursor)
+	mockColl.AssertExpectations(t)
+}
+
+func TestUserRepository_ListAfterCursor(t *testing.T) {
+	mockColl := new(MockCollection)
+	repo := repository.NewUserRepositoryFromCollection(mockColl)
+
+	last := model.User{ID: primitive.NewObjectID(), Name: "Anna"}
+	first, _ := mongo.NewCursorFromDocuments([]interface{}{model.User{ID: primitive.NewObjectID()}, last, model.User{}}, nil, nil)
+	mockColl.On("CountDocuments", mock.Anything, bson.M{}).Return(int64(3), nil)
+	mockColl.On("Find", mock.Anything, bson.M{}).Return(first, nil).Once()
+
+	page, err := repo.List(context.Background(), model.UserQuery{SortBy: "name", Limit: 2})
+	assert.NoError(t, err)
+
+	next, _ := mongo.NewCursorFromDocuments(nil, nil, nil)
+	want := bson.M{"$and": bson.A{bson.M{}, bson.M{"$or": bson.A{
+		bson.M{"name": bson.M{"$gt": "Anna"}},
+		bson.M{"name": "Anna", "_id": bson.M{"$gt": last.ID}},
+	}}}}
+	mockColl.On("Find", mock.Anything, want).Return(next, nil).Once()
+
+	page, err = repo.List(context.Background(), model.UserQuery{SortBy: "name", After: page.NextCursor, Limit: 2})
+	assert.NoError(t, err)
+	assert.Empty(t, page.Users)
+	assert.Empty(t, page.NextCursor)
+	mockColl.AssertExpectations(t)
+}
+
+func TestUserRepository_ListCursorScope(t *testing.T) {
+	mockColl := new(MockCollection)
+	repo := repository.NewUserRepositoryFromCollection(mockColl)
+	mockColl.On("CountDocuments", mock.Anything, mock.Anything).Return(int64(3), nil)
+	users, _ := mongo.NewCursorFromDocuments([]interface{}{
+		model.User{ID: primitive.NewObjectID(), Name: "Ann"},
+		model.User{ID: primitive.NewObjectID(), Name: "Anna"},
+	}, nil, nil)
+	mockColl.On("Find", mock.Anything, mock.Anything).Return(users, nil).Once()
+
+	query := model.UserQuery{Filter: model.UserFilter{NamePrefix: "ann"}, SortBy: "name", Limit: 1}
+	page, err := repo.List(context.Background(), query)
+	require.NoError(t, err)
+	require.NotEmpty(t, page.NextCursor)
+
+	for name, change := range map[string]func(q *model.UserQuery){
+		"other field":     func(q *model.UserQuery) { q.SortBy = "email" },
+		"other direction": func(q *model.UserQuery) { q.Descending = true },
+		"other filter":    func(q *model.UserQuery) { q.Filter.NamePrefix = "bob" },
+		"added filter":    func(q *model.UserQuery) { q.Filter.CreatedAfter = time.Now() },
+	} {
+		q := query
+		q.After = page.NextCursor
+		change(&q)
+		_, err := repo.List(context.Background(), q)
+		assert.ErrorIs(t, err, apperr.ErrValidation, name)
+	}
+}
+
+func TestUserRepository_ListInvalidQuery(t *testing.T) {
+	mockColl := new(MockCollection)
+	repo := repository.NewUserRepositoryFromCollection(mockColl)
+	mockColl.On("CountDocuments", mock.Anything, mock.Anything).Return(int64(0), nil)
+
+	_, err := repo.List(context.Background(), model.UserQuery{SortBy: "password", Limit: 10})
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+
+	_, err = repo.List(context.Background(), model.UserQuery{After: "not-a-cursor", Limit: 10})
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+}
+
 func TestUserRepository_Count(t *testing.T) {
 	mockColl := new(MockCollection)
 	repo := repository.NewUserRepositoryFromCollection(mockColl)
@@ -0,0 +1,158 @@
+package usecase
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/model"
+	"7-solutions/policy"
+	"7-solutions/repository"
+	"7-solutions/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// APIKeyPrefix starts every API key, so leaked keys are easy to spot.
+	APIKeyPrefix = "7s_"
+	// MaxAPIKeysPerUser caps how many keys a user may hold at once.
+	MaxAPIKeysPerUser = 20
+
+	// apiKeyDisplayLength is how much of a key is kept to identify it.
+	apiKeyDisplayLength = len(APIKeyPrefix) + 6
+	// apiKeyUsedPrecision limits last-used writes to one per key per minute.
+	apiKeyUsedPrecision = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey     = apperr.New(apperr.ErrUnauthorized, "invalid or expired api key")
+	ErrTooManyAPIKeys    = apperr.New(apperr.ErrConflict, "too many api keys, revoke one first")
+	errAPIKeyNameInvalid = apperr.Validation(apperr.FieldError{Field: "name", Message: "must be 1 to 100 characters"})
+)
+
+// APIKeyUsecase manages the API keys users create for machine clients.
+type APIKeyUsecase interface {
+	// CreateAPIKey returns the new key and its secret, which is only shown
+	// this once. A nil expiresAt makes a key that does not expire.
+	CreateAPIKey(ctx context.Context, userID, name string, scopes []string, expiresAt *time.Time) (*model.APIKey, string, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, id string) error
+	// AuthenticateAPIKey returns the key and its user, and records the use.
+	AuthenticateAPIKey(ctx context.Context, secret string) (*model.APIKey, *model.User, error)
+}
+
+type apiKeyUsecase struct {
+	keys  repository.APIKeyRepository
+	users repository.UsersRepository
+}
+
+func NewAPIKeyUsecase(keys repository.APIKeyRepository, users repository.UsersRepository) APIKeyUsecase {
+	return &apiKeyUsecase{keys: keys, users: users}
+}
+
+func (u *apiKeyUsecase) CreateAPIKey(ctx context.Context, userID, name string, scopes []string, expiresAt *time.Time) (*model.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return nil, "", errAPIKeyNameInvalid
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", apperr.Validation(apperr.FieldError{Field: "expires_at", Message: "must be in the future"})
+	}
+	user, err := u.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkScopes(scopes, user.Role); err != nil {
+		return nil, "", err
+	}
+	existing, err := u.keys.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(existing) >= MaxAPIKeysPerUser {
+		return nil, "", ErrTooManyAPIKeys
+	}
+
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return nil, "", err
+	}
+	secret := APIKeyPrefix + token
+	key, err := u.keys.Create(ctx, &model.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    secret[:apiKeyDisplayLength],
+		KeyHash:   utils.HashToken(secret),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+func (u *apiKeyUsecase) ListAPIKeys(ctx context.Context, userID string) ([]model.APIKey, error) {
+	return u.keys.ListByUser(ctx, userID)
+}
+
+func (u *apiKeyUsecase) RevokeAPIKey(ctx context.Context, userID, id string) error {
+	return u.keys.Delete(ctx, userID, id)
+}
+
+func (u *apiKeyUsecase) AuthenticateAPIKey(ctx context.Context, secret string) (*model.APIKey, *model.User, error) {
+	if !strings.HasPrefix(secret, APIKeyPrefix) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	key, err := u.keys.GetByHash(ctx, utils.HashToken(secret))
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	if key.Expired(now) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	user, err := u.users.GetByID(ctx, key.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) || errors.Is(err, repository.ErrInvalidUserID) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyUsedPrecision {
+		if err := u.keys.MarkUsed(ctx, key.ID, now); err != nil {
+			log.Printf("recording use of api key %s: %v", key.ID.Hex(), err)
+		} else {
+			key.LastUsedAt = &now
+		}
+	}
+	return key, user, nil
+}
+
+// checkScopes requires at least one scope, each known and within what role
+// can use, so a key never claims more than its user could do.
+func checkScopes(scopes []string, role model.Role) error {
+	if len(scopes) == 0 {
+		return apperr.Validation(apperr.FieldError{Field: "scopes", Message: "must name at least one scope"})
+	}
+	allowed := policy.RoleScopes(role)
+	var fields []apperr.FieldError
+	for _, scope := range scopes {
+		switch {
+		case !policy.KnownScope(scope):
+			fields = append(fields, apperr.FieldError{Field: "scopes", Message: fmt.Sprintf("unknown scope %q", scope)})
+		case !policy.HasScopes(allowed, scope):
+			fields = append(fields, apperr.FieldError{Field: "scopes", Message: fmt.Sprintf("%q is not available to your role", scope)})
+		}
+	}
+	if len(fields) > 0 {
+		return apperr.Validation(fields...)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package usecase_test
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/mailer"
+	"7-solutions/model"
+	"7-solutions/policy"
+	"7-solutions/repository"
+	"7-solutions/usecase"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryAPIKeys is an in-memory repository.APIKeyRepository.
+type memoryAPIKeys struct {
+	keys []model.APIKey
+}
+
+func (m *memoryAPIKeys) Create(ctx context.Context, key *model.APIKey) (*model.APIKey, error) {
+	created := *key
+	created.ID = primitive.NewObjectID()
+	created.CreatedAt = time.Now()
+	m.keys = append(m.keys, created)
+	return &created, nil
+}
+
+func (m *memoryAPIKeys) GetByHash(ctx context.Context, keyHash string) (*model.APIKey, error) {
+	for _, k := range m.keys {
+		if k.KeyHash == keyHash {
+			return &k, nil
+		}
+	}
+	return nil, repository.ErrAPIKeyNotFound
+}
+
+func (m *memoryAPIKeys) ListByUser(ctx context.Context, userID string) ([]model.APIKey, error) {
+	keys := []model.APIKey{}
+	for _, k := range m.keys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (m *memoryAPIKeys) Delete(ctx context.Context, userID, id string) error {
+	for i, k := range m.keys {
+		if k.ID.Hex() == id && k.UserID == userID {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			return nil
+		}
+	}
+	return repository.ErrAPIKeyNotFound
+}
+
+func (m *memoryAPIKeys) DeleteByUser(ctx context.Context, userID string) error {
+	keys := m.keys[:0]
+	for _, k := range m.keys {
+		if k.UserID != userID {
+			keys = append(keys, k)
+		}
+	}
+	m.keys = keys
+	return nil
+}
+
+func (m *memoryAPIKeys) MarkUsed(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	for i := range m.keys {
+		if m.keys[i].ID == id {
+			m.keys[i].LastUsedAt = &at
+		}
+	}
+	return nil
+}
+
+var readOnly = []string{policy.ScopeUsersRead}
+
+func newAPIKeyUsecase(user *model.User) (usecase.APIKeyUsecase, *memoryAPIKeys) {
+	users := new(MockUserRepo)
+	users.On("GetByID", mock.Anything, user.ID.Hex()).Return(user, nil)
+	users.On("GetByID", mock.Anything, mock.Anything).Return(nil, repository.ErrUserNotFound)
+	keys := &memoryAPIKeys{}
+	return usecase.NewAPIKeyUsecase(keys, users), keys
+}
+
+func TestAPIKey_CreateAndAuthenticate(t *testing.T) {
+	user := &model.User{ID: primitive.NewObjectID(), Role: model.RoleUser}
+	uc, stored := newAPIKeyUsecase(user)
+	ctx := context.Background()
+
+	key, secret, err := uc.CreateAPIKey(ctx, user.ID.Hex(), " ci ", []string{"users:read"}, nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, usecase.APIKeyPrefix))
+	assert.True(t, strings.HasPrefix(secret, key.Prefix))
+	assert.Equal(t, "ci", key.Name)
+	assert.NotEqual(t, secret, stored.keys[0].KeyHash, "only the hash is stored")
+
+	got, owner, err := uc.AuthenticateAPIKey(ctx, secret)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, got.ID)
+	assert.Equal(t, user, owner)
+	require.NotNil(t, stored.keys[0].LastUsedAt)
+
+	_, _, err = uc.AuthenticateAPIKey(ctx, secret+"x")
+	assert.ErrorIs(t, err, usecase.ErrInvalidAPIKey)
+
+	require.NoError(t, uc.RevokeAPIKey(ctx, user.ID.Hex(), key.ID.Hex()))
+	_, _, err = uc.AuthenticateAPIKey(ctx, secret)
+	assert.ErrorIs(t, err, usecase.ErrInvalidAPIKey)
+}
+
+func TestAPIKey_Expiry(t *testing.T) {
+	user := &model.User{ID: primitive.NewObjectID()}
+	uc, stored := newAPIKeyUsecase(user)
+	ctx := context.Background()
+
+	past := time.Now().Add(-time.Minute)
+	_, _, err := uc.CreateAPIKey(ctx, user.ID.Hex(), "ci", readOnly, &past)
+	assert.Error(t, err, "expiry must be in the future")
+
+	future := time.Now().Add(time.Hour)
+	_, secret, err := uc.CreateAPIKey(ctx, user.ID.Hex(), "ci", readOnly, &future)
+	require.NoError(t, err)
+	stored.keys[0].ExpiresAt = &past
+	_, _, err = uc.AuthenticateAPIKey(ctx, secret)
+	assert.ErrorIs(t, err, usecase.ErrInvalidAPIKey)
+}
+
+func TestAPIKey_OwnerDeleted(t *testing.T) {
+	user := &model.User{ID: primitive.NewObjectID()}
+	uc, stored := newAPIKeyUsecase(user)
+	_, secret, err := uc.CreateAPIKey(context.Background(), user.ID.Hex(), "orphan", readOnly, nil)
+	require.NoError(t, err)
+	stored.keys[0].UserID = primitive.NewObjectID().Hex()
+
+	_, _, err = uc.AuthenticateAPIKey(context.Background(), secret)
+	assert.ErrorIs(t, err, usecase.ErrInvalidAPIKey)
+}
+
+func TestAPIKey_RevokeOnlyOwnKeys(t *testing.T) {
+	user := &model.User{ID: primitive.NewObjectID()}
+	uc, _ := newAPIKeyUsecase(user)
+	key, _, err := uc.CreateAPIKey(context.Background(), user.ID.Hex(), "ci", readOnly, nil)
+	require.NoError(t, err)
+
+	err = uc.RevokeAPIKey(context.Background(), primitive.NewObjectID().Hex(), key.ID.Hex())
+	assert.ErrorIs(t, err, repository.ErrAPIKeyNotFound)
+	keys, err := uc.ListAPIKeys(context.Background(), user.ID.Hex())
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+}
+
+func TestAPIKey_Limit(t *testing.T) {
+	user := &model.User{ID: primitive.NewObjectID()}
+	uc, _ := newAPIKeyUsecase(user)
+	for i := 0; i < usecase.MaxAPIKeysPerUser; i++ {
+		_, _, err := uc.CreateAPIKey(context.Background(), user.ID.Hex(), "ci", readOnly, nil)
+		require.NoError(t, err)
+	}
+	_, _, err := uc.CreateAPIKey(context.Background(), user.ID.Hex(), "ci", readOnly, nil)
+	assert.ErrorIs(t, err, usecase.ErrTooManyAPIKeys)
+}
+
+func TestAPIKey_Scopes(t *testing.T) {
+	user := &model.User{ID: primitive.NewObjectID(), Role: model.RoleUser}
+	uc, _ := newAPIKeyUsecase(user)
+	ctx := context.Background()
+
+	for _, scopes := range [][]string{nil, {"users:everything"}, {policy.ScopeUsersAdmin}} {
+		_, _, err := uc.CreateAPIKey(ctx, user.ID.Hex(), "ci", scopes, nil)
+		assert.ErrorIs(t, err, apperr.ErrValidation, "%v", scopes)
+	}
+	key, _, err := uc.CreateAPIKey(ctx, user.ID.Hex(), "ci", []string{policy.ScopeUsersRead, policy.ScopeUsersWrite}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{policy.ScopeUsersRead, policy.ScopeUsersWrite}, key.Scopes)
+}
+
+// newRevokingUsecases returns a user usecase that can revoke the keys of
+// the API key usecase it is paired with.
+func newRevokingUsecases(user *model.User, opts ...usecase.Option) (usecase.UserUsecase, usecase.APIKeyUsecase, *MockUserRepo) {
+	users := new(MockUserRepo)
+	users.On("GetByEmail", mock.Anything, user.Email).Return(user, nil)
+	users.On("GetByID", mock.Anything, user.ID.Hex()).Return(user, nil)
+	users.On("UpdatePassword", mock.Anything, user.ID.Hex(), mock.Anything).Return(nil)
+	keys := &memoryAPIKeys{}
+	opts = append(opts, usecase.WithAPIKeys(keys), usecase.WithPasswordHasher(testHasher))
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour, opts...)
+	return uc, usecase.NewAPIKeyUsecase(keys, users), users
+}
+
+func TestAPIKey_LogoutAll(t *testing.T) {
+	user := &model.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Role: model.RoleUser}
+	uc, apiKeys, _ := newRevokingUsecases(user)
+	ctx := context.Background()
+	_, secret, err := apiKeys.CreateAPIKey(ctx, user.ID.Hex(), "ci", readOnly, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, uc.LogoutAll(ctx, user.ID.Hex(), false))
+	_, _, err = apiKeys.AuthenticateAPIKey(ctx, secret)
+	assert.NoError(t, err, "keys survive unless revoked explicitly")
+
+	require.NoError(t, uc.LogoutAll(ctx, user.ID.Hex(), true))
+	_, _, err = apiKeys.AuthenticateAPIKey(ctx, secret)
+	assert.ErrorIs(t, err, usecase.ErrInvalidAPIKey)
+
+	_, secret, err = apiKeys.CreateAPIKey(ctx, user.ID.Hex(), "ci", readOnly, nil)
+	require.NoError(t, err)
+	_, _, err = apiKeys.AuthenticateAPIKey(ctx, secret)
+	assert.NoError(t, err, "keys created afterwards work")
+}
+
+func TestAPIKey_KeptOnPasswordReset(t *testing.T) {
+	hashed, err := testHasher.Hash("old-password")
+	require.NoError(t, err)
+	user := &model.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Role: model.RoleUser, Password: hashed}
+	mail := mailer.NewMemoryMailer()
+	uc, apiKeys, _ := newRevokingUsecases(user, usecase.WithMailer(mail))
+	ctx := context.Background()
+	_, secret, err := apiKeys.CreateAPIKey(ctx, user.ID.Hex(), "ci", readOnly, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, uc.ForgotPassword(ctx, "alice@example.com"))
+	token := resetTokenPattern.FindString(waitForMail(t, mail, 1)[0].Body)
+	require.NoError(t, uc.ResetPassword(ctx, token, "correct horse battery"))
+	_, _, err = apiKeys.AuthenticateAPIKey(ctx, secret)
+	assert.NoError(t, err)
+
+	require.NoError(t, uc.ChangePassword(ctx, user.ID.Hex(), "current-session", "old-password", "another horse battery"))
+	_, _, err = apiKeys.AuthenticateAPIKey(ctx, secret)
+	assert.NoError(t, err)
+}
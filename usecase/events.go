@@ -0,0 +1,56 @@
+package usecase
+
+import (
+	"7-solutions/model"
+	"log"
+	"sync"
+	"time"
+)
+
+// subscriberBuffer bounds how far a watcher may fall behind before events are
+// dropped for it, so a slow client never blocks writes.
+const subscriberBuffer = 64
+
+type userEventBroker struct {
+	mu          sync.RWMutex
+	nextID      int
+	subscribers map[int]chan model.UserEvent
+}
+
+func newUserEventBroker() *userEventBroker {
+	return &userEventBroker{subscribers: make(map[int]chan model.UserEvent)}
+}
+
+func (b *userEventBroker) subscribe() (<-chan model.UserEvent, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	ch := make(chan model.UserEvent, subscriberBuffer)
+	b.subscribers[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers, id)
+			close(ch)
+		})
+	}
+}
+
+func (b *userEventBroker) publish(eventType model.UserEventType, user model.User) {
+	event := model.UserEvent{Type: eventType, User: user, OccurredAt: time.Now()}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for id, ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("dropping user event for slow subscriber %d", id)
+		}
+	}
+}
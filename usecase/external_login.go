@@ -0,0 +1,192 @@
+package usecase
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/connector"
+	"7-solutions/model"
+	"7-solutions/repository"
+	"7-solutions/utils"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// ExternalLoginTTL is how long a user has to sign in at the identity
+	// provider before the callback is refused.
+	ExternalLoginTTL = 10 * time.Minute
+
+	purposeExternalLogin = "external_login"
+)
+
+var (
+	ErrUnknownConnector      = apperr.New(apperr.ErrNotFound, "unknown identity provider")
+	ErrInvalidExternalState  = apperr.New(apperr.ErrUnauthorized, "invalid or expired sign-in state")
+	ErrExternalLoginFailed   = apperr.New(apperr.ErrUnauthorized, "identity provider sign-in failed")
+	ErrExternalEmailRequired = apperr.New(apperr.ErrValidation, "identity provider did not share an email address")
+	// ErrAccountExists is returned when the provider's email belongs to an
+	// account that cannot be linked automatically. The user signs in with the
+	// password instead.
+	ErrAccountExists = apperr.New(apperr.ErrConflict, "an account with this email already exists, sign in with your password")
+)
+
+// StartExternalLogin remembers a new state, nonce and PKCE verifier for the
+// callback and returns the provider's sign-in URL.
+func (u *userUsecase) StartExternalLogin(ctx context.Context, provider string) (string, string, error) {
+	c, ok := u.connectors[provider]
+	if !ok {
+		return "", "", ErrUnknownConnector
+	}
+	var secrets [3]string
+	for i := range secrets {
+		s, err := utils.GenerateOpaqueToken()
+		if err != nil {
+			return "", "", err
+		}
+		secrets[i] = s
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	authURL, err := c.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+	err = u.tokens.SaveOneTimeToken(ctx, &model.OneTimeToken{
+		TokenHash: utils.HashToken(state),
+		Purpose:   purposeExternalLogin,
+		ExpiresAt: time.Now().Add(ExternalLoginTTL),
+		Data: map[string]string{
+			"provider": provider,
+			"nonce":    nonce,
+			"verifier": verifier,
+		},
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// FinishExternalLogin redeems the provider's code and signs in the user linked
+// to the returned identity. Otherwise the identity is linked to the account
+// with the same email when both the provider and the account have verified
+// it, or a new account without a password is created.
+func (u *userUsecase) FinishExternalLogin(ctx context.Context, provider, state, code string) (*TokenPair, error) {
+	c, ok := u.connectors[provider]
+	if !ok {
+		return nil, ErrUnknownConnector
+	}
+	stored, err := u.tokens.ConsumeOneTimeToken(ctx, purposeExternalLogin, utils.HashToken(state))
+	if errors.Is(err, repository.ErrTokenNotFound) {
+		return nil, ErrInvalidExternalState
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.Data["provider"] != provider {
+		return nil, ErrInvalidExternalState
+	}
+
+	identity, err := c.Exchange(ctx, code, stored.Data["nonce"], stored.Data["verifier"])
+	if err != nil {
+		log.Printf("external login with %s: %v", provider, err)
+		return nil, ErrExternalLoginFailed
+	}
+	user, err := u.externalUser(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.requireVerified && !user.Verified {
+		return nil, ErrEmailNotVerified
+	}
+	if user.MFA.TOTPEnabled {
+		mfaToken, err := u.issueMFAToken(user)
+		if err != nil {
+			return nil, err
+		}
+		return &TokenPair{MFAToken: mfaToken, ExpiresIn: MFATokenTTL}, nil
+	}
+	return u.issueTokens(ctx, user, uuid.New().String())
+}
+
+// externalUser finds, links or creates the user for identity.
+func (u *userUsecase) externalUser(ctx context.Context, identity *connector.Identity) (*model.User, error) {
+	user, err := u.repo.GetByIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, repository.ErrUserNotFound) {
+		return nil, err
+	}
+	if identity.Email == "" {
+		return nil, ErrExternalEmailRequired
+	}
+
+	link := model.Identity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		LinkedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	user, err = u.repo.GetByEmail(ctx, identity.Email)
+	if err == nil {
+		// Linking on an unverified email on either side would let whoever
+		// controls that side take over the account.
+		if !identity.EmailVerified || !user.Verified {
+			return nil, ErrAccountExists
+		}
+		err := u.repo.LinkIdentity(ctx, user.ID.Hex(), link)
+		if errors.Is(err, repository.ErrIdentityTaken) {
+			return u.linkedUser(ctx, identity)
+		}
+		if err != nil {
+			return nil, err
+		}
+		user.Identities = append(user.Identities, link)
+		return user, nil
+	}
+	if !errors.Is(err, repository.ErrUserNotFound) {
+		return nil, err
+	}
+
+	name := identity.Name
+	if name == "" {
+		name = identity.Email
+	}
+	user, err = u.repo.Create(ctx, &model.User{
+		Name:       name,
+		Email:      identity.Email,
+		Role:       model.RoleUser,
+		Verified:   identity.EmailVerified,
+		Identities: []model.Identity{link},
+	})
+	if errors.Is(err, repository.ErrIdentityTaken) {
+		return u.linkedUser(ctx, identity)
+	}
+	if errors.Is(err, repository.ErrEmailTaken) {
+		return nil, ErrAccountExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.events.publish(model.UserCreated, *user)
+	if !user.Verified {
+		if err := u.sendVerification(ctx, user); err != nil {
+			log.Printf("sending verification email to user %s: %v", user.ID.Hex(), err)
+		}
+	}
+	return user, nil
+}
+
+// linkedUser returns the user a concurrent sign-in linked identity to first.
+func (u *userUsecase) linkedUser(ctx context.Context, identity *connector.Identity) (*model.User, error) {
+	user, err := u.repo.GetByIdentity(ctx, identity.Provider, identity.Subject)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrExternalLoginFailed
+	}
+	return user, err
+}
@@ -0,0 +1,184 @@
+package usecase_test
+
+import (
+	"7-solutions/connector"
+	"7-solutions/model"
+	"7-solutions/repository"
+	"7-solutions/usecase"
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// stubConnector returns identity for the code "good" when the nonce and
+// verifier are the ones it put in the sign-in URL.
+type stubConnector struct {
+	identity connector.Identity
+	nonce    string
+	verifier string
+}
+
+func (c *stubConnector) ID() string { return "stub" }
+
+func (c *stubConnector) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	c.nonce, c.verifier = nonce, verifier
+	return "https://idp.example.com/authorize?state=" + url.QueryEscape(state), nil
+}
+
+func (c *stubConnector) Exchange(ctx context.Context, code, nonce, verifier string) (*connector.Identity, error) {
+	if code != "good" || nonce != c.nonce || verifier != c.verifier {
+		return nil, errors.New("invalid_grant")
+	}
+	identity := c.identity
+	return &identity, nil
+}
+
+func newExternalLoginUsecase(identity connector.Identity, opts ...usecase.Option) (usecase.UserUsecase, *MockUserRepo) {
+	users := new(MockUserRepo)
+	opts = append(opts, usecase.WithConnectors(&stubConnector{identity: identity}))
+	return usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour, opts...), users
+}
+
+// externalLogin runs the whole flow with code "good".
+func externalLogin(t *testing.T, uc usecase.UserUsecase) (*usecase.TokenPair, error) {
+	authURL, state, err := uc.StartExternalLogin(context.Background(), "stub")
+	require.NoError(t, err)
+	assert.Equal(t, "https://idp.example.com/authorize?state="+url.QueryEscape(state), authURL)
+	return uc.FinishExternalLogin(context.Background(), "stub", state, "good")
+}
+
+var externalIdentity = connector.Identity{
+	Provider:      "stub",
+	Subject:       "248289761001",
+	Email:         "alice@example.com",
+	EmailVerified: true,
+	Name:          "Alice",
+}
+
+func TestExternalLogin_LinkedIdentity(t *testing.T) {
+	uc, users := newExternalLoginUsecase(externalIdentity)
+	user := &model.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Role: model.RoleUser}
+	users.On("GetByIdentity", mock.Anything, "stub", "248289761001").Return(user, nil)
+
+	tokens, err := externalLogin(t, uc)
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.NotEmpty(t, tokens.RefreshToken)
+	users.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestExternalLogin_CreatesUserWithoutPassword(t *testing.T) {
+	uc, users := newExternalLoginUsecase(externalIdentity)
+	users.On("GetByIdentity", mock.Anything, "stub", "248289761001").Return(nil, repository.ErrUserNotFound)
+	users.On("GetByEmail", mock.Anything, "alice@example.com").Return(nil, repository.ErrUserNotFound)
+	users.On("Create", mock.Anything, mock.MatchedBy(func(u *model.User) bool {
+		return u.Password == "" && u.Verified && u.Name == "Alice" &&
+			len(u.Identities) == 1 && u.Identities[0].Subject == "248289761001"
+	})).Return(&model.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Role: model.RoleUser, Verified: true}, nil)
+
+	tokens, err := externalLogin(t, uc)
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+	users.AssertExpectations(t)
+
+	_, _, err = uc.Authenticate(context.Background(), "alice@example.com", "", "")
+	assert.ErrorIs(t, err, usecase.ErrInvalidCredentials, "no password to sign in with")
+}
+
+func TestExternalLogin_ConcurrentCreate(t *testing.T) {
+	uc, users := newExternalLoginUsecase(externalIdentity)
+	user := &model.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Role: model.RoleUser, Verified: true}
+	users.On("GetByIdentity", mock.Anything, "stub", "248289761001").Return(nil, repository.ErrUserNotFound).Once()
+	users.On("GetByEmail", mock.Anything, "alice@example.com").Return(nil, repository.ErrUserNotFound)
+	users.On("Create", mock.Anything, mock.Anything).Return(nil, repository.ErrIdentityTaken)
+	users.On("GetByIdentity", mock.Anything, "stub", "248289761001").Return(user, nil)
+
+	tokens, err := externalLogin(t, uc)
+	require.NoError(t, err, "the sign-in that created the user first wins")
+	assert.NotEmpty(t, tokens.AccessToken)
+}
+
+func TestExternalLogin_LinksVerifiedEmail(t *testing.T) {
+	uc, users := newExternalLoginUsecase(externalIdentity)
+	user := &model.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Role: model.RoleUser, Verified: true}
+	users.On("GetByIdentity", mock.Anything, "stub", "248289761001").Return(nil, repository.ErrUserNotFound)
+	users.On("GetByEmail", mock.Anything, "alice@example.com").Return(user, nil)
+	users.On("LinkIdentity", mock.Anything, user.ID.Hex(), mock.MatchedBy(func(i model.Identity) bool {
+		return i.Provider == "stub" && i.Subject == "248289761001"
+	})).Return(nil)
+
+	_, err := externalLogin(t, uc)
+	require.NoError(t, err)
+	users.AssertExpectations(t)
+}
+
+func TestExternalLogin_RefusesUnverifiedLink(t *testing.T) {
+	unverified := externalIdentity
+	unverified.EmailVerified = false
+
+	for name, tc := range map[string]struct {
+		identity connector.Identity
+		verified bool
+	}{
+		"provider email unverified": {identity: unverified, verified: true},
+		"local email unverified":    {identity: externalIdentity, verified: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			uc, users := newExternalLoginUsecase(tc.identity)
+			user := &model.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Verified: tc.verified}
+			users.On("GetByIdentity", mock.Anything, "stub", "248289761001").Return(nil, repository.ErrUserNotFound)
+			users.On("GetByEmail", mock.Anything, "alice@example.com").Return(user, nil)
+
+			_, err := externalLogin(t, uc)
+			assert.ErrorIs(t, err, usecase.ErrAccountExists)
+			users.AssertNotCalled(t, "LinkIdentity", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestExternalLogin_RequiresEmail(t *testing.T) {
+	identity := externalIdentity
+	identity.Email = ""
+	uc, users := newExternalLoginUsecase(identity)
+	users.On("GetByIdentity", mock.Anything, "stub", "248289761001").Return(nil, repository.ErrUserNotFound)
+
+	_, err := externalLogin(t, uc)
+	assert.ErrorIs(t, err, usecase.ErrExternalEmailRequired)
+}
+
+func TestExternalLogin_MFA(t *testing.T) {
+	uc, users := newExternalLoginUsecase(externalIdentity)
+	user := &model.User{ID: primitive.NewObjectID(), Email: "alice@example.com", MFA: model.MFA{TOTPEnabled: true}}
+	users.On("GetByIdentity", mock.Anything, "stub", "248289761001").Return(user, nil)
+
+	tokens, err := externalLogin(t, uc)
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.MFAToken)
+	assert.Empty(t, tokens.AccessToken)
+}
+
+func TestExternalLogin_State(t *testing.T) {
+	uc, users := newExternalLoginUsecase(externalIdentity)
+	users.On("GetByIdentity", mock.Anything, "stub", "248289761001").Return(&model.User{ID: primitive.NewObjectID()}, nil)
+	ctx := context.Background()
+
+	_, _, err := uc.StartExternalLogin(ctx, "other")
+	assert.ErrorIs(t, err, usecase.ErrUnknownConnector)
+
+	_, err = uc.FinishExternalLogin(ctx, "stub", "forged", "good")
+	assert.ErrorIs(t, err, usecase.ErrInvalidExternalState)
+
+	_, state, err := uc.StartExternalLogin(ctx, "stub")
+	require.NoError(t, err)
+	_, err = uc.FinishExternalLogin(ctx, "stub", state, "bad")
+	assert.ErrorIs(t, err, usecase.ErrExternalLoginFailed)
+	_, err = uc.FinishExternalLogin(ctx, "stub", state, "good")
+	assert.ErrorIs(t, err, usecase.ErrInvalidExternalState, "state works once")
+}
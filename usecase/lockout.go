@@ -0,0 +1,114 @@
+package usecase
+
+import (
+	"7-solutions/apperr"
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAccountLocked  = apperr.New(apperr.ErrLocked, "account temporarily locked after too many failed logins")
+	ErrLoginThrottled = apperr.New(apperr.ErrTooManyRequests, "too many failed logins, try again later")
+)
+
+func loginEmailKey(email string) string {
+	return "login:email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func loginLockKey(email string) string {
+	return "login:lock:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func loginIPKey(clientIP string) string {
+	return "login:ip:" + clientIP
+}
+
+// checkLoginAllowed refuses a login while the account is locked or backing
+// off, or while the client IP is blocked.
+func (u *userUsecase) checkLoginAllowed(ctx context.Context, email, clientIP string) error {
+	if err := u.checkBlock(ctx, loginLockKey(email), ErrAccountLocked); err != nil {
+		return err
+	}
+	if err := u.checkBlock(ctx, loginEmailKey(email), ErrLoginThrottled); err != nil {
+		return err
+	}
+	if clientIP == "" {
+		return nil
+	}
+	return u.checkBlock(ctx, loginIPKey(clientIP), ErrLoginThrottled)
+}
+
+func (u *userUsecase) checkBlock(ctx context.Context, key string, blocked error) error {
+	until, err := u.tokens.AttemptsBlockedUntil(ctx, key)
+	if err != nil {
+		return err
+	}
+	if wait := time.Until(until); wait > 0 {
+		return apperr.WithRetryAfter(blocked, wait)
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login against the email and the client
+// IP and applies the lockout policy. Emails are counted whether or not they
+// are registered, so lockouts reveal nothing about accounts. Storage errors
+// are only logged; checkLoginAllowed already fails closed.
+func (u *userUsecase) recordLoginFailure(ctx context.Context, email, clientIP string) {
+	if err := u.countLoginFailure(ctx, email, clientIP); err != nil {
+		log.Printf("recording failed login: %v", err)
+	}
+}
+
+func (u *userUsecase) countLoginFailure(ctx context.Context, email, clientIP string) error {
+	now := time.Now()
+	emailKey := loginEmailKey(email)
+	failures, err := u.tokens.IncrementAttempts(ctx, emailKey, u.lockout.Window)
+	if err != nil {
+		return err
+	}
+	switch {
+	case u.lockout.LocksAccount(failures):
+		if err := u.tokens.BlockAttempts(ctx, loginLockKey(email), now.Add(u.lockout.LockoutDuration)); err != nil {
+			return err
+		}
+		// Counting starts over once the lockout ends.
+		if err := u.tokens.ResetAttempts(ctx, emailKey); err != nil {
+			return err
+		}
+	case u.lockout.Backoff(failures) > 0:
+		if err := u.tokens.BlockAttempts(ctx, emailKey, now.Add(u.lockout.Backoff(failures))); err != nil {
+			return err
+		}
+	}
+
+	if clientIP == "" {
+		return nil
+	}
+	ipKey := loginIPKey(clientIP)
+	failures, err = u.tokens.IncrementAttempts(ctx, ipKey, u.lockout.Window)
+	if err != nil {
+		return err
+	}
+	if !u.lockout.BlocksIP(failures) {
+		return nil
+	}
+	if err := u.tokens.ResetAttempts(ctx, ipKey); err != nil {
+		return err
+	}
+	return u.tokens.BlockAttempts(ctx, ipKey, now.Add(u.lockout.LockoutDuration))
+}
+
+// UnlockUser lifts a lockout or backoff on the user's account and clears its
+// failed login count. Blocks on client IPs are left alone.
+func (u *userUsecase) UnlockUser(ctx context.Context, id string) error {
+	user, err := u.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := u.tokens.ResetAttempts(ctx, loginLockKey(user.Email)); err != nil {
+		return err
+	}
+	return u.tokens.ResetAttempts(ctx, loginEmailKey(user.Email))
+}
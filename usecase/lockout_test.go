@@ -0,0 +1,112 @@
+package usecase_test
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/model"
+	"7-solutions/policy"
+	"7-solutions/repository"
+	"7-solutions/usecase"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newLockoutUsecase(t *testing.T, lockout policy.LockoutPolicy) (usecase.UserUsecase, *model.User) {
+	hashed, err := testHasher.Hash("secret")
+	require.NoError(t, err)
+	user := &model.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Password: hashed}
+	users := new(MockUserRepo)
+	users.On("GetByEmail", mock.Anything, "alice@example.com").Return(user, nil)
+	users.On("GetByEmail", mock.Anything, mock.Anything).Return(nil, repository.ErrUserNotFound)
+	users.On("GetByID", mock.Anything, user.ID.Hex()).Return(user, nil)
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour,
+		usecase.WithPasswordHasher(testHasher),
+		usecase.WithLockoutPolicy(lockout),
+	)
+	return uc, user
+}
+
+func TestLogin_BacksOffAfterFreeAttempts(t *testing.T) {
+	uc, _ := newLockoutUsecase(t, policy.LockoutPolicy{Window: time.Hour, FreeAttempts: 2, BaseDelay: time.Minute})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := uc.Login(ctx, "alice@example.com", "wrong", "10.0.0.1")
+		require.ErrorIs(t, err, usecase.ErrInvalidCredentials)
+	}
+
+	_, err := uc.Login(ctx, "Alice@Example.com", "secret", "10.0.0.2")
+	assert.ErrorIs(t, err, usecase.ErrLoginThrottled)
+	assert.ErrorIs(t, err, apperr.ErrTooManyRequests)
+	after, ok := apperr.RetryAfter(err)
+	require.True(t, ok)
+	assert.InDelta(t, time.Minute.Seconds(), after.Seconds(), 1)
+}
+
+func TestLogin_LocksAccount(t *testing.T) {
+	uc, user := newLockoutUsecase(t, policy.LockoutPolicy{Window: time.Hour, MaxAttempts: 3, LockoutDuration: time.Hour})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := uc.Login(ctx, "alice@example.com", "wrong", "")
+		require.ErrorIs(t, err, usecase.ErrInvalidCredentials)
+	}
+
+	_, err := uc.Login(ctx, "alice@example.com", "secret", "")
+	assert.ErrorIs(t, err, usecase.ErrAccountLocked)
+	assert.ErrorIs(t, err, apperr.ErrLocked)
+	after, ok := apperr.RetryAfter(err)
+	require.True(t, ok)
+	assert.InDelta(t, time.Hour.Seconds(), after.Seconds(), 1)
+
+	require.NoError(t, uc.UnlockUser(ctx, user.ID.Hex()))
+	_, err = uc.Login(ctx, "alice@example.com", "secret", "")
+	assert.NoError(t, err)
+}
+
+func TestLogin_SuccessResetsAccountFailures(t *testing.T) {
+	uc, _ := newLockoutUsecase(t, policy.LockoutPolicy{Window: time.Hour, MaxAttempts: 2, LockoutDuration: time.Hour})
+	ctx := context.Background()
+
+	_, err := uc.Login(ctx, "alice@example.com", "wrong", "")
+	require.ErrorIs(t, err, usecase.ErrInvalidCredentials)
+	_, err = uc.Login(ctx, "alice@example.com", "secret", "")
+	require.NoError(t, err)
+	_, err = uc.Login(ctx, "alice@example.com", "wrong", "")
+	require.ErrorIs(t, err, usecase.ErrInvalidCredentials)
+
+	_, err = uc.Login(ctx, "alice@example.com", "secret", "")
+	assert.NoError(t, err)
+}
+
+func TestLogin_UnknownEmailsAreThrottledToo(t *testing.T) {
+	uc, _ := newLockoutUsecase(t, policy.LockoutPolicy{Window: time.Hour, MaxAttempts: 2, LockoutDuration: time.Hour})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, err := uc.Login(ctx, "nobody@example.com", "wrong", "")
+		require.ErrorIs(t, err, usecase.ErrInvalidCredentials)
+	}
+	_, err := uc.Login(ctx, "nobody@example.com", "wrong", "")
+	assert.ErrorIs(t, err, usecase.ErrAccountLocked)
+}
+
+func TestLogin_BlocksClientIP(t *testing.T) {
+	uc, _ := newLockoutUsecase(t, policy.LockoutPolicy{Window: time.Hour, MaxIPAttempts: 3, LockoutDuration: time.Hour})
+	ctx := context.Background()
+
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		_, err := uc.Login(ctx, email, "wrong", "10.0.0.1")
+		require.ErrorIs(t, err, usecase.ErrInvalidCredentials)
+	}
+
+	_, err := uc.Login(ctx, "alice@example.com", "secret", "10.0.0.1")
+	assert.ErrorIs(t, err, usecase.ErrLoginThrottled)
+	_, err = uc.Login(ctx, "alice@example.com", "secret", "10.0.0.2")
+	assert.NoError(t, err)
+}
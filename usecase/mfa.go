@@ -0,0 +1,291 @@
+package usecase
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/model"
+	"7-solutions/repository"
+	"7-solutions/utils"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"image/png"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	DefaultTOTPIssuer = "7-solutions"
+	// MFATokenTTL is how long the token returned by Login to users with
+	// two-factor authentication can be exchanged through LoginMFA.
+	MFATokenTTL = 5 * time.Minute
+
+	totpPeriod = 30 * time.Second
+	totpSkew   = 1
+	// maxMFAAttempts wrong codes block a user's LoginMFA for MFATokenTTL.
+	maxMFAAttempts    = 5
+	recoveryCodeCount = 10
+	qrCodeSize        = 256
+)
+
+var (
+	ErrInvalidMFAToken    = apperr.New(apperr.ErrUnauthorized, "invalid or expired mfa token")
+	ErrInvalidMFACode     = apperr.New(apperr.ErrUnauthorized, "invalid authentication code")
+	ErrTooManyMFAAttempts = apperr.New(apperr.ErrTooManyRequests, "too many invalid authentication codes, try again later")
+	ErrMFAAlreadyEnabled  = apperr.New(apperr.ErrConflict, "two-factor authentication is already enabled")
+	ErrMFANotEnrolled     = apperr.New(apperr.ErrConflict, "two-factor enrollment has not been started")
+)
+
+// TOTPEnrollment is what an authenticator app needs to add the account.
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+	// QRCode is a PNG image encoding URI.
+	QRCode []byte
+}
+
+var totpOpts = totp.ValidateOpts{
+	Period:    uint(totpPeriod.Seconds()),
+	Skew:      totpSkew,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// EnrollTOTP starts TOTP enrollment with a new secret. Two-factor login only
+// applies once ConfirmTOTP has checked a code from it.
+func (u *userUsecase) EnrollTOTP(ctx context.Context, userID string) (*TOTPEnrollment, error) {
+	user, err := u.repo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFA.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      u.totpIssuer,
+		AccountName: user.Email,
+		Period:      totpOpts.Period,
+		Digits:      totpOpts.Digits,
+		Algorithm:   totpOpts.Algorithm,
+	})
+	if err != nil {
+		return nil, err
+	}
+	img, err := key.Image(qrCodeSize, qrCodeSize)
+	if err != nil {
+		return nil, err
+	}
+	var qr bytes.Buffer
+	if err := png.Encode(&qr, img); err != nil {
+		return nil, err
+	}
+
+	if err := u.repo.UpdateMFA(ctx, userID, model.MFA{TOTPSecret: key.Secret()}); err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{Secret: key.Secret(), URI: key.URL(), QRCode: qr.Bytes()}, nil
+}
+
+// ConfirmTOTP enables two-factor login once code matches the enrolled secret
+// and returns the recovery codes, which are only shown this once.
+func (u *userUsecase) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	user, err := u.repo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFA.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.MFA.TOTPSecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+	ok, err := u.verifyTOTP(ctx, user, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, apperr.Validation(apperr.FieldError{Field: "code", Message: "is incorrect"})
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = u.repo.UpdateMFA(ctx, userID, model.MFA{
+		TOTPSecret:    user.MFA.TOTPSecret,
+		TOTPEnabled:   true,
+		RecoveryCodes: hashes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor login off after checking the password. Users
+// without a password, who sign in through an identity provider, confirm with
+// a current TOTP or recovery code instead, limited like LoginMFA.
+func (u *userUsecase) DisableTOTP(ctx context.Context, userID, password, code string) error {
+	user, err := u.repo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Password != "" {
+		if !u.verifyPassword(user, password) {
+			return apperr.Validation(apperr.FieldError{Field: "password", Message: "is incorrect"})
+		}
+		return u.repo.UpdateMFA(ctx, userID, model.MFA{})
+	}
+
+	if strings.TrimSpace(code) == "" {
+		return apperr.Validation(apperr.FieldError{Field: "code", Message: "is required for accounts without a password"})
+	}
+	attemptsKey := "mfa:" + userID
+	if err := u.checkBlock(ctx, attemptsKey, ErrTooManyMFAAttempts); err != nil {
+		return err
+	}
+	ok, err := u.verifySecondFactor(ctx, user, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := u.recordMFAFailure(ctx, attemptsKey); !errors.Is(err, ErrInvalidMFACode) {
+			return err
+		}
+		return apperr.Validation(apperr.FieldError{Field: "code", Message: "is incorrect"})
+	}
+	if err := u.tokens.ResetAttempts(ctx, attemptsKey); err != nil {
+		return err
+	}
+	return u.repo.UpdateMFA(ctx, userID, model.MFA{})
+}
+
+// issueMFAToken answers a correct password from a user with two-factor login
+// with a short-lived token for LoginMFA instead of a session.
+func (u *userUsecase) issueMFAToken(user *model.User) (string, error) {
+	return utils.GeneratePurposeToken(user.ID.Hex(), utils.PurposeMFAPending, u.jwtSecret, MFATokenTTL, nil)
+}
+
+// LoginMFA exchanges the token from Login and a TOTP or recovery code for a
+// session. Each token, TOTP code and recovery code works once.
+func (u *userUsecase) LoginMFA(ctx context.Context, mfaToken, code string) (*TokenPair, error) {
+	user, err := u.AuthenticateMFA(ctx, mfaToken, code)
+	if err != nil {
+		return nil, err
+	}
+	return u.issueTokens(ctx, user, uuid.New().String())
+}
+
+func (u *userUsecase) AuthenticateMFA(ctx context.Context, mfaToken, code string) (*model.User, error) {
+	userID, _, err := utils.ValidatePurposeToken(mfaToken, utils.PurposeMFAPending, u.jwtSecret)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+	user, err := u.repo.GetByID(ctx, userID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrInvalidMFAToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !user.MFA.TOTPEnabled {
+		return nil, ErrInvalidMFAToken
+	}
+
+	attemptsKey := "mfa:" + userID
+	if err := u.checkBlock(ctx, attemptsKey, ErrTooManyMFAAttempts); err != nil {
+		return nil, err
+	}
+	// The token is claimed before the code is checked, so a replayed token
+	// cannot use up a recovery code or a TOTP code. A wrong code releases it
+	// for another try.
+	tokenKey := "mfa_token:" + utils.HashToken(mfaToken)
+	n, err := u.tokens.IncrementAttempts(ctx, tokenKey, MFATokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	if n > 1 {
+		return nil, ErrInvalidMFAToken
+	}
+	ok, err := u.verifySecondFactor(ctx, user, code)
+	if err == nil && !ok {
+		err = u.recordMFAFailure(ctx, attemptsKey)
+	}
+	if err != nil {
+		if resetErr := u.tokens.ResetAttempts(ctx, tokenKey); resetErr != nil {
+			log.Printf("releasing mfa token of user %s: %v", userID, resetErr)
+		}
+		return nil, err
+	}
+	if err := u.tokens.ResetAttempts(ctx, attemptsKey); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (u *userUsecase) recordMFAFailure(ctx context.Context, key string) error {
+	n, err := u.tokens.IncrementAttempts(ctx, key, MFATokenTTL)
+	if err != nil {
+		return err
+	}
+	if n < maxMFAAttempts {
+		return ErrInvalidMFACode
+	}
+	if err := u.tokens.ResetAttempts(ctx, key); err != nil {
+		return err
+	}
+	if err := u.tokens.BlockAttempts(ctx, key, time.Now().Add(MFATokenTTL)); err != nil {
+		return err
+	}
+	return apperr.WithRetryAfter(ErrTooManyMFAAttempts, MFATokenTTL)
+}
+
+// verifySecondFactor accepts a current TOTP code or an unused recovery code.
+func (u *userUsecase) verifySecondFactor(ctx context.Context, user *model.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) == int(totpOpts.Digits) {
+		return u.verifyTOTP(ctx, user, code)
+	}
+	return u.repo.UseRecoveryCode(ctx, user.ID.Hex(), utils.HashToken(normalizeRecoveryCode(code)))
+}
+
+// verifyTOTP checks code against the user's secret, allowing one period of
+// clock drift either way. A code is refused the second time it is presented.
+func (u *userUsecase) verifyTOTP(ctx context.Context, user *model.User, code string) (bool, error) {
+	ok, err := totp.ValidateCustom(code, user.MFA.TOTPSecret, time.Now(), totpOpts)
+	if err != nil || !ok {
+		return false, nil
+	}
+	n, err := u.tokens.IncrementAttempts(ctx, "totp_used:"+user.ID.Hex()+":"+code, (2*totpSkew+1)*totpPeriod)
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// generateRecoveryCodes returns codes formatted as "xxxxx-xxxxx" and the
+// hashes to store.
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(raw))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, utils.HashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,242 @@
+package usecase_test
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/model"
+	"7-solutions/repository"
+	"7-solutions/usecase"
+	"7-solutions/utils"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newMFAUsecase serves user from a mock repository that applies UpdateMFA.
+func newMFAUsecase(t *testing.T, user *model.User) (usecase.UserUsecase, *MockUserRepo) {
+	users := new(MockUserRepo)
+	users.On("GetByID", mock.Anything, user.ID.Hex()).Return(user, nil)
+	users.On("GetByEmail", mock.Anything, user.Email).Return(user, nil)
+	users.On("UpdateMFA", mock.Anything, user.ID.Hex(), mock.Anything).
+		Run(func(args mock.Arguments) { user.MFA = args.Get(2).(model.MFA) }).
+		Return(nil)
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour,
+		usecase.WithPasswordHasher(testHasher),
+	)
+	return uc, users
+}
+
+func newMFAUser(t *testing.T) *model.User {
+	hashed, err := testHasher.Hash("secret")
+	require.NoError(t, err)
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "test", AccountName: "alice@example.com"})
+	require.NoError(t, err)
+	return &model.User{
+		ID:       primitive.NewObjectID(),
+		Email:    "alice@example.com",
+		Password: hashed,
+		MFA: model.MFA{
+			TOTPSecret:    key.Secret(),
+			TOTPEnabled:   true,
+			RecoveryCodes: []string{utils.HashToken("abcdeabcde")},
+		},
+	}
+}
+
+func TestTOTPEnrollment(t *testing.T) {
+	user := &model.User{ID: primitive.NewObjectID(), Email: "alice@example.com"}
+	uc, _ := newMFAUsecase(t, user)
+	ctx := context.Background()
+
+	_, err := uc.ConfirmTOTP(ctx, user.ID.Hex(), "123456")
+	assert.ErrorIs(t, err, usecase.ErrMFANotEnrolled)
+
+	enrollment, err := uc.EnrollTOTP(ctx, user.ID.Hex())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/7-solutions:alice@example.com?"), enrollment.URI)
+	assert.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+	assert.Equal(t, "\x89PNG", string(enrollment.QRCode[:4]))
+	assert.False(t, user.MFA.TOTPEnabled, "enrollment needs confirming")
+
+	_, err = uc.ConfirmTOTP(ctx, user.ID.Hex(), "abcdef")
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+
+	code, err := totp.GenerateCode(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	recovery, err := uc.ConfirmTOTP(ctx, user.ID.Hex(), code)
+	require.NoError(t, err)
+	assert.Len(t, recovery, 10)
+	assert.Regexp(t, `^[a-z2-7]{5}-[a-z2-7]{5}$`, recovery[0])
+	assert.True(t, user.MFA.TOTPEnabled)
+	assert.Len(t, user.MFA.RecoveryCodes, 10)
+	assert.NotContains(t, user.MFA.RecoveryCodes, recovery[0], "only hashes are stored")
+
+	_, err = uc.EnrollTOTP(ctx, user.ID.Hex())
+	assert.ErrorIs(t, err, usecase.ErrMFAAlreadyEnabled)
+
+	assert.ErrorIs(t, uc.DisableTOTP(ctx, user.ID.Hex(), "wrong", ""), apperr.ErrValidation)
+}
+
+func TestDisableTOTP(t *testing.T) {
+	user := newMFAUser(t)
+	uc, _ := newMFAUsecase(t, user)
+	ctx := context.Background()
+
+	code, err := totp.GenerateCode(user.MFA.TOTPSecret, time.Now())
+	require.NoError(t, err)
+	assert.ErrorIs(t, uc.DisableTOTP(ctx, user.ID.Hex(), "", code), apperr.ErrValidation, "a code does not replace an existing password")
+	require.NoError(t, uc.DisableTOTP(ctx, user.ID.Hex(), "secret", ""))
+	assert.False(t, user.MFA.TOTPEnabled)
+}
+
+func TestDisableTOTP_WithoutPassword(t *testing.T) {
+	user := newMFAUser(t)
+	user.Password = ""
+	uc, users := newMFAUsecase(t, user)
+	users.On("UseRecoveryCode", mock.Anything, user.ID.Hex(), mock.Anything).Return(false, nil)
+	ctx := context.Background()
+
+	assert.ErrorIs(t, uc.DisableTOTP(ctx, user.ID.Hex(), "", "000000"), apperr.ErrValidation)
+	assert.ErrorIs(t, uc.DisableTOTP(ctx, user.ID.Hex(), "", "wrong-code"), apperr.ErrValidation)
+	assert.True(t, user.MFA.TOTPEnabled)
+
+	code, err := totp.GenerateCode(user.MFA.TOTPSecret, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, uc.DisableTOTP(ctx, user.ID.Hex(), "", code))
+	assert.False(t, user.MFA.TOTPEnabled)
+}
+
+func TestLoginMFA(t *testing.T) {
+	user := newMFAUser(t)
+	uc, users := newMFAUsecase(t, user)
+	users.On("UseRecoveryCode", mock.Anything, user.ID.Hex(), utils.HashToken("abcdeabcde")).Return(true, nil).Once()
+	users.On("UseRecoveryCode", mock.Anything, user.ID.Hex(), mock.Anything).Return(false, nil)
+	ctx := context.Background()
+
+	pending, err := uc.Login(ctx, "alice@example.com", "secret", "")
+	require.NoError(t, err)
+	assert.Empty(t, pending.AccessToken)
+	assert.Empty(t, pending.RefreshToken)
+	require.NotEmpty(t, pending.MFAToken)
+	assert.Equal(t, usecase.MFATokenTTL, pending.ExpiresIn)
+	_, err = utils.ValidateJWT(pending.MFAToken, "secret")
+	assert.Error(t, err, "an mfa token is not an access token")
+	subject, claims, err := utils.ValidatePurposeToken(pending.MFAToken, utils.PurposeMFAPending, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID.Hex(), subject)
+	assert.NotContains(t, claims, "email")
+	_, _, err = utils.ValidatePurposeToken(pending.MFAToken, utils.PurposeVerifyEmail, "secret")
+	assert.Error(t, err, "an mfa token does not verify an email")
+
+	later, err := totp.GenerateCode(user.MFA.TOTPSecret, time.Now().Add(10*time.Minute))
+	require.NoError(t, err)
+	_, err = uc.LoginMFA(ctx, pending.MFAToken, later)
+	assert.ErrorIs(t, err, usecase.ErrInvalidMFACode)
+
+	code, err := totp.GenerateCode(user.MFA.TOTPSecret, time.Now())
+	require.NoError(t, err)
+	session, err := uc.LoginMFA(ctx, pending.MFAToken, code)
+	require.NoError(t, err)
+	assert.NotEmpty(t, session.AccessToken)
+	assert.Empty(t, session.MFAToken)
+
+	again, err := uc.Login(ctx, "alice@example.com", "secret", "")
+	require.NoError(t, err)
+	_, err = uc.LoginMFA(ctx, again.MFAToken, code)
+	assert.ErrorIs(t, err, usecase.ErrInvalidMFACode, "a TOTP code works once")
+	_, err = uc.LoginMFA(ctx, session.AccessToken, code)
+	assert.ErrorIs(t, err, usecase.ErrInvalidMFAToken)
+
+	_, err = uc.LoginMFA(ctx, again.MFAToken, "ABCDE-abcde")
+	require.NoError(t, err, "recovery codes are accepted")
+	third, err := uc.Login(ctx, "alice@example.com", "secret", "")
+	require.NoError(t, err)
+	_, err = uc.LoginMFA(ctx, third.MFAToken, "abcde-abcde")
+	assert.ErrorIs(t, err, usecase.ErrInvalidMFACode, "a recovery code works once")
+}
+
+func TestLoginMFA_TokenWorksOnce(t *testing.T) {
+	user := newMFAUser(t)
+	uc, users := newMFAUsecase(t, user)
+	users.On("UseRecoveryCode", mock.Anything, user.ID.Hex(), mock.Anything).Return(true, nil)
+	ctx := context.Background()
+
+	pending, err := uc.Login(ctx, "alice@example.com", "secret", "")
+	require.NoError(t, err)
+	_, err = uc.LoginMFA(ctx, pending.MFAToken, "aaaaa-aaaaa")
+	require.NoError(t, err)
+	_, err = uc.LoginMFA(ctx, pending.MFAToken, "bbbbb-bbbbb")
+	assert.ErrorIs(t, err, usecase.ErrInvalidMFAToken)
+	users.AssertNumberOfCalls(t, "UseRecoveryCode", 1)
+}
+
+func TestLoginMFA_ReplayedTokenKeepsCodes(t *testing.T) {
+	user := newMFAUser(t)
+	uc, users := newMFAUsecase(t, user)
+	users.On("UseRecoveryCode", mock.Anything, user.ID.Hex(), mock.Anything).Return(true, nil)
+	ctx := context.Background()
+
+	pending, err := uc.Login(ctx, "alice@example.com", "secret", "")
+	require.NoError(t, err)
+	_, err = uc.LoginMFA(ctx, pending.MFAToken, "aaaaa-aaaaa")
+	require.NoError(t, err)
+
+	// A spent token is refused before the code is looked at.
+	_, err = uc.LoginMFA(ctx, pending.MFAToken, "bbbbb-bbbbb")
+	assert.ErrorIs(t, err, usecase.ErrInvalidMFAToken)
+	users.AssertNumberOfCalls(t, "UseRecoveryCode", 1)
+	code, err := totp.GenerateCode(user.MFA.TOTPSecret, time.Now())
+	require.NoError(t, err)
+	_, err = uc.LoginMFA(ctx, pending.MFAToken, code)
+	assert.ErrorIs(t, err, usecase.ErrInvalidMFAToken)
+
+	again, err := uc.Login(ctx, "alice@example.com", "secret", "")
+	require.NoError(t, err)
+	_, err = uc.LoginMFA(ctx, again.MFAToken, code)
+	assert.NoError(t, err, "the replay did not use up the TOTP code")
+}
+
+func TestLoginMFA_WrongCodeKeepsToken(t *testing.T) {
+	user := newMFAUser(t)
+	uc, users := newMFAUsecase(t, user)
+	users.On("UseRecoveryCode", mock.Anything, user.ID.Hex(), mock.Anything).Return(false, nil)
+	ctx := context.Background()
+
+	pending, err := uc.Login(ctx, "alice@example.com", "secret", "")
+	require.NoError(t, err)
+	_, err = uc.LoginMFA(ctx, pending.MFAToken, "wrong-code")
+	require.ErrorIs(t, err, usecase.ErrInvalidMFACode)
+	code, err := totp.GenerateCode(user.MFA.TOTPSecret, time.Now())
+	require.NoError(t, err)
+	_, err = uc.LoginMFA(ctx, pending.MFAToken, code)
+	assert.NoError(t, err)
+}
+
+func TestLoginMFA_BlocksRepeatedFailures(t *testing.T) {
+	user := newMFAUser(t)
+	uc, users := newMFAUsecase(t, user)
+	users.On("UseRecoveryCode", mock.Anything, user.ID.Hex(), mock.Anything).Return(false, nil)
+	ctx := context.Background()
+
+	pending, err := uc.Login(ctx, "alice@example.com", "secret", "")
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		_, err = uc.LoginMFA(ctx, pending.MFAToken, "wrong-code")
+		require.ErrorIs(t, err, usecase.ErrInvalidMFACode)
+	}
+	_, err = uc.LoginMFA(ctx, pending.MFAToken, "wrong-code")
+	assert.ErrorIs(t, err, usecase.ErrTooManyMFAAttempts)
+
+	code, err := totp.GenerateCode(user.MFA.TOTPSecret, time.Now())
+	require.NoError(t, err)
+	_, err = uc.LoginMFA(ctx, pending.MFAToken, code)
+	assert.ErrorIs(t, err, usecase.ErrTooManyMFAAttempts)
+	_, ok := apperr.RetryAfter(err)
+	assert.True(t, ok)
+}
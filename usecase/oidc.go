@@ -0,0 +1,446 @@
+package usecase
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/model"
+	"7-solutions/repository"
+	"7-solutions/utils"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// AuthorizationCodeTTL is how long a client has to redeem a code.
+	AuthorizationCodeTTL = time.Minute
+
+	purposeAuthorizationCode = "oauth_code"
+)
+
+// Scopes understood by the provider. openid is required.
+const (
+	ScopeOpenID  = "openid"
+	ScopeEmail   = "email"
+	ScopeProfile = "profile"
+)
+
+// TokenKeys signs and verifies tokens with the service's keys. keys.Manager
+// implements it.
+type TokenKeys interface {
+	utils.TokenSigner
+	utils.TokenVerifier
+	SigningAlgorithm() string
+}
+
+// OAuthError is answered with an RFC 6749 error code. It unwraps to an apperr
+// category for callers that only need that.
+type OAuthError struct {
+	Code string
+	err  *apperr.Error
+}
+
+func oauthError(code string, kind error, message string) *OAuthError {
+	return &OAuthError{Code: code, err: apperr.New(kind, message)}
+}
+
+func (e *OAuthError) Error() string {
+	return e.err.Error()
+}
+
+func (e *OAuthError) Unwrap() error {
+	return e.err
+}
+
+var (
+	ErrInvalidClient           = oauthError("invalid_client", apperr.ErrUnauthorized, "unknown client or invalid client credentials")
+	ErrInvalidRedirectURI      = oauthError("invalid_request", apperr.ErrValidation, "redirect_uri is not registered for the client")
+	ErrUnsupportedResponseType = oauthError("unsupported_response_type", apperr.ErrValidation, "only the code response type is supported")
+	ErrInvalidScope            = oauthError("invalid_scope", apperr.ErrValidation, "scope must include openid")
+	ErrPKCERequired            = oauthError("invalid_request", apperr.ErrValidation, "code_challenge with code_challenge_method S256 is required")
+	ErrUnsupportedGrantType    = oauthError("unsupported_grant_type", apperr.ErrValidation, "only the authorization_code grant is supported")
+	ErrInvalidGrant            = oauthError("invalid_grant", apperr.ErrValidation, "invalid, expired or already used authorization code")
+	ErrInvalidAccessToken      = oauthError("invalid_token", apperr.ErrUnauthorized, "invalid access token")
+
+	// ErrSymmetricSigningKey is returned by NewOIDCProvider for an HMAC
+	// signing key. Relying parties could only verify ID tokens with the
+	// secret that signs every user session.
+	ErrSymmetricSigningKey = errors.New("the OpenID Connect provider needs an asymmetric signing key")
+)
+
+// AuthorizationRequest holds the parameters of an authorization-code request.
+type AuthorizationRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// TokenRequest holds the parameters of a token request. ClientSecret is empty
+// for public clients.
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	ClientID     string
+	ClientSecret string
+	CodeVerifier string
+}
+
+type OIDCTokens struct {
+	AccessToken string
+	IDToken     string
+	Scope       string
+	ExpiresIn   time.Duration
+}
+
+// OIDCDiscovery is the OpenID Provider Metadata document.
+type OIDCDiscovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+// OIDCProvider lets other applications sign users in with the
+// authorization-code flow and PKCE. Users authenticate through
+// UserUsecase.Authenticate before a code is issued.
+type OIDCProvider interface {
+	Discovery() OIDCDiscovery
+	// RegisterClient returns the client and, for confidential clients, its
+	// secret. The secret is only stored hashed.
+	RegisterClient(ctx context.Context, name string, redirectURIs []string, public bool) (*model.OAuthClient, string, error)
+	// AuthorizationClient returns the client of an authorization request.
+	// Errors mean the user must not be redirected back to redirectURI.
+	AuthorizationClient(ctx context.Context, clientID, redirectURI string) (*model.OAuthClient, error)
+	// CheckAuthorization validates the rest of the request. Its errors are
+	// reported to the client's redirect URI.
+	CheckAuthorization(req AuthorizationRequest) error
+	// Authorize issues a code for user and returns the redirect URI carrying it.
+	Authorize(ctx context.Context, req AuthorizationRequest, user *model.User) (string, error)
+	// RedirectURI adds params, state and the issuer (RFC 9207) to the query
+	// of a registered redirect URI.
+	RedirectURI(redirectURI string, params url.Values, state string) string
+	Exchange(ctx context.Context, req TokenRequest) (*OIDCTokens, error)
+	UserInfo(ctx context.Context, accessToken string) (map[string]interface{}, error)
+}
+
+type oidcProvider struct {
+	issuer   string
+	users    repository.UsersRepository
+	clients  repository.OAuthClientRepository
+	codes    repository.OneTimeTokenStore
+	keys     TokenKeys
+	tokenTTL time.Duration
+}
+
+// NewOIDCProvider serves issuer, the public base URL of the service. ID and
+// access tokens are signed with keys and live for tokenTTL. keys must sign
+// with an asymmetric key, published in the JWKS.
+func NewOIDCProvider(issuer string, users repository.UsersRepository, clients repository.OAuthClientRepository, codes repository.OneTimeTokenStore, keys TokenKeys, tokenTTL time.Duration) (OIDCProvider, error) {
+	if strings.HasPrefix(keys.SigningAlgorithm(), "HS") {
+		return nil, ErrSymmetricSigningKey
+	}
+	return &oidcProvider{
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		users:    users,
+		clients:  clients,
+		codes:    codes,
+		keys:     keys,
+		tokenTTL: tokenTTL,
+	}, nil
+}
+
+func (p *oidcProvider) Discovery() OIDCDiscovery {
+	return OIDCDiscovery{
+		Issuer:                            p.issuer,
+		AuthorizationEndpoint:             p.issuer + "/authorize",
+		TokenEndpoint:                     p.issuer + "/token",
+		UserinfoEndpoint:                  p.issuer + "/userinfo",
+		JWKSURI:                           p.issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{p.keys.SigningAlgorithm()},
+		ScopesSupported:                   []string{ScopeOpenID, ScopeEmail, ScopeProfile},
+		ClaimsSupported:                   []string{"sub", "email", "email_verified", "name"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+	}
+}
+
+func (p *oidcProvider) RegisterClient(ctx context.Context, name string, redirectURIs []string, public bool) (*model.OAuthClient, string, error) {
+	var problems []apperr.FieldError
+	if strings.TrimSpace(name) == "" {
+		problems = append(problems, apperr.FieldError{Field: "name", Message: "is required"})
+	}
+	if len(redirectURIs) == 0 {
+		problems = append(problems, apperr.FieldError{Field: "redirect_uris", Message: "needs at least one URI"})
+	}
+	for _, uri := range redirectURIs {
+		if !validRedirectURI(uri) {
+			problems = append(problems, apperr.FieldError{Field: "redirect_uris", Message: uri + " must be an https URL, or http on localhost, without a fragment"})
+		}
+	}
+	if len(problems) > 0 {
+		return nil, "", apperr.Validation(problems...)
+	}
+
+	client := &model.OAuthClient{ID: uuid.New().String(), Name: name, RedirectURIs: redirectURIs}
+	var secret string
+	if !public {
+		var err error
+		secret, err = utils.GenerateOpaqueToken()
+		if err != nil {
+			return nil, "", err
+		}
+		client.SecretHash = utils.HashToken(secret)
+	}
+	created, err := p.clients.Create(ctx, client)
+	if err != nil {
+		return nil, "", err
+	}
+	return created, secret, nil
+}
+
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
+
+func (p *oidcProvider) AuthorizationClient(ctx context.Context, clientID, redirectURI string) (*model.OAuthClient, error) {
+	client, err := p.clients.GetByID(ctx, clientID)
+	if errors.Is(err, repository.ErrClientNotFound) {
+		return nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsRedirect(redirectURI) {
+		return nil, ErrInvalidRedirectURI
+	}
+	return client, nil
+}
+
+func (p *oidcProvider) CheckAuthorization(req AuthorizationRequest) error {
+	if req.ResponseType != "code" {
+		return ErrUnsupportedResponseType
+	}
+	if !hasScope(req.Scope, ScopeOpenID) {
+		return ErrInvalidScope
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return ErrPKCERequired
+	}
+	return nil
+}
+
+func (p *oidcProvider) Authorize(ctx context.Context, req AuthorizationRequest, user *model.User) (string, error) {
+	if _, err := p.AuthorizationClient(ctx, req.ClientID, req.RedirectURI); err != nil {
+		return "", err
+	}
+	if err := p.CheckAuthorization(req); err != nil {
+		return "", err
+	}
+
+	code, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	err = p.codes.SaveOneTimeToken(ctx, &model.OneTimeToken{
+		TokenHash: utils.HashToken(code),
+		Purpose:   purposeAuthorizationCode,
+		UserID:    user.ID.Hex(),
+		ExpiresAt: time.Now().Add(AuthorizationCodeTTL),
+		Data: map[string]string{
+			"client_id":      req.ClientID,
+			"redirect_uri":   req.RedirectURI,
+			"scope":          req.Scope,
+			"nonce":          req.Nonce,
+			"code_challenge": req.CodeChallenge,
+			"auth_time":      strconv.FormatInt(time.Now().Unix(), 10),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return p.RedirectURI(req.RedirectURI, url.Values{"code": {code}}, req.State), nil
+}
+
+func (p *oidcProvider) RedirectURI(redirectURI string, params url.Values, state string) string {
+	u, _ := url.Parse(redirectURI)
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	query.Set("iss", p.issuer)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (p *oidcProvider) Exchange(ctx context.Context, req TokenRequest) (*OIDCTokens, error) {
+	if req.GrantType != "authorization_code" {
+		return nil, ErrUnsupportedGrantType
+	}
+	client, err := p.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := p.codes.ConsumeOneTimeToken(ctx, purposeAuthorizationCode, utils.HashToken(req.Code))
+	if errors.Is(err, repository.ErrTokenNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.Data["client_id"] != client.ID || stored.Data["redirect_uri"] != req.RedirectURI ||
+		!verifyPKCE(req.CodeVerifier, stored.Data["code_challenge"]) {
+		return nil, ErrInvalidGrant
+	}
+	user, err := p.users.GetByID(ctx, stored.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	scope := stored.Data["scope"]
+	now := time.Now()
+	accessToken, err := p.keys.Sign(map[string]interface{}{
+		"iss":       p.issuer,
+		"sub":       user.ID.Hex(),
+		"client_id": client.ID,
+		"scope":     scope,
+		"jti":       uuid.New().String(),
+		"iat":       now.Unix(),
+		"exp":       now.Add(p.tokenTTL).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	idClaims := userClaims(user, scope)
+	idClaims["iss"] = p.issuer
+	idClaims["aud"] = client.ID
+	idClaims["iat"] = now.Unix()
+	idClaims["exp"] = now.Add(p.tokenTTL).Unix()
+	if authTime, err := strconv.ParseInt(stored.Data["auth_time"], 10, 64); err == nil {
+		idClaims["auth_time"] = authTime
+	}
+	if nonce := stored.Data["nonce"]; nonce != "" {
+		idClaims["nonce"] = nonce
+	}
+	idToken, err := p.keys.Sign(idClaims)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCTokens{AccessToken: accessToken, IDToken: idToken, Scope: scope, ExpiresIn: p.tokenTTL}, nil
+}
+
+// authenticateClient checks the secret of confidential clients. Public
+// clients prove themselves with PKCE alone.
+func (p *oidcProvider) authenticateClient(ctx context.Context, clientID, secret string) (*model.OAuthClient, error) {
+	client, err := p.clients.GetByID(ctx, clientID)
+	if errors.Is(err, repository.ErrClientNotFound) {
+		return nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+	if client.Public() {
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// verifyPKCE checks an S256 code verifier (RFC 7636) against its challenge.
+func verifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 || challenge == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+func (p *oidcProvider) UserInfo(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	claims, err := p.keys.Verify(accessToken)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+	issuer, _ := claims["iss"].(string)
+	clientID, _ := claims["client_id"].(string)
+	scope, _ := claims["scope"].(string)
+	userID, _ := claims["sub"].(string)
+	if issuer != p.issuer || clientID == "" || !hasScope(scope, ScopeOpenID) {
+		return nil, ErrInvalidAccessToken
+	}
+	user, err := p.users.GetByID(ctx, userID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrInvalidAccessToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return userClaims(user, scope), nil
+}
+
+// userClaims returns the standard claims about user that scope grants.
+func userClaims(user *model.User, scope string) map[string]interface{} {
+	claims := map[string]interface{}{"sub": user.ID.Hex()}
+	if hasScope(scope, ScopeEmail) {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.Verified
+	}
+	if hasScope(scope, ScopeProfile) {
+		claims["name"] = user.Name
+	}
+	return claims
+}
+
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
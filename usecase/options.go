@@ -0,0 +1,112 @@
+package usecase
+
+import (
+	"7-solutions/connector"
+	"7-solutions/mailer"
+	"7-solutions/policy"
+	"7-solutions/repository"
+	"7-solutions/utils"
+	"time"
+)
+
+// Option configures optional behaviour of the user usecase.
+type Option func(*userUsecase)
+
+// WithMailer sets where account emails are sent. The default only logs them.
+func WithMailer(m mailer.Mailer) Option {
+	return func(u *userUsecase) {
+		u.mailer = m
+	}
+}
+
+// WithBaseURL sets the public URL that links in emails point to, e.g.
+// "https://app.example.com". Without it, emails carry only the raw token.
+func WithBaseURL(url string) Option {
+	return func(u *userUsecase) {
+		u.baseURL = url
+	}
+}
+
+// WithPasswordPolicy sets the rules new passwords must satisfy on register,
+// reset and change.
+func WithPasswordPolicy(p policy.PasswordPolicy) Option {
+	return func(u *userUsecase) {
+		u.passwordPolicy = p
+	}
+}
+
+// WithPasswordHasher sets how new passwords are hashed. Stored hashes made
+// with other parameters are upgraded on the next successful login.
+func WithPasswordHasher(h utils.PasswordHasher) Option {
+	return func(u *userUsecase) {
+		u.hasher = h
+	}
+}
+
+// WithLockoutPolicy sets how failed logins are throttled and when accounts
+// are locked.
+func WithLockoutPolicy(p policy.LockoutPolicy) Option {
+	return func(u *userUsecase) {
+		u.lockout = p
+	}
+}
+
+// WithTOTPIssuer sets the issuer authenticator apps show next to the account.
+func WithTOTPIssuer(issuer string) Option {
+	return func(u *userUsecase) {
+		u.totpIssuer = issuer
+	}
+}
+
+// WithPasswordResetTTL sets how long password reset tokens stay valid.
+func WithPasswordResetTTL(ttl time.Duration) Option {
+	return func(u *userUsecase) {
+		u.resetTTL = ttl
+	}
+}
+
+// WithEmailVerification sets how long verification links stay valid and
+// whether Login is refused until the email is verified.
+func WithEmailVerification(ttl time.Duration, required bool) Option {
+	return func(u *userUsecase) {
+		u.verificationTTL = ttl
+		u.requireVerified = required
+	}
+}
+
+// WithTokenSigner sets how access tokens are signed, e.g. a keys.Manager
+// holding an asymmetric key. The default signs with HS256 and jwtSecret,
+// which stays in use for email and MFA tokens either way.
+func WithTokenSigner(s utils.TokenSigner) Option {
+	return func(u *userUsecase) {
+		u.signer = s
+	}
+}
+
+// WithConnectors sets the external identity providers users may sign in
+// with, keyed by their ID.
+func WithConnectors(connectors ...connector.Connector) Option {
+	return func(u *userUsecase) {
+		for _, c := range connectors {
+			u.connectors[c.ID()] = c
+		}
+	}
+}
+
+// WithAPIKeys sets the API keys LogoutAll deletes when asked to.
+func WithAPIKeys(keys repository.APIKeyRepository) Option {
+	return func(u *userUsecase) {
+		u.apiKeys = keys
+	}
+}
+
+// WithMailConcurrency sets how many emails may be sent off the request path
+// at once, DefaultMailConcurrency by default. Further emails are dropped
+// until one finishes.
+func WithMailConcurrency(n int) Option {
+	return func(u *userUsecase) {
+		if n > 0 {
+			u.mailSlots = make(chan struct{}, n)
+		}
+	}
+}
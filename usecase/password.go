@@ -0,0 +1,198 @@
+package usecase
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/mailer"
+	"7-solutions/model"
+	"7-solutions/repository"
+	"7-solutions/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	DefaultPasswordResetTTL = time.Hour
+
+	purposePasswordReset = "password_reset"
+	// passwordResetMailTimeout bounds issuing and mailing a reset token once
+	// the request has been answered.
+	passwordResetMailTimeout = 30 * time.Second
+)
+
+var ErrInvalidResetToken = apperr.New(apperr.ErrValidation, "invalid or expired reset token")
+
+// checkPassword reports each password policy violation as an error on field.
+func (u *userUsecase) checkPassword(field, password string, personal ...string) error {
+	problems := u.passwordPolicy.Check(password, personal...)
+	if len(problems) == 0 {
+		return nil
+	}
+	fields := make([]apperr.FieldError, 0, len(problems))
+	for _, problem := range problems {
+		fields = append(fields, apperr.FieldError{Field: field, Message: problem})
+	}
+	return apperr.Validation(fields...)
+}
+
+// verifyPassword checks password against the user's stored hash. A hash that
+// cannot be read counts as a mismatch, as does any password for users who
+// only sign in through an identity provider.
+func (u *userUsecase) verifyPassword(user *model.User, password string) bool {
+	if user.Password == "" {
+		return false
+	}
+	ok, err := u.hasher.Verify(password, user.Password)
+	if err != nil {
+		log.Printf("verifying password of user %s: %v", user.ID.Hex(), err)
+		return false
+	}
+	return ok
+}
+
+// upgradePasswordHash re-hashes a just-verified password whose stored hash
+// uses an outdated algorithm or parameters. Failures only delay the upgrade
+// to a later login.
+func (u *userUsecase) upgradePasswordHash(ctx context.Context, user *model.User, password string) {
+	if !u.hasher.NeedsRehash(user.Password) {
+		return
+	}
+	hashed, err := u.hasher.Hash(password)
+	if err == nil {
+		err = u.repo.UpdatePassword(ctx, user.ID.Hex(), hashed)
+	}
+	if err != nil {
+		log.Printf("upgrading password hash of user %s: %v", user.ID.Hex(), err)
+	}
+}
+
+// ForgotPassword emails a single-use reset token. It returns nil whether or
+// not the email is registered, so callers cannot use it to discover accounts.
+func (u *userUsecase) ForgotPassword(ctx context.Context, email string) error {
+	user, err := u.repo.GetByEmail(ctx, email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// The token is stored and mailed off the request path, so a registered
+	// email answers as quickly as an unknown one.
+	u.mailInBackground(ctx, "password reset", user, u.sendPasswordReset)
+	return nil
+}
+
+// sendPasswordReset issues a reset token for user and mails it. Failures are
+// only logged; the caller has already been answered.
+func (u *userUsecase) sendPasswordReset(ctx context.Context, user *model.User) {
+	ctx, cancel := context.WithTimeout(ctx, passwordResetMailTimeout)
+	defer cancel()
+
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		log.Printf("issuing password reset token for user %s: %v", user.ID.Hex(), err)
+		return
+	}
+	err = u.tokens.SaveOneTimeToken(ctx, &model.OneTimeToken{
+		TokenHash: utils.HashToken(token),
+		Purpose:   purposePasswordReset,
+		UserID:    user.ID.Hex(),
+		ExpiresAt: time.Now().Add(u.resetTTL),
+	})
+	if err != nil {
+		log.Printf("saving password reset token for user %s: %v", user.ID.Hex(), err)
+		return
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nReset your password with this token:\n\n%s\n", user.Name, token)
+	if u.baseURL != "" {
+		body += fmt.Sprintf("\nor open %s/reset-password?token=%s\n", u.baseURL, token)
+	}
+	body += fmt.Sprintf("\nIt expires in %s and works once. If you did not ask for a reset, ignore this email.\n", u.resetTTL)
+	err = u.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    body,
+	})
+	if err != nil {
+		log.Printf("sending password reset email to user %s: %v", user.ID.Hex(), err)
+	}
+}
+
+// ResetPassword redeems a reset token, sets the new password and ends every
+// session of the user. Other outstanding reset tokens are discarded.
+func (u *userUsecase) ResetPassword(ctx context.Context, token, newPassword string) error {
+	// Rules that need no user are checked before the token is spent.
+	if err := u.checkPassword("password", newPassword); err != nil {
+		return err
+	}
+	stored, err := u.tokens.ConsumeOneTimeToken(ctx, purposePasswordReset, utils.HashToken(token))
+	if errors.Is(err, repository.ErrTokenNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	user, err := u.repo.GetByID(ctx, stored.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	if err := u.checkPassword("password", newPassword, user.Email, user.Name); err != nil {
+		// Put the token back so the user can retry with another password.
+		if saveErr := u.tokens.SaveOneTimeToken(ctx, stored); saveErr != nil {
+			log.Printf("restoring password reset token of user %s: %v", stored.UserID, saveErr)
+		}
+		return err
+	}
+
+	hashed, err := u.hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	if err := u.repo.UpdatePassword(ctx, stored.UserID, hashed); err != nil {
+		return err
+	}
+
+	if err := u.tokens.DeleteUserOneTimeTokens(ctx, purposePasswordReset, stored.UserID); err != nil {
+		return err
+	}
+	return u.LogoutAll(ctx, stored.UserID, false)
+}
+
+func (u *userUsecase) ChangePassword(ctx context.Context, userID, sessionID, currentPassword, newPassword string) error {
+	user, err := u.repo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !u.verifyPassword(user, currentPassword) {
+		return apperr.Validation(apperr.FieldError{Field: "current_password", Message: "is incorrect"})
+	}
+	if err := u.checkPassword("new_password", newPassword, user.Email, user.Name); err != nil {
+		return err
+	}
+	if newPassword == currentPassword {
+		return apperr.Validation(apperr.FieldError{Field: "new_password", Message: "must differ from the current password"})
+	}
+
+	hashed, err := u.hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	if err := u.repo.UpdatePassword(ctx, userID, hashed); err != nil {
+		return err
+	}
+
+	if err := u.tokens.DeleteUserOneTimeTokens(ctx, purposePasswordReset, userID); err != nil {
+		return err
+	}
+	if err := u.tokens.RevokeUserTokens(ctx, userID, time.Now(), sessionID); err != nil {
+		return err
+	}
+	return u.tokens.RevokeUserRefreshTokens(ctx, userID, sessionID)
+}
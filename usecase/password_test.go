@@ -0,0 +1,236 @@
+package usecase_test
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/mailer"
+	"7-solutions/model"
+	"7-solutions/repository"
+	"7-solutions/usecase"
+	"7-solutions/utils"
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var resetTokenPattern = regexp.MustCompile(`(?m)^[\w-]{43}$`)
+
+func TestPasswordReset(t *testing.T) {
+	user := &model.User{ID: primitive.NewObjectID(), Name: "Alice", Email: "alice@example.com", Role: model.RoleUser}
+	users := new(MockUserRepo)
+	users.On("GetByEmail", mock.Anything, "alice@example.com").Return(user, nil)
+	users.On("GetByID", mock.Anything, user.ID.Hex()).Return(user, nil)
+	users.On("UpdatePassword", mock.Anything, user.ID.Hex(), mock.MatchedBy(func(hash string) bool {
+		ok, _ := testHasher.Verify("new-password", hash)
+		return ok
+	})).Return(nil).Once()
+	tokens := repository.NewMemoryTokenStore()
+	mail := mailer.NewMemoryMailer()
+	uc := usecase.NewUserUsecase(users, tokens, "secret", time.Minute, time.Hour,
+		usecase.WithMailer(mail),
+		usecase.WithPasswordHasher(testHasher),
+	)
+	ctx := context.Background()
+
+	require.NoError(t, uc.ForgotPassword(ctx, "alice@example.com"))
+	messages := waitForMail(t, mail, 1)
+	require.Len(t, messages, 1)
+	token := resetTokenPattern.FindString(messages[0].Body)
+	require.NotEmpty(t, token)
+
+	require.NoError(t, uc.ResetPassword(ctx, token, "new-password"))
+	assert.ErrorIs(t, uc.ResetPassword(ctx, token, "another-password"), usecase.ErrInvalidResetToken)
+	users.AssertExpectations(t)
+}
+
+func TestResetPassword_RevokesSessions(t *testing.T) {
+	hashed, err := testHasher.Hash("old-password")
+	require.NoError(t, err)
+	user := &model.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Password: hashed}
+	users := new(MockUserRepo)
+	users.On("GetByEmail", mock.Anything, "alice@example.com").Return(user, nil)
+	users.On("GetByID", mock.Anything, user.ID.Hex()).Return(user, nil)
+	users.On("UpdatePassword", mock.Anything, user.ID.Hex(), mock.Anything).Return(nil)
+	tokens := repository.NewMemoryTokenStore()
+	mail := mailer.NewMemoryMailer()
+	uc := usecase.NewUserUsecase(users, tokens, "secret", time.Minute, time.Hour,
+		usecase.WithMailer(mail),
+		usecase.WithPasswordHasher(testHasher),
+	)
+	ctx := context.Background()
+
+	session, err := uc.Login(ctx, "alice@example.com", "old-password", "")
+	require.NoError(t, err)
+	require.NoError(t, uc.ForgotPassword(ctx, "alice@example.com"))
+	require.NoError(t, uc.ForgotPassword(ctx, "alice@example.com"))
+	messages := waitForMail(t, mail, 2)
+	first := resetTokenPattern.FindString(messages[0].Body)
+	second := resetTokenPattern.FindString(messages[1].Body)
+
+	require.NoError(t, uc.ResetPassword(ctx, second, "new-password"))
+
+	_, err = uc.RefreshToken(ctx, session.RefreshToken)
+	assert.ErrorIs(t, err, usecase.ErrInvalidRefreshToken)
+	claims, err := utils.ValidateJWT(session.AccessToken, "secret")
+	require.NoError(t, err)
+	revoked, err := tokens.IsRevoked(ctx, claims["jti"].(string), user.ID.Hex(), claims["sid"].(string), utils.ClaimTime(claims, "iat"))
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	assert.ErrorIs(t, uc.ResetPassword(ctx, first, "other-password"), usecase.ErrInvalidResetToken)
+}
+
+func TestResetPassword_PolicyViolationKeepsToken(t *testing.T) {
+	user := &model.User{ID: primitive.NewObjectID(), Name: "Alice", Email: "alice@example.com"}
+	users := new(MockUserRepo)
+	users.On("GetByEmail", mock.Anything, "alice@example.com").Return(user, nil)
+	users.On("GetByID", mock.Anything, user.ID.Hex()).Return(user, nil)
+	users.On("UpdatePassword", mock.Anything, user.ID.Hex(), mock.Anything).Return(nil).Once()
+	mail := mailer.NewMemoryMailer()
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour,
+		usecase.WithMailer(mail),
+		usecase.WithPasswordHasher(testHasher),
+	)
+	ctx := context.Background()
+
+	require.NoError(t, uc.ForgotPassword(ctx, "alice@example.com"))
+	token := resetTokenPattern.FindString(waitForMail(t, mail, 1)[0].Body)
+
+	err := uc.ResetPassword(ctx, token, "short")
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+	err = uc.ResetPassword(ctx, token, "alice-rocks-2024")
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+
+	require.NoError(t, uc.ResetPassword(ctx, token, "correct horse battery"))
+	users.AssertExpectations(t)
+}
+
+// waitForMail waits for the reset emails ForgotPassword sends in the
+// background.
+func waitForMail(t *testing.T, mail *mailer.MemoryMailer, n int) []mailer.Message {
+	t.Helper()
+	require.Eventually(t, func() bool { return len(mail.Messages()) >= n }, time.Second, time.Millisecond)
+	return mail.Messages()
+}
+
+// gatedMailer holds every message until release is closed.
+type gatedMailer struct {
+	*mailer.MemoryMailer
+	release chan struct{}
+}
+
+func (m *gatedMailer) Send(ctx context.Context, msg mailer.Message) error {
+	select {
+	case <-m.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return m.MemoryMailer.Send(ctx, msg)
+}
+
+func TestForgotPassword_BoundsPendingMail(t *testing.T) {
+	user := &model.User{ID: primitive.NewObjectID(), Name: "Alice", Email: "alice@example.com"}
+	users := new(MockUserRepo)
+	users.On("GetByEmail", mock.Anything, "alice@example.com").Return(user, nil)
+	mail := &gatedMailer{MemoryMailer: mailer.NewMemoryMailer(), release: make(chan struct{})}
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour,
+		usecase.WithMailer(mail),
+		usecase.WithMailConcurrency(2),
+	)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, uc.ForgotPassword(ctx, "alice@example.com"))
+	}
+	close(mail.release)
+	waitForMail(t, mail.MemoryMailer, 2)
+	assert.Never(t, func() bool { return len(mail.Messages()) > 2 }, 50*time.Millisecond, time.Millisecond,
+		"requests beyond the limit are dropped")
+
+	require.NoError(t, uc.ForgotPassword(ctx, "alice@example.com"))
+	waitForMail(t, mail.MemoryMailer, 3)
+}
+
+func TestForgotPassword_UnknownEmail(t *testing.T) {
+	users := new(MockUserRepo)
+	users.On("GetByEmail", mock.Anything, "nobody@example.com").Return(nil, repository.ErrUserNotFound)
+	mail := mailer.NewMemoryMailer()
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour,
+		usecase.WithMailer(mail),
+		usecase.WithPasswordHasher(testHasher),
+	)
+
+	assert.NoError(t, uc.ForgotPassword(context.Background(), "nobody@example.com"))
+	assert.Empty(t, mail.Messages())
+}
+
+func TestChangePassword(t *testing.T) {
+	hashed, err := testHasher.Hash("old-password")
+	require.NoError(t, err)
+	user := &model.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Password: hashed}
+	users := new(MockUserRepo)
+	users.On("GetByEmail", mock.Anything, "alice@example.com").Return(user, nil)
+	users.On("GetByID", mock.Anything, user.ID.Hex()).Return(user, nil)
+	users.On("UpdatePassword", mock.Anything, user.ID.Hex(), mock.Anything).Return(nil).Once()
+	tokens := repository.NewMemoryTokenStore()
+	uc := usecase.NewUserUsecase(users, tokens, "secret", time.Minute, time.Hour, usecase.WithPasswordHasher(testHasher))
+	ctx := context.Background()
+
+	current, err := uc.Login(ctx, "alice@example.com", "old-password", "")
+	require.NoError(t, err)
+	other, err := uc.Login(ctx, "alice@example.com", "old-password", "")
+	require.NoError(t, err)
+	currentClaims, err := utils.ValidateJWT(current.AccessToken, "secret")
+	require.NoError(t, err)
+	sessionID := currentClaims["sid"].(string)
+
+	err = uc.ChangePassword(ctx, user.ID.Hex(), sessionID, "wrong-password", "new-password")
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+	err = uc.ChangePassword(ctx, user.ID.Hex(), sessionID, "old-password", "short")
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+	err = uc.ChangePassword(ctx, user.ID.Hex(), sessionID, "old-password", "old-password")
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+
+	require.NoError(t, uc.ChangePassword(ctx, user.ID.Hex(), sessionID, "old-password", "new-password"))
+
+	_, err = uc.RefreshToken(ctx, current.RefreshToken)
+	assert.NoError(t, err)
+	_, err = uc.RefreshToken(ctx, other.RefreshToken)
+	assert.ErrorIs(t, err, usecase.ErrInvalidRefreshToken)
+
+	revoked, err := tokens.IsRevoked(ctx, currentClaims["jti"].(string), user.ID.Hex(), sessionID, utils.ClaimTime(currentClaims, "iat"))
+	require.NoError(t, err)
+	assert.False(t, revoked)
+	otherClaims, err := utils.ValidateJWT(other.AccessToken, "secret")
+	require.NoError(t, err)
+	revoked, err = tokens.IsRevoked(ctx, otherClaims["jti"].(string), user.ID.Hex(), otherClaims["sid"].(string), utils.ClaimTime(otherClaims, "iat"))
+	require.NoError(t, err)
+	assert.True(t, revoked)
+	users.AssertExpectations(t)
+}
+
+func TestLogin_UpgradesOutdatedHash(t *testing.T) {
+	legacy, err := testHasher.Hash("old-password")
+	require.NoError(t, err)
+	argon := utils.NewArgon2idHasher(utils.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	user := &model.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Password: legacy}
+	users := new(MockUserRepo)
+	users.On("GetByEmail", mock.Anything, "alice@example.com").Return(user, nil)
+	users.On("UpdatePassword", mock.Anything, user.ID.Hex(), mock.MatchedBy(func(hash string) bool {
+		ok, _ := argon.Verify("old-password", hash)
+		return ok && !argon.NeedsRehash(hash)
+	})).Return(nil).Once()
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour, usecase.WithPasswordHasher(argon))
+	ctx := context.Background()
+
+	_, err = uc.Login(ctx, "alice@example.com", "wrong-password", "")
+	assert.ErrorIs(t, err, usecase.ErrInvalidCredentials)
+	_, err = uc.Login(ctx, "alice@example.com", "old-password", "")
+	require.NoError(t, err)
+	users.AssertExpectations(t)
+}
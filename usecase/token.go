@@ -0,0 +1,130 @@
+package usecase
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/model"
+	"7-solutions/policy"
+	"7-solutions/repository"
+	"7-solutions/utils"
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidRefreshToken = apperr.New(apperr.ErrUnauthorized, "invalid refresh token")
+	ErrRefreshTokenReused  = apperr.New(apperr.ErrUnauthorized, "refresh token reuse detected")
+)
+
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration
+	// MFAToken is set instead of the tokens when the user must still pass
+	// LoginMFA. ExpiresIn is then its lifetime.
+	MFAToken string
+}
+
+// RefreshToken rotates a refresh token: the presented token is consumed and a
+// new pair in the same family is issued. Presenting an already rotated token
+// means it leaked, so the whole family is revoked.
+func (u *userUsecase) RefreshToken(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	hash := utils.HashToken(refreshToken)
+	stored, err := u.tokens.GetRefreshToken(ctx, hash)
+	if err != nil {
+		if errors.Is(err, repository.ErrTokenNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	if stored.Used {
+		return nil, u.revokeFamily(ctx, stored.FamilyID)
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	ok, err := u.tokens.MarkRefreshTokenUsed(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, u.revokeFamily(ctx, stored.FamilyID)
+	}
+
+	user, err := u.repo.GetByID(ctx, stored.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u.issueTokens(ctx, user, stored.FamilyID)
+}
+
+// Logout revokes the presented access token and the refresh-token family of
+// its session.
+func (u *userUsecase) Logout(ctx context.Context, userID, jti, sessionID string, expiresAt time.Time) error {
+	if err := u.tokens.RevokeToken(ctx, jti, expiresAt); err != nil {
+		return err
+	}
+	if sessionID == "" {
+		return nil
+	}
+	return u.tokens.RevokeRefreshFamily(ctx, sessionID)
+}
+
+// LogoutAll revokes every access and refresh token the user holds, and their
+// API keys too when revokeAPIKeys is set.
+func (u *userUsecase) LogoutAll(ctx context.Context, userID string, revokeAPIKeys bool) error {
+	if err := u.tokens.RevokeUserTokens(ctx, userID, time.Now(), ""); err != nil {
+		return err
+	}
+	if err := u.tokens.RevokeUserRefreshTokens(ctx, userID, ""); err != nil {
+		return err
+	}
+	if !revokeAPIKeys || u.apiKeys == nil {
+		return nil
+	}
+	return u.apiKeys.DeleteByUser(ctx, userID)
+}
+
+func (u *userUsecase) revokeFamily(ctx context.Context, familyID string) error {
+	if err := u.tokens.RevokeRefreshFamily(ctx, familyID); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+func (u *userUsecase) issueTokens(ctx context.Context, user *model.User, familyID string) (*TokenPair, error) {
+	role := user.Role
+	if role == "" {
+		role = model.RoleUser
+	}
+	userID := user.ID.Hex()
+	accessToken, err := utils.GenerateJWT(userID, string(role), familyID, policy.RoleScopes(role), u.signer, u.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	err = u.tokens.SaveRefreshToken(ctx, &model.RefreshToken{
+		TokenHash: utils.HashToken(refreshToken),
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(u.refreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    u.accessTTL,
+	}, nil
+}
@@ -0,0 +1,97 @@
+package usecase_test
+
+import (
+	"7-solutions/model"
+	"7-solutions/repository"
+	"7-solutions/usecase"
+	"7-solutions/utils"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newTokenUsecase() (usecase.UserUsecase, repository.TokenStore, string) {
+	userID := primitive.NewObjectID()
+	users := new(MockUserRepo)
+	users.On("GetByID", mock.Anything, userID.Hex()).Return(&model.User{ID: userID}, nil)
+
+	tokens := repository.NewMemoryTokenStore()
+	uc := usecase.NewUserUsecase(users, tokens, "secret", time.Minute, time.Hour)
+	return uc, tokens, userID.Hex()
+}
+
+func seedRefreshToken(t *testing.T, tokens repository.TokenStore, userID, raw string, expiresAt time.Time) {
+	require.NoError(t, tokens.SaveRefreshToken(context.Background(), &model.RefreshToken{
+		TokenHash: utils.HashToken(raw),
+		FamilyID:  "family-1",
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	}))
+}
+
+func TestRefreshToken_Rotates(t *testing.T) {
+	uc, tokens, userID := newTokenUsecase()
+	seedRefreshToken(t, tokens, userID, "first", time.Now().Add(time.Hour))
+
+	pair, err := uc.RefreshToken(context.Background(), "first")
+	require.NoError(t, err)
+	assert.NotEmpty(t, pair.AccessToken)
+	assert.NotEqual(t, "first", pair.RefreshToken)
+	assert.Equal(t, time.Minute, pair.ExpiresIn)
+
+	claims, err := utils.ValidateJWT(pair.AccessToken, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, userID, claims["user_id"])
+
+	rotated, err := tokens.GetRefreshToken(context.Background(), utils.HashToken(pair.RefreshToken))
+	require.NoError(t, err)
+	assert.Equal(t, "family-1", rotated.FamilyID)
+	assert.False(t, rotated.Used)
+}
+
+func TestRefreshToken_ReuseRevokesFamily(t *testing.T) {
+	uc, tokens, userID := newTokenUsecase()
+	seedRefreshToken(t, tokens, userID, "first", time.Now().Add(time.Hour))
+
+	pair, err := uc.RefreshToken(context.Background(), "first")
+	require.NoError(t, err)
+
+	_, err = uc.RefreshToken(context.Background(), "first")
+	assert.ErrorIs(t, err, usecase.ErrRefreshTokenReused)
+
+	_, err = uc.RefreshToken(context.Background(), pair.RefreshToken)
+	assert.ErrorIs(t, err, usecase.ErrInvalidRefreshToken)
+}
+
+func TestRefreshToken_Invalid(t *testing.T) {
+	uc, tokens, userID := newTokenUsecase()
+	seedRefreshToken(t, tokens, userID, "expired", time.Now().Add(-time.Minute))
+
+	_, err := uc.RefreshToken(context.Background(), "expired")
+	assert.ErrorIs(t, err, usecase.ErrInvalidRefreshToken)
+
+	_, err = uc.RefreshToken(context.Background(), "unknown")
+	assert.ErrorIs(t, err, usecase.ErrInvalidRefreshToken)
+}
+
+func TestLogoutAll_RevokesEverySession(t *testing.T) {
+	userID := primitive.NewObjectID().Hex()
+	tokens := repository.NewMemoryTokenStore()
+	uc := usecase.NewUserUsecase(new(MockUserRepo), tokens, "secret", time.Minute, time.Hour)
+	seedRefreshToken(t, tokens, userID, "first", time.Now().Add(time.Hour))
+
+	issuedAt := time.Now()
+	require.NoError(t, uc.LogoutAll(context.Background(), userID, false))
+
+	revoked, err := tokens.IsRevoked(context.Background(), "any-jti", userID, "any-session", issuedAt)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	_, err = uc.RefreshToken(context.Background(), "first")
+	assert.ErrorIs(t, err, usecase.ErrInvalidRefreshToken)
+}
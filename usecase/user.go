@@ -1,74 +1,236 @@
 package usecase
 
 import (
+	"7-solutions/apperr"
+	"7-solutions/connector"
+	"7-solutions/keys"
+	"7-solutions/mailer"
 	"7-solutions/model"
+	"7-solutions/policy"
 	"7-solutions/repository"
 	"7-solutions/utils"
 	"context"
 	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrInvalidCredentials = apperr.New(apperr.ErrUnauthorized, "invalid credentials")
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
 )
 
 type UserUsecase interface {
-	Register(ctx context.Context, name, email, password string) error
-	Login(ctx context.Context, email, password string) (string, error)
+	Register(ctx context.Context, name, email, password string) (*model.User, error)
+	// Login is throttled per email and per clientIP, which may be empty when
+	// unknown.
+	Login(ctx context.Context, email, password, clientIP string) (*TokenPair, error)
+	// Authenticate checks credentials like Login without starting a session.
+	// Users with two-factor login come back with an MFA token for
+	// AuthenticateMFA as well.
+	Authenticate(ctx context.Context, email, password, clientIP string) (*model.User, string, error)
+	AuthenticateMFA(ctx context.Context, mfaToken, code string) (*model.User, error)
+	RefreshToken(ctx context.Context, refreshToken string) (*TokenPair, error)
+	Logout(ctx context.Context, userID, jti, sessionID string, expiresAt time.Time) error
+	// LogoutAll ends every session of the user. API keys keep working unless
+	// revokeAPIKeys is set.
+	LogoutAll(ctx context.Context, userID string, revokeAPIKeys bool) error
 	GetUser(ctx context.Context, id string) (*model.User, error)
-	ListUsers(ctx context.Context) ([]model.User, error)
+	ListUsers(ctx context.Context, query model.UserQuery) (*model.UserPage, error)
+	// WatchUsers streams user changes until the returned cancel func is called.
+	WatchUsers(ctx context.Context) (<-chan model.UserEvent, func())
 	UpdateUser(ctx context.Context, id string, name, email string) error
 	DeleteUser(ctx context.Context, id string) error
 	CountUsers(ctx context.Context) (int64, error)
+	VerifyEmail(ctx context.Context, token string) error
+	ResendVerification(ctx context.Context, email string) error
+	// ForgotPassword mails a reset token in the background and answers the
+	// same whether or not email is registered.
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, newPassword string) error
+	// ChangePassword keeps sessionID signed in and ends every other session.
+	ChangePassword(ctx context.Context, userID, sessionID, currentPassword, newPassword string) error
+	UnlockUser(ctx context.Context, id string) error
+	EnrollTOTP(ctx context.Context, userID string) (*TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error)
+	// DisableTOTP checks password, or code for users without a password.
+	DisableTOTP(ctx context.Context, userID, password, code string) error
+	LoginMFA(ctx context.Context, mfaToken, code string) (*TokenPair, error)
+	// StartExternalLogin returns where to send the user to sign in at
+	// provider, and the state the callback must present.
+	StartExternalLogin(ctx context.Context, provider string) (authURL, state string, err error)
+	// FinishExternalLogin signs in, links or creates the user the provider
+	// returned with code. Users with two-factor login get an MFA token.
+	FinishExternalLogin(ctx context.Context, provider, state, code string) (*TokenPair, error)
 }
 
 type userUsecase struct {
-	repo      repository.UsersRepository
-	jwtSecret string
+	repo       repository.UsersRepository
+	tokens     repository.TokenStore
+	jwtSecret  string
+	signer     utils.TokenSigner
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	events     *userEventBroker
+
+	mailer          mailer.Mailer
+	baseURL         string
+	verificationTTL time.Duration
+	requireVerified bool
+	resetTTL        time.Duration
+	passwordPolicy  policy.PasswordPolicy
+	hasher          utils.PasswordHasher
+	lockout         policy.LockoutPolicy
+	totpIssuer      string
+	connectors      map[string]connector.Connector
+	apiKeys         repository.APIKeyRepository
+	// mailSlots holds one token per email being sent off the request path.
+	mailSlots chan struct{}
 }
 
-func NewUserUsecase(repo repository.UsersRepository, jwtSecret string) UserUsecase {
-	return &userUsecase{repo: repo, jwtSecret: jwtSecret}
+func NewUserUsecase(repo repository.UsersRepository, tokens repository.TokenStore, jwtSecret string, accessTTL, refreshTTL time.Duration, opts ...Option) UserUsecase {
+	u := &userUsecase{
+		repo:            repo,
+		tokens:          tokens,
+		jwtSecret:       jwtSecret,
+		signer:          keys.NewHMACManager(jwtSecret),
+		accessTTL:       accessTTL,
+		refreshTTL:      refreshTTL,
+		events:          newUserEventBroker(),
+		mailer:          mailer.NewLogMailer(),
+		verificationTTL: DefaultVerificationTTL,
+		resetTTL:        DefaultPasswordResetTTL,
+		passwordPolicy:  policy.DefaultPasswordPolicy(),
+		hasher:          utils.NewBcryptHasher(utils.DefaultBcryptCost),
+		lockout:         policy.DefaultLockoutPolicy(),
+		totpIssuer:      DefaultTOTPIssuer,
+		connectors:      map[string]connector.Connector{},
+		mailSlots:       make(chan struct{}, DefaultMailConcurrency),
+	}
+	for _, opt := range opts {
+		opt(u)
+	}
+	return u
 }
 
-func (u *userUsecase) Register(ctx context.Context, name, email, password string) error {
-	hashed, err := utils.HashPassword(password)
+func (u *userUsecase) Register(ctx context.Context, name, email, password string) (*model.User, error) {
+	if err := u.checkPassword("password", password, email, name); err != nil {
+		return nil, err
+	}
+	hashed, err := u.hasher.Hash(password)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	user := &model.User{
+	user, err := u.repo.Create(ctx, &model.User{
 		Name:     name,
 		Email:    email,
 		Password: hashed,
+		Role:     model.RoleUser,
+	})
+	if err != nil {
+		return nil, err
 	}
-	return u.repo.Create(ctx, user)
+	u.events.publish(model.UserCreated, *user)
+	if err := u.sendVerification(ctx, user); err != nil {
+		log.Printf("sending verification email to user %s: %v", user.ID.Hex(), err)
+	}
+	return user, nil
 }
 
-func (u *userUsecase) Login(ctx context.Context, email, password string) (string, error) {
-	user, err := u.repo.GetByEmail(ctx, email)
+func (u *userUsecase) Login(ctx context.Context, email, password, clientIP string) (*TokenPair, error) {
+	user, mfaToken, err := u.Authenticate(ctx, email, password, clientIP)
 	if err != nil {
-		return "", errors.New("invalid credentials")
+		return nil, err
+	}
+	if mfaToken != "" {
+		return &TokenPair{MFAToken: mfaToken, ExpiresIn: MFATokenTTL}, nil
+	}
+	return u.issueTokens(ctx, user, uuid.New().String())
+}
+
+func (u *userUsecase) Authenticate(ctx context.Context, email, password, clientIP string) (*model.User, string, error) {
+	if err := u.checkLoginAllowed(ctx, email, clientIP); err != nil {
+		return nil, "", err
 	}
-	if !utils.CheckPasswordHash(password, user.Password) {
-		return "", errors.New("invalid credentials")
+	user, err := u.repo.GetByEmail(ctx, email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		u.recordLoginFailure(ctx, email, clientIP)
+		return nil, "", ErrInvalidCredentials
 	}
-	token, err := utils.GenerateJWT(user.ID.Hex(), u.jwtSecret)
 	if err != nil {
-		return "", err
+		return nil, "", err
+	}
+	if !u.verifyPassword(user, password) {
+		u.recordLoginFailure(ctx, email, clientIP)
+		return nil, "", ErrInvalidCredentials
+	}
+	// The IP counter is kept, so one valid account cannot clear it.
+	if err := u.tokens.ResetAttempts(ctx, loginEmailKey(email)); err != nil {
+		return nil, "", err
+	}
+	u.upgradePasswordHash(ctx, user, password)
+	if u.requireVerified && !user.Verified {
+		return nil, "", ErrEmailNotVerified
 	}
-	return token, nil
+	if user.MFA.TOTPEnabled {
+		mfaToken, err := u.issueMFAToken(user)
+		if err != nil {
+			return nil, "", err
+		}
+		return user, mfaToken, nil
+	}
+	return user, "", nil
 }
 
 func (u *userUsecase) GetUser(ctx context.Context, id string) (*model.User, error) {
 	return u.repo.GetByID(ctx, id)
 }
 
-func (u *userUsecase) ListUsers(ctx context.Context) ([]model.User, error) {
-	return u.repo.List(ctx)
+func (u *userUsecase) ListUsers(ctx context.Context, query model.UserQuery) (*model.UserPage, error) {
+	if query.Limit <= 0 {
+		query.Limit = DefaultPageSize
+	}
+	if query.Limit > MaxPageSize {
+		query.Limit = MaxPageSize
+	}
+	return u.repo.List(ctx, query)
+}
+
+func (u *userUsecase) WatchUsers(ctx context.Context) (<-chan model.UserEvent, func()) {
+	return u.events.subscribe()
 }
 
 func (u *userUsecase) UpdateUser(ctx context.Context, id string, name, email string) error {
-	return u.repo.Update(ctx, id, &model.User{Name: name, Email: email})
+	if err := u.repo.Update(ctx, id, &model.User{Name: name, Email: email}); err != nil {
+		return err
+	}
+	user, err := u.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil
+	}
+	u.events.publish(model.UserUpdated, *user)
+	// A new address has to be verified again, which needs a fresh token when
+	// logins wait for verification.
+	if email != "" && !user.Verified {
+		if err := u.sendVerification(ctx, user); err != nil {
+			log.Printf("sending verification email to user %s: %v", user.ID.Hex(), err)
+		}
+	}
+	return nil
 }
 
 func (u *userUsecase) DeleteUser(ctx context.Context, id string) error {
-	return u.repo.Delete(ctx, id)
+	if err := u.repo.Delete(ctx, id); err != nil {
+		return err
+	}
+	objID, _ := primitive.ObjectIDFromHex(id)
+	u.events.publish(model.UserDeleted, model.User{ID: objID})
+	return nil
 }
 
 func (u *userUsecase) CountUsers(ctx context.Context) (int64, error) {
@@ -0,0 +1,168 @@
+package usecase_test
+
+import (
+	"7-solutions/model"
+	"7-solutions/repository"
+	"7-solutions/usecase"
+	"7-solutions/utils"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testHasher keeps password hashing cheap in tests.
+var testHasher = utils.NewBcryptHasher(bcrypt.MinCost)
+
+type MockUserRepo struct {
+	mock.Mock
+}
+
+func (m *MockUserRepo) Create(ctx context.Context, user *model.User) (*model.User, error) {
+	args := m.Called(ctx, user)
+	created, _ := args.Get(0).(*model.User)
+	return created, args.Error(1)
+}
+
+func (m *MockUserRepo) MarkEmailVerified(ctx context.Context, id, email string) error {
+	return m.Called(ctx, id, email).Error(0)
+}
+
+func (m *MockUserRepo) UpdatePassword(ctx context.Context, id, passwordHash string) error {
+	return m.Called(ctx, id, passwordHash).Error(0)
+}
+
+func (m *MockUserRepo) UpdateMFA(ctx context.Context, id string, mfa model.MFA) error {
+	return m.Called(ctx, id, mfa).Error(0)
+}
+
+func (m *MockUserRepo) UseRecoveryCode(ctx context.Context, id, codeHash string) (bool, error) {
+	args := m.Called(ctx, id, codeHash)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepo) GetByID(ctx context.Context, id string) (*model.User, error) {
+	args := m.Called(ctx, id)
+	user, _ := args.Get(0).(*model.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserRepo) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	args := m.Called(ctx, email)
+	user, _ := args.Get(0).(*model.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserRepo) GetByIdentity(ctx context.Context, provider, subject string) (*model.User, error) {
+	args := m.Called(ctx, provider, subject)
+	user, _ := args.Get(0).(*model.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserRepo) LinkIdentity(ctx context.Context, id string, identity model.Identity) error {
+	return m.Called(ctx, id, identity).Error(0)
+}
+
+func (m *MockUserRepo) Update(ctx context.Context, id string, user *model.User) error {
+	return m.Called(ctx, id, user).Error(0)
+}
+
+func (m *MockUserRepo) Delete(ctx context.Context, id string) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func (m *MockUserRepo) List(ctx context.Context, query model.UserQuery) (*model.UserPage, error) {
+	args := m.Called(ctx, query)
+	page, _ := args.Get(0).(*model.UserPage)
+	return page, args.Error(1)
+}
+
+func (m *MockUserRepo) Count(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func TestListUsers_ClampsLimit(t *testing.T) {
+	users := new(MockUserRepo)
+	users.On("List", mock.Anything, model.UserQuery{Limit: usecase.DefaultPageSize}).Return(&model.UserPage{}, nil)
+	users.On("List", mock.Anything, model.UserQuery{Limit: usecase.MaxPageSize}).Return(&model.UserPage{}, nil)
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour)
+
+	_, err := uc.ListUsers(context.Background(), model.UserQuery{})
+	require.NoError(t, err)
+	_, err = uc.ListUsers(context.Background(), model.UserQuery{Limit: 1000})
+	require.NoError(t, err)
+	users.AssertExpectations(t)
+}
+
+func TestRegister_ReturnsCreatedUser(t *testing.T) {
+	stored := &model.User{ID: primitive.NewObjectID(), Name: "Alice", Email: "alice@example.com", Role: model.RoleUser, CreatedAt: time.Now()}
+	users := new(MockUserRepo)
+	users.On("Create", mock.Anything, mock.MatchedBy(func(u *model.User) bool {
+		return u.Email == "alice@example.com" && u.Role == model.RoleUser && u.Password != "correct horse battery"
+	})).Return(stored, nil)
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour, usecase.WithPasswordHasher(testHasher))
+
+	events, cancel := uc.WatchUsers(context.Background())
+	defer cancel()
+
+	user, err := uc.Register(context.Background(), "Alice", "alice@example.com", "correct horse battery")
+	require.NoError(t, err)
+	assert.Equal(t, stored, user)
+	event := receiveEvent(t, events)
+	assert.Equal(t, model.UserCreated, event.Type)
+	assert.Equal(t, stored.ID, event.User.ID)
+}
+
+func receiveEvent(t *testing.T, events <-chan model.UserEvent) model.UserEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no user event received")
+		return model.UserEvent{}
+	}
+}
+
+func TestWatchUsers_UpdateAndDelete(t *testing.T) {
+	id := primitive.NewObjectID()
+	users := new(MockUserRepo)
+	users.On("Update", mock.Anything, id.Hex(), mock.Anything).Return(nil)
+	users.On("GetByID", mock.Anything, id.Hex()).Return(&model.User{ID: id, Name: "Alice"}, nil)
+	users.On("Delete", mock.Anything, id.Hex()).Return(nil)
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour)
+
+	events, cancel := uc.WatchUsers(context.Background())
+	defer cancel()
+
+	require.NoError(t, uc.UpdateUser(context.Background(), id.Hex(), "Alice", "alice@example.com"))
+	event := receiveEvent(t, events)
+	assert.Equal(t, model.UserUpdated, event.Type)
+	assert.Equal(t, "Alice", event.User.Name)
+
+	require.NoError(t, uc.DeleteUser(context.Background(), id.Hex()))
+	event = receiveEvent(t, events)
+	assert.Equal(t, model.UserDeleted, event.Type)
+	assert.Equal(t, id, event.User.ID)
+}
+
+func TestWatchUsers_FailedWritesPublishNothing(t *testing.T) {
+	users := new(MockUserRepo)
+	users.On("Delete", mock.Anything, "missing").Return(repository.ErrUserNotFound)
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour)
+
+	events, cancel := uc.WatchUsers(context.Background())
+	defer cancel()
+
+	assert.ErrorIs(t, uc.DeleteUser(context.Background(), "missing"), repository.ErrUserNotFound)
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %v", event.Type)
+	default:
+	}
+}
@@ -0,0 +1,107 @@
+package usecase
+
+import (
+	"7-solutions/apperr"
+	"7-solutions/mailer"
+	"7-solutions/model"
+	"7-solutions/repository"
+	"7-solutions/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	DefaultVerificationTTL = 24 * time.Hour
+	// DefaultMailConcurrency caps the emails sent off the request path at
+	// once.
+	DefaultMailConcurrency = 16
+
+	// verificationMailTimeout bounds mailing a verification token once the
+	// request has been answered.
+	verificationMailTimeout = 30 * time.Second
+)
+
+var (
+	ErrInvalidVerificationToken = apperr.New(apperr.ErrValidation, "invalid or expired verification token")
+	ErrEmailNotVerified         = apperr.New(apperr.ErrForbidden, "email not verified")
+)
+
+// VerifyEmail marks the user in a verification token as verified. Tokens are
+// signed and expire but are not single-use; verifying twice is harmless.
+func (u *userUsecase) VerifyEmail(ctx context.Context, token string) error {
+	userID, email, err := utils.ValidateEmailToken(token, utils.PurposeVerifyEmail, u.jwtSecret)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+	err = u.repo.MarkEmailVerified(ctx, userID, email)
+	if errors.Is(err, repository.ErrUserNotFound) || errors.Is(err, repository.ErrInvalidUserID) {
+		return ErrInvalidVerificationToken
+	}
+	return err
+}
+
+// ResendVerification emails a new verification token. It succeeds whether or
+// not the email belongs to an unverified user, so callers cannot use it to
+// discover accounts. The mail is sent off the request path, so an unverified
+// account answers as quickly as an unknown email.
+func (u *userUsecase) ResendVerification(ctx context.Context, email string) error {
+	user, err := u.repo.GetByEmail(ctx, email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.Verified {
+		return nil
+	}
+	u.mailInBackground(ctx, "verification", user, u.mailVerification)
+	return nil
+}
+
+// mailVerification sends a verification token to user in the background.
+// Failures are only logged; the caller has already been answered.
+func (u *userUsecase) mailVerification(ctx context.Context, user *model.User) {
+	ctx, cancel := context.WithTimeout(ctx, verificationMailTimeout)
+	defer cancel()
+	if err := u.sendVerification(ctx, user); err != nil {
+		log.Printf("sending verification email to user %s: %v", user.ID.Hex(), err)
+	}
+}
+
+// mailInBackground runs send for user off the request path. At most
+// cap(u.mailSlots) sends run at once; beyond that the email is dropped and
+// logged, so a burst of requests against a slow mail server cannot pile up
+// goroutines and connections.
+func (u *userUsecase) mailInBackground(ctx context.Context, kind string, user *model.User, send func(context.Context, *model.User)) {
+	select {
+	case u.mailSlots <- struct{}{}:
+	default:
+		log.Printf("dropping %s email to user %s: %d emails already being sent", kind, user.ID.Hex(), cap(u.mailSlots))
+		return
+	}
+	go func() {
+		defer func() { <-u.mailSlots }()
+		send(context.WithoutCancel(ctx), user)
+	}()
+}
+
+func (u *userUsecase) sendVerification(ctx context.Context, user *model.User) error {
+	token, err := utils.GenerateEmailToken(user.ID.Hex(), user.Email, utils.PurposeVerifyEmail, u.jwtSecret, u.verificationTTL)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Hi %s,\n\nConfirm your email address with this token:\n\n%s\n", user.Name, token)
+	if u.baseURL != "" {
+		body += fmt.Sprintf("\nor open %s/verify-email?token=%s\n", u.baseURL, token)
+	}
+	body += fmt.Sprintf("\nIt expires in %s.\n", u.verificationTTL)
+	return u.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    body,
+	})
+}
@@ -0,0 +1,139 @@
+package usecase_test
+
+import (
+	"7-solutions/keys"
+	"7-solutions/mailer"
+	"7-solutions/model"
+	"7-solutions/repository"
+	"7-solutions/usecase"
+	"7-solutions/utils"
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var tokenPattern = regexp.MustCompile(`[\w-]+\.[\w-]+\.[\w-]+`)
+
+func sentToken(t *testing.T, mail *mailer.MemoryMailer) string {
+	messages := mail.Messages()
+	require.NotEmpty(t, messages, "no email sent")
+	token := tokenPattern.FindString(messages[len(messages)-1].Body)
+	require.NotEmpty(t, token, "no token in email")
+	return token
+}
+
+func TestRegister_SendsVerificationEmail(t *testing.T) {
+	stored := &model.User{ID: primitive.NewObjectID(), Name: "Alice", Email: "alice@example.com"}
+	users := new(MockUserRepo)
+	users.On("Create", mock.Anything, mock.Anything).Return(stored, nil)
+	users.On("MarkEmailVerified", mock.Anything, stored.ID.Hex(), "alice@example.com").Return(nil)
+	mail := mailer.NewMemoryMailer()
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour,
+		usecase.WithMailer(mail),
+		usecase.WithBaseURL("https://app.example.com"),
+		usecase.WithPasswordHasher(testHasher),
+	)
+
+	_, err := uc.Register(context.Background(), "Alice", "alice@example.com", "correct horse battery")
+	require.NoError(t, err)
+
+	messages := mail.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "alice@example.com", messages[0].To)
+	assert.Contains(t, messages[0].Body, "https://app.example.com/verify-email?token=")
+
+	require.NoError(t, uc.VerifyEmail(context.Background(), sentToken(t, mail)))
+	users.AssertExpectations(t)
+}
+
+func TestVerifyEmail_RejectsInvalidTokens(t *testing.T) {
+	uc := usecase.NewUserUsecase(new(MockUserRepo), repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour)
+	id := primitive.NewObjectID().Hex()
+
+	expired, err := utils.GenerateEmailToken(id, "alice@example.com", utils.PurposeVerifyEmail, "secret", -time.Minute)
+	require.NoError(t, err)
+	access, err := utils.GenerateJWT(id, "user", "session", nil, keys.NewHMACManager("secret"), time.Minute)
+	require.NoError(t, err)
+
+	for _, token := range []string{"garbage", expired, access} {
+		assert.ErrorIs(t, uc.VerifyEmail(context.Background(), token), usecase.ErrInvalidVerificationToken)
+	}
+}
+
+func TestVerifyEmail_EmailChangedSinceSent(t *testing.T) {
+	id := primitive.NewObjectID().Hex()
+	users := new(MockUserRepo)
+	users.On("MarkEmailVerified", mock.Anything, id, "old@example.com").Return(repository.ErrUserNotFound)
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour)
+
+	token, err := utils.GenerateEmailToken(id, "old@example.com", utils.PurposeVerifyEmail, "secret", time.Hour)
+	require.NoError(t, err)
+	assert.ErrorIs(t, uc.VerifyEmail(context.Background(), token), usecase.ErrInvalidVerificationToken)
+}
+
+func TestLogin_RequiresVerifiedEmail(t *testing.T) {
+	hashed, err := testHasher.Hash("secret")
+	require.NoError(t, err)
+	users := new(MockUserRepo)
+	users.On("GetByEmail", mock.Anything, "alice@example.com").
+		Return(&model.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Password: hashed}, nil)
+	users.On("GetByEmail", mock.Anything, "bob@example.com").
+		Return(&model.User{ID: primitive.NewObjectID(), Email: "bob@example.com", Password: hashed, Verified: true}, nil)
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour,
+		usecase.WithEmailVerification(time.Hour, true),
+		usecase.WithPasswordHasher(testHasher),
+	)
+
+	_, err = uc.Login(context.Background(), "alice@example.com", "secret", "")
+	assert.ErrorIs(t, err, usecase.ErrEmailNotVerified)
+
+	_, err = uc.Login(context.Background(), "alice@example.com", "wrong", "")
+	assert.ErrorIs(t, err, usecase.ErrInvalidCredentials)
+
+	_, err = uc.Login(context.Background(), "bob@example.com", "secret", "")
+	assert.NoError(t, err)
+}
+
+func TestResendVerification(t *testing.T) {
+	users := new(MockUserRepo)
+	users.On("GetByEmail", mock.Anything, "alice@example.com").
+		Return(&model.User{ID: primitive.NewObjectID(), Email: "alice@example.com"}, nil)
+	users.On("GetByEmail", mock.Anything, "bob@example.com").
+		Return(&model.User{ID: primitive.NewObjectID(), Email: "bob@example.com", Verified: true}, nil)
+	users.On("GetByEmail", mock.Anything, "nobody@example.com").Return(nil, repository.ErrUserNotFound)
+	mail := mailer.NewMemoryMailer()
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour, usecase.WithMailer(mail))
+
+	require.NoError(t, uc.ResendVerification(context.Background(), "nobody@example.com"))
+	require.NoError(t, uc.ResendVerification(context.Background(), "bob@example.com"))
+	require.NoError(t, uc.ResendVerification(context.Background(), "alice@example.com"))
+	messages := waitForMail(t, mail, 1)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "alice@example.com", messages[0].To)
+}
+
+func TestUpdateUser_NewEmailSendsVerification(t *testing.T) {
+	id := primitive.NewObjectID()
+	users := new(MockUserRepo)
+	users.On("Update", mock.Anything, id.Hex(), mock.Anything).Return(nil)
+	users.On("GetByID", mock.Anything, id.Hex()).Return(&model.User{ID: id, Name: "Alice", Email: "alice@example.org"}, nil).Once()
+	mail := mailer.NewMemoryMailer()
+	uc := usecase.NewUserUsecase(users, repository.NewMemoryTokenStore(), "secret", time.Minute, time.Hour,
+		usecase.WithMailer(mail),
+		usecase.WithEmailVerification(time.Hour, true),
+	)
+
+	require.NoError(t, uc.UpdateUser(context.Background(), id.Hex(), "", "alice@example.org"))
+	require.Len(t, mail.Messages(), 1)
+	assert.Equal(t, "alice@example.org", mail.Messages()[0].To)
+
+	users.On("GetByID", mock.Anything, id.Hex()).Return(&model.User{ID: id, Name: "Alicia", Email: "alice@example.org", Verified: true}, nil)
+	require.NoError(t, uc.UpdateUser(context.Background(), id.Hex(), "Alicia", ""))
+	assert.Len(t, mail.Messages(), 1, "a name change keeps the address verified")
+}
@@ -2,21 +2,42 @@ package utils
 
 import (
 	"errors"
+	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
-func GenerateJWT(userID, secret string) (string, error) {
-	claims := jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-	}
+// TokenSigner signs access token claims. keys.Manager implements it.
+type TokenSigner interface {
+	Sign(claims map[string]interface{}) (string, error)
+}
+
+// TokenVerifier checks an access token and returns its claims.
+type TokenVerifier interface {
+	Verify(token string) (map[string]interface{}, error)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+// GenerateJWT signs an access token for userID. sessionID ties the token to
+// the refresh-token family it was issued with so logout can end both. scopes
+// go into the space-delimited "scope" claim.
+func GenerateJWT(userID, role, sessionID string, scopes []string, signer TokenSigner, ttl time.Duration) (string, error) {
+	now := time.Now()
+	return signer.Sign(map[string]interface{}{
+		"user_id": userID,
+		"role":    role,
+		"sid":     sessionID,
+		"scope":   strings.Join(scopes, " "),
+		"jti":     uuid.New().String(),
+		"iat":     now.Unix(),
+		"nbf":     now.Unix(),
+		"exp":     now.Add(ttl).Unix(),
+	})
 }
 
+// ValidateJWT checks a token signed with the shared HMAC secret, such as the
+// email and MFA tokens. Access tokens go through an auth.Verifier.
 func ValidateJWT(tokenStr, secret string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -34,3 +55,14 @@ func ValidateJWT(tokenStr, secret string) (jwt.MapClaims, error) {
 	}
 	return claims, nil
 }
+
+// ClaimTime reads a NumericDate claim such as "exp" or "iat".
+func ClaimTime(claims map[string]interface{}, key string) time.Time {
+	switch v := claims[key].(type) {
+	case float64:
+		return time.Unix(int64(v), 0)
+	case int64:
+		return time.Unix(v, 0)
+	}
+	return time.Time{}
+}
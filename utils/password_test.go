@@ -0,0 +1,80 @@
+package utils_test
+
+import (
+	"7-solutions/utils"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var cheapArgon2 = utils.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestPasswordHashers(t *testing.T) {
+	hashers := map[string]utils.PasswordHasher{
+		"bcrypt":   utils.NewBcryptHasher(bcrypt.MinCost),
+		"argon2id": utils.NewArgon2idHasher(cheapArgon2),
+	}
+	for name, h := range hashers {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := h.Hash("correct horse battery")
+			require.NoError(t, err)
+
+			ok, err := h.Verify("correct horse battery", encoded)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			ok, err = h.Verify("wrong", encoded)
+			require.NoError(t, err)
+			assert.False(t, ok)
+			assert.False(t, h.NeedsRehash(encoded))
+
+			again, err := h.Hash("correct horse battery")
+			require.NoError(t, err)
+			assert.NotEqual(t, encoded, again, "hashes must be salted")
+		})
+	}
+}
+
+func TestArgon2idHasher_PHCFormat(t *testing.T) {
+	encoded, err := utils.NewArgon2idHasher(cheapArgon2).Hash("secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"), encoded)
+	assert.Len(t, strings.Split(encoded, "$"), 6)
+}
+
+func TestPasswordHasher_NeedsRehash(t *testing.T) {
+	bcryptLow := utils.NewBcryptHasher(bcrypt.MinCost)
+	bcryptHigh := utils.NewBcryptHasher(bcrypt.MinCost + 1)
+	argon := utils.NewArgon2idHasher(cheapArgon2)
+	stronger := cheapArgon2
+	stronger.Iterations = 2
+	argonStronger := utils.NewArgon2idHasher(stronger)
+
+	lowHash, err := bcryptLow.Hash("secret")
+	require.NoError(t, err)
+	argonHash, err := argon.Hash("secret")
+	require.NoError(t, err)
+
+	assert.True(t, bcryptHigh.NeedsRehash(lowHash), "bcrypt cost changed")
+	assert.True(t, argon.NeedsRehash(lowHash), "algorithm changed to argon2id")
+	assert.True(t, bcryptLow.NeedsRehash(argonHash), "algorithm changed to bcrypt")
+	assert.True(t, argonStronger.NeedsRehash(argonHash), "argon2id parameters changed")
+
+	// Either hasher verifies the other's hashes, so switching loses no users.
+	ok, err := argon.Verify("secret", lowHash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = bcryptLow.Verify("secret", argonHash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestPasswordHasher_UnknownFormat(t *testing.T) {
+	h := utils.NewBcryptHasher(bcrypt.MinCost)
+	_, err := h.Verify("secret", "plaintext")
+	assert.ErrorIs(t, err, utils.ErrUnknownHashFormat)
+	_, err = h.Verify("secret", "$argon2id$v=19$broken")
+	assert.Error(t, err)
+}
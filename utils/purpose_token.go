@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Purposes of signed purpose tokens. A token only validates for the purpose
+// it was issued for.
+const (
+	PurposeVerifyEmail = "verify_email"
+	// PurposeMFAPending marks a login whose password was correct and that
+	// still needs a second factor.
+	PurposeMFAPending = "mfa_pending"
+)
+
+// GeneratePurposeToken signs a short-lived token about subject for one
+// purpose, carrying any extra claims. It is keyed separately from access
+// tokens and from every other purpose, so none can stand in for another.
+func GeneratePurposeToken(subject, purpose, secret string, ttl time.Duration, extra map[string]interface{}) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	claims["sub"] = subject
+	claims["purpose"] = purpose
+	// jti keeps tokens issued within the same second distinct.
+	claims["jti"] = uuid.New().String()
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ttl).Unix()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(purposeTokenKey(secret, purpose))
+}
+
+// ValidatePurposeToken returns the subject and claims of a token issued by
+// GeneratePurposeToken for purpose.
+func ValidatePurposeToken(tokenStr, purpose, secret string) (string, map[string]interface{}, error) {
+	claims, err := ValidateJWT(tokenStr, string(purposeTokenKey(secret, purpose)))
+	if err != nil {
+		return "", nil, err
+	}
+	if p, _ := claims["purpose"].(string); p != purpose {
+		return "", nil, errors.New("invalid token purpose")
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return "", nil, errors.New("invalid claims")
+	}
+	return subject, claims, nil
+}
+
+// GenerateEmailToken signs a token binding userID to email for one purpose,
+// such as PurposeVerifyEmail.
+func GenerateEmailToken(userID, email, purpose, secret string, ttl time.Duration) (string, error) {
+	return GeneratePurposeToken(userID, purpose, secret, ttl, map[string]interface{}{"email": email})
+}
+
+// ValidateEmailToken returns the user id and email carried by a token issued
+// by GenerateEmailToken for purpose.
+func ValidateEmailToken(tokenStr, purpose, secret string) (userID, email string, err error) {
+	userID, claims, err := ValidatePurposeToken(tokenStr, purpose, secret)
+	if err != nil {
+		return "", "", err
+	}
+	email, _ = claims["email"].(string)
+	if email == "" {
+		return "", "", errors.New("invalid claims")
+	}
+	return userID, email, nil
+}
+
+func purposeTokenKey(secret, purpose string) []byte {
+	return []byte(purpose + ":" + secret)
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// GenerateOpaqueToken returns a random URL-safe token carrying 256 bits of entropy.
+func GenerateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex SHA-256 of an opaque token so only digests are stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyCredential returns the API key in an "ApiKey <key>" authorization
+// value, else the value of the dedicated API key header. Authorization with
+// another scheme, such as a bearer token, takes precedence over the header.
+func APIKeyCredential(authorization, apiKeyHeader string) string {
+	scheme, key, _ := strings.Cut(authorization, " ")
+	if strings.EqualFold(scheme, "apikey") {
+		return strings.TrimSpace(key)
+	}
+	if authorization != "" {
+		return ""
+	}
+	return strings.TrimSpace(apiKeyHeader)
+}